@@ -2,14 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	zap "go.uber.org/zap"
 
 	config "github.com/adzpm/telegram-clicker/internal/config"
+	leaderboard "github.com/adzpm/telegram-clicker/internal/leaderboard"
 	math "github.com/adzpm/telegram-clicker/internal/math"
+	reload "github.com/adzpm/telegram-clicker/internal/reload"
 	rest "github.com/adzpm/telegram-clicker/internal/rest"
+	shop "github.com/adzpm/telegram-clicker/internal/shop"
 	storage "github.com/adzpm/telegram-clicker/internal/storage"
+	task "github.com/adzpm/telegram-clicker/internal/task"
 )
 
 const (
@@ -26,41 +36,117 @@ func getEnv(key, fallback string) string {
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	err := run(ctx)
+
+	stop()
+
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run serves the game until the context is done, then stops the server and waits for the background jobs,
+// flushes the buffered clicks and closes the storage.
+func run(ctx context.Context) (err error) {
 	var (
-		ctx, cancel = context.WithCancel(context.Background())
-		cfgPath     = getEnv(envClickerConfigPath, defClickerConfigPath)
-		cfg         = config.New()
+		cfgPath = getEnv(envClickerConfigPath, defClickerConfigPath)
 
+		cfg *config.Config
 		lgr *zap.Logger
-		str *storage.Storage
+		str storage.Storage
 		rst *rest.REST
 		mth *math.Math
-		err error
+		shp *shop.Shop
+		tsk *task.Tasks
+		ldb *leaderboard.Leaderboard
 	)
 
-	defer cancel()
-
-	if err = cfg.Read(cfgPath); err != nil {
-		panic(err)
+	if cfg, err = config.Load(cfgPath); err != nil {
+		return err
 	}
 
 	if lgr, err = zap.NewProduction(); err != nil {
-		panic(err)
+		return err
 	}
 
 	defer func() { _ = lgr.Sync() }()
 
-	if str, err = storage.New(lgr, &cfg.Storage); err != nil {
-		panic(err)
+	if str, err = storage.New(lgr, &cfg.Storage, &cfg.GameVariables); err != nil {
+		return err
 	}
 
-	if mth = math.New(&cfg.GameVariables); err != nil {
-		panic(err)
+	// str is the buffered storage by the time it's closed, so the last clicks are flushed
+	defer func() {
+		if closeErr := str.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+
+		lgr.Info("stopped")
+	}()
+
+	if cfg.Storage.ClickFlushInterval > 0 {
+		var (
+			buf                   = storage.NewBuffered(lgr, str, time.Duration(cfg.Storage.ClickFlushInterval)*time.Second)
+			flushCtx, cancelFlush = context.WithCancel(context.Background())
+			flushed               = make(chan struct{})
+		)
+
+		go func() {
+			defer close(flushed)
+			_ = buf.Run(flushCtx)
+		}()
+
+		// the flusher stops after the server, so the clicks of the drained requests get flushed too
+		defer func() {
+			cancelFlush()
+			<-flushed
+		}()
+
+		str = buf
 	}
 
-	rst = rest.New(lgr, str, mth, &cfg.REST)
+	mth = math.New(&cfg.GameVariables)
 
-	if err = rst.Start(ctx); err != nil {
-		panic(err)
+	if shp, err = shop.Read(cfg.GameVariables.ShopPath); err != nil {
+		return err
 	}
+
+	if tsk, err = task.Read(cfg.GameVariables.TasksPath); err != nil {
+		return err
+	}
+
+	ldb = leaderboard.New(lgr, str, time.Duration(cfg.REST.LeaderboardInterval)*time.Second)
+	rst = rest.New(lgr, str, mth, shp, tsk, ldb, &cfg.REST)
+
+	var (
+		wg                sync.WaitGroup
+		jobsCtx, stopJobs = context.WithCancel(ctx)
+	)
+
+	// the background jobs stop before the clicks are flushed and the storage is closed,
+	// also when the server fails to start and ctx isn't done
+	defer func() {
+		stopJobs()
+		wg.Wait()
+	}()
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		_ = ldb.Run(jobsCtx)
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		if err := reload.New(lgr, cfgPath, cfg, mth, str).Run(jobsCtx); err != nil {
+			lgr.Error("config hot reload is disabled", zap.Error(err))
+		}
+	}()
+
+	return rst.Start(ctx)
 }
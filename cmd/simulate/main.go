@@ -0,0 +1,147 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	math "github.com/adzpm/telegram-clicker/internal/math"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+	simulate "github.com/adzpm/telegram-clicker/internal/simulate"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+)
+
+const (
+	envClickerConfigPath = "CLICKER_CONFIG_PATH"
+	defClickerConfigPath = "example.config.yaml"
+)
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run simulates the player with the game variables and the cards of the config and prints the summary.
+// Nothing but the config and the cards file is read, no storage is needed.
+func run(args []string, out io.Writer) (err error) {
+	var (
+		flags = flag.NewFlagSet("simulate", flag.ContinueOnError)
+
+		cfgPath      = flags.String("config", getEnv(envClickerConfigPath, defClickerConfigPath), "path to the config")
+		days         = flags.Float64("days", 7, "number of the simulated days")
+		sample       = flags.Duration("sample", time.Hour, "interval between the samples of the csv")
+		prestigeGain = flags.Float64("prestige-gain", 100, "growth of the investors multiplier in percents to prestige for")
+		csvPath      = flags.String("csv", "", "path to write the samples as csv to")
+
+		cfg   = config.New()
+		cards []storageModel.Card
+	)
+
+	if err = flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !(*days > 0) || *sample < time.Second || !(*prestigeGain >= 0) {
+		return fmt.Errorf("days must be positive, sample at least a second and prestige-gain non-negative")
+	}
+
+	if err = cfg.Read(*cfgPath); err != nil {
+		return err
+	}
+
+	if err = cfg.ReadEnv(); err != nil {
+		return err
+	}
+
+	if err = cfg.GameVariables.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	if cards, err = storage.ReadCardsFile(cfg.GameVariables.CardsPath); err != nil {
+		return err
+	}
+
+	if err = storage.ValidateCards(cards); err != nil {
+		return err
+	}
+
+	slices.SortFunc(cards, func(a, b storageModel.Card) int { return cmp.Compare(a.ID, b.ID) })
+
+	res := simulate.Run(math.New(&cfg.GameVariables), cards, simulate.Options{
+		Duration:       uint64(*days * 86400),
+		SampleInterval: uint64(sample.Seconds()),
+		PrestigeGain:   *prestigeGain,
+	})
+
+	printSummary(out, cards, res)
+
+	if *csvPath == "" {
+		return nil
+	}
+
+	file, err := os.Create(*csvPath)
+	if err != nil {
+		return err
+	}
+
+	if err = simulate.WriteCSV(file, res.Samples); err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
+
+// printSummary prints the time to unlock every card of the sorted cards, the prestiges and the last sample.
+func printSummary(out io.Writer, cards []storageModel.Card, res *simulate.Result) {
+	_, _ = fmt.Fprintln(out, "time to unlock:")
+
+	for _, card := range cards {
+		unlock := "never"
+
+		if at, ok := res.Unlocks[card.ID]; ok {
+			unlock = formatTime(at)
+		}
+
+		_, _ = fmt.Fprintf(out, "  %3d %-40s %s\n", card.ID, card.Name, unlock)
+	}
+
+	firstPrestige := "never"
+
+	if res.Prestiges > 0 {
+		firstPrestige = formatTime(res.FirstPrestige)
+	}
+
+	_, _ = fmt.Fprintf(out, "time to first prestige: %s\n", firstPrestige)
+	_, _ = fmt.Fprintf(out, "prestiges: %d\n", res.Prestiges)
+
+	if len(res.Samples) == 0 {
+		return
+	}
+
+	last := res.Samples[len(res.Samples)-1]
+
+	_, _ = fmt.Fprintf(out, "at %s: %s coins, %.6g coins/sec, %d investors, %d cards, %d levels\n",
+		formatTime(last.Time), last.Coins, last.CoinsPerSecond, last.Investors, last.Cards, last.Levels)
+}
+
+// formatTime formats the seconds from the start.
+func formatTime(seconds uint64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
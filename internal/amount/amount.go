@@ -0,0 +1,351 @@
+package amount
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	stdmath "math"
+	"math/big"
+	"math/bits"
+	"strings"
+
+	gorm "gorm.io/gorm"
+	schema "gorm.io/gorm/schema"
+)
+
+// digits is the number of decimal digits of Max, the stored amounts are padded to it.
+const digits = 78
+
+var (
+	// Zero is the zero amount, the same as the zero value.
+	Zero = Amount{}
+	// Max is the largest amount, 2^256 - 1. The saturating arithmetic stops on it.
+	Max = Amount{w: [4]uint64{stdmath.MaxUint64, stdmath.MaxUint64, stdmath.MaxUint64, stdmath.MaxUint64}}
+
+	ErrOverflow = errors.New("amount overflows")
+	ErrInvalid  = errors.New("invalid amount")
+
+	maxBig = Max.Big()
+)
+
+type (
+	// Amount is a non-negative integer number of coins up to Max. Amounts are immutable comparable values,
+	// the arithmetic saturates on Max and zero instead of wrapping, the Checked methods report the overflow.
+	// JSON holds amounts as decimal strings, the databases as numeric or zero padded text columns,
+	// so they are ordered as numbers in both.
+	Amount struct {
+		// w holds the 64-bit words of the number, the least significant first.
+		w [4]uint64
+	}
+)
+
+// New creates the amount of the number.
+func New(v uint64) Amount {
+	return Amount{w: [4]uint64{v}}
+}
+
+// Parse parses the decimal amount. Leading zeros are allowed, signs and fractions are not.
+func Parse(s string) (a Amount, err error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return Zero, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+
+	v, _ := new(big.Int).SetString(s, 10)
+
+	if a, ok := FromBig(v); ok {
+		return a, nil
+	}
+
+	return Max, fmt.Errorf("%w: %q", ErrOverflow, s)
+}
+
+// FromBig converts the integer to the amount. The negative integers saturate to zero and the large ones to Max,
+// ok is false in both cases.
+func FromBig(v *big.Int) (a Amount, ok bool) {
+	switch {
+	case v.Sign() < 0:
+		return Zero, false
+	case v.Cmp(maxBig) > 0:
+		return Max, false
+	}
+
+	for i, word := range v.Bits() {
+		// big.Word is 32 bits wide on 32-bit platforms
+		if bits.UintSize == 32 {
+			a.w[i/2] |= uint64(word) << (32 * (i % 2))
+		} else {
+			a.w[i] = uint64(word)
+		}
+	}
+
+	return a, true
+}
+
+// FromFloat converts the number to the amount dropping the fraction. NaN and negative numbers saturate to zero,
+// the numbers above Max saturate to Max.
+func FromFloat(f float64) Amount {
+	switch {
+	case stdmath.IsNaN(f) || f < 1:
+		return Zero
+	case f < 1<<64:
+		return New(uint64(f))
+	case f >= 0x1p256:
+		return Max
+	}
+
+	v, _ := big.NewFloat(f).Int(nil)
+	a, _ := FromBig(v)
+
+	return a
+}
+
+// Big returns the amount as a new big integer.
+func (a Amount) Big() *big.Int {
+	v := new(big.Int)
+
+	for i := len(a.w) - 1; i >= 0; i-- {
+		v.Lsh(v, 64).Or(v, new(big.Int).SetUint64(a.w[i]))
+	}
+
+	return v
+}
+
+// Uint64 returns the amount as a number, saturating to math.MaxUint64.
+func (a Amount) Uint64() uint64 {
+	if a.w[1] != 0 || a.w[2] != 0 || a.w[3] != 0 {
+		return stdmath.MaxUint64
+	}
+
+	return a.w[0]
+}
+
+// Float64 returns the nearest float of the amount.
+func (a Amount) Float64() (f float64) {
+	for i := len(a.w) - 1; i >= 0; i-- {
+		f = f*0x1p64 + float64(a.w[i])
+	}
+
+	return f
+}
+
+// IsZero reports whether the amount is zero.
+func (a Amount) IsZero() bool {
+	return a == Zero
+}
+
+// Cmp compares the amounts and returns -1 if a < b, 0 if a == b and +1 if a > b.
+func (a Amount) Cmp(b Amount) int {
+	for i := len(a.w) - 1; i >= 0; i-- {
+		switch {
+		case a.w[i] < b.w[i]:
+			return -1
+		case a.w[i] > b.w[i]:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// Add returns a + b saturating to Max.
+func (a Amount) Add(b Amount) Amount {
+	if sum, ok := a.CheckedAdd(b); ok {
+		return sum
+	}
+
+	return Max
+}
+
+// CheckedAdd returns a + b, ok is false if the sum overflows.
+func (a Amount) CheckedAdd(b Amount) (sum Amount, ok bool) {
+	var carry uint64
+
+	for i := range a.w {
+		sum.w[i], carry = bits.Add64(a.w[i], b.w[i], carry)
+	}
+
+	return sum, carry == 0
+}
+
+// Sub returns a - b saturating to zero.
+func (a Amount) Sub(b Amount) Amount {
+	if diff, ok := a.CheckedSub(b); ok {
+		return diff
+	}
+
+	return Zero
+}
+
+// CheckedSub returns a - b, ok is false if b is greater than a.
+func (a Amount) CheckedSub(b Amount) (diff Amount, ok bool) {
+	var borrow uint64
+
+	for i := range a.w {
+		diff.w[i], borrow = bits.Sub64(a.w[i], b.w[i], borrow)
+	}
+
+	return diff, borrow == 0
+}
+
+// Mul returns a * n saturating to Max.
+func (a Amount) Mul(n uint64) Amount {
+	if product, ok := a.CheckedMul(n); ok {
+		return product
+	}
+
+	return Max
+}
+
+// CheckedMul returns a * n, ok is false if the product overflows.
+func (a Amount) CheckedMul(n uint64) (product Amount, ok bool) {
+	var carry uint64
+
+	for i := range a.w {
+		hi, lo := bits.Mul64(a.w[i], n)
+
+		var c uint64
+
+		product.w[i], c = bits.Add64(lo, carry, 0)
+		carry = hi + c
+	}
+
+	return product, carry == 0
+}
+
+// MulFloat returns a * f dropping the fraction and saturating to zero and Max.
+func (a Amount) MulFloat(f float64) Amount {
+	switch {
+	case stdmath.IsNaN(f) || f <= 0:
+		return Zero
+	case stdmath.IsInf(f, 1):
+		if a.IsZero() {
+			return Zero
+		}
+
+		return Max
+	case a.Uint64() < 1<<53 && f < 1<<10:
+		// both fit in the float mantissa, the common case doesn't need big numbers
+		return FromFloat(float64(a.w[0]) * f)
+	}
+
+	v, _ := new(big.Float).SetPrec(512).Mul(new(big.Float).SetPrec(512).SetInt(a.Big()), big.NewFloat(f)).Int(nil)
+	product, _ := FromBig(v)
+
+	return product
+}
+
+// Div returns a / n dropping the remainder. It panics if n is zero.
+func (a Amount) Div(n uint64) (quo Amount) {
+	var rem uint64
+
+	for i := len(a.w) - 1; i >= 0; i-- {
+		quo.w[i], rem = bits.Div64(rem, a.w[i], n)
+	}
+
+	return quo
+}
+
+// String returns the decimal amount.
+func (a Amount) String() string {
+	if a.w[1] == 0 && a.w[2] == 0 && a.w[3] == 0 {
+		return fmt.Sprint(a.w[0])
+	}
+
+	return a.Big().String()
+}
+
+// MarshalJSON encodes the amount as a decimal string, javascript numbers lose precision above 2^53.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + a.String() + `"`), nil
+}
+
+// UnmarshalJSON decodes the amount from a decimal string or a json number,
+// the numbers may have an exponent to write large amounts in the catalogs.
+func (a *Amount) UnmarshalJSON(data []byte) (err error) {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+
+		if s, err = unquote(data); err != nil {
+			return err
+		}
+
+		*a, err = Parse(s)
+
+		return err
+	}
+
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if *a, err = Parse(string(data)); !errors.Is(err, ErrInvalid) {
+		return err
+	}
+
+	f, _, err := big.ParseFloat(string(data), 10, 512, big.ToZero)
+	if err != nil || f.Sign() < 0 || !f.IsInt() {
+		return fmt.Errorf("%w: %s", ErrInvalid, data)
+	}
+
+	v, _ := f.Int(nil)
+
+	if *a, _ = FromBig(v); v.Cmp(maxBig) > 0 {
+		return fmt.Errorf("%w: %s", ErrOverflow, data)
+	}
+
+	return nil
+}
+
+// Value stores the amount as a decimal padded with zeros to the same length, so the text columns
+// are ordered as numbers.
+func (a Amount) Value() (driver.Value, error) {
+	s := a.String()
+
+	return strings.Repeat("0", digits-len(s)) + s, nil
+}
+
+// Scan reads the amount from the numeric, text or integer column.
+func (a *Amount) Scan(src interface{}) (err error) {
+	switch v := src.(type) {
+	case nil:
+		*a = Zero
+	case string:
+		*a, err = Parse(v)
+	case []byte:
+		*a, err = Parse(string(v))
+	case int64:
+		if v < 0 {
+			return fmt.Errorf("%w: %d", ErrInvalid, v)
+		}
+
+		*a = New(uint64(v))
+	default:
+		return fmt.Errorf("%w: unsupported type %T", ErrInvalid, src)
+	}
+
+	return err
+}
+
+// GormDataType is the generic data type of the amount columns.
+func (Amount) GormDataType() string {
+	return "amount"
+}
+
+// GormDBDataType stores the amounts in numeric columns in postgres and in text columns elsewhere.
+func (Amount) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return fmt.Sprintf("numeric(%d,0)", digits)
+	}
+
+	return "text"
+}
+
+// unquote returns the content of the json string, the amounts never need escapes.
+func unquote(data []byte) (string, error) {
+	if len(data) < 2 || data[len(data)-1] != '"' {
+		return "", fmt.Errorf("%w: %s", ErrInvalid, data)
+	}
+
+	return string(data[1 : len(data)-1]), nil
+}
@@ -0,0 +1,191 @@
+package amount
+
+import (
+	"encoding/json"
+	stdmath "math"
+	"strings"
+	"testing"
+)
+
+const maxString = "115792089237316195423570985008687907853269984665640564039457584007913129639935"
+
+func mustParse(t *testing.T, s string) Amount {
+	t.Helper()
+
+	a, err := Parse(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return a
+}
+
+func TestParse(t *testing.T) {
+	testCases := map[string]struct {
+		input    string
+		expected string
+		err      error
+	}{
+		"zero":           {"0", "0", nil},
+		"padded":         {"000042", "42", nil},
+		"uint64 max":     {"18446744073709551615", "18446744073709551615", nil},
+		"above uint64":   {"18446744073709551616", "18446744073709551616", nil},
+		"max":            {maxString, maxString, nil},
+		"above max":      {maxString[:len(maxString)-1] + "6", maxString, ErrOverflow},
+		"empty":          {"", "0", ErrInvalid},
+		"negative":       {"-1", "0", ErrInvalid},
+		"fraction":       {"1.5", "0", ErrInvalid},
+		"not a number":   {"coins", "0", ErrInvalid},
+		"padded to max":  {strings.Repeat("0", 100) + "7", "7", nil},
+		"thousand digit": {"1" + strings.Repeat("0", 1000), maxString, ErrOverflow},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			a, err := Parse(tc.input)
+
+			if (tc.err == nil) != (err == nil) || (tc.err != nil && !strings.Contains(err.Error(), tc.err.Error())) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+
+			if a.String() != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, a)
+			}
+		})
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	var (
+		one       = New(1)
+		uint64Max = New(stdmath.MaxUint64)
+		carried   = mustParse(t, "18446744073709551616")
+	)
+
+	testCases := map[string]struct {
+		result   Amount
+		expected Amount
+	}{
+		"add":                  {New(2).Add(New(3)), New(5)},
+		"add carries":          {uint64Max.Add(one), carried},
+		"add saturates":        {Max.Add(one), Max},
+		"sub":                  {New(5).Sub(New(3)), New(2)},
+		"sub borrows":          {carried.Sub(one), uint64Max},
+		"sub saturates":        {New(3).Sub(New(5)), Zero},
+		"mul":                  {New(6).Mul(7), New(42)},
+		"mul carries":          {uint64Max.Mul(2), carried.Add(uint64Max).Sub(one)},
+		"mul saturates":        {Max.Mul(2), Max},
+		"mul by zero":          {Max.Mul(0), Zero},
+		"mul float":            {New(100).MulFloat(1.5), New(150)},
+		"mul float truncates":  {New(3).MulFloat(0.5), New(1)},
+		"mul float big":        {carried.MulFloat(2), carried.Mul(2)},
+		"mul float saturates":  {Max.MulFloat(1.5), Max},
+		"mul float negative":   {New(10).MulFloat(-1), Zero},
+		"mul float infinity":   {New(10).MulFloat(stdmath.Inf(1)), Max},
+		"mul float nan":        {New(10).MulFloat(stdmath.NaN()), Zero},
+		"div":                  {New(42).Div(5), New(8)},
+		"div big":              {carried.Div(2), New(1 << 63)},
+		"div max":              {Max.Div(1), Max},
+		"div remainder":        {Max.Div(stdmath.MaxUint64).Mul(stdmath.MaxUint64), Max},
+		"from float":           {FromFloat(41.9), New(41)},
+		"from float big":       {FromFloat(0x1p64), carried},
+		"from float saturates": {FromFloat(1e100), Max},
+		"from float negative":  {FromFloat(-1), Zero},
+		"from float nan":       {FromFloat(stdmath.NaN()), Zero},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if tc.result != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, tc.result)
+			}
+		})
+	}
+
+	if _, ok := Max.CheckedAdd(one); ok {
+		t.Errorf("expected add overflow")
+	}
+
+	if _, ok := Zero.CheckedSub(one); ok {
+		t.Errorf("expected sub overflow")
+	}
+
+	if _, ok := Max.CheckedMul(2); ok {
+		t.Errorf("expected mul overflow")
+	}
+
+	if Max.Uint64() != stdmath.MaxUint64 || carried.Float64() != 0x1p64 || Max.Cmp(carried) != 1 || one.Cmp(carried) != -1 {
+		t.Errorf("unexpected conversion or comparison")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	testCases := map[string]struct {
+		input    string
+		expected string
+		valid    bool
+	}{
+		"string":          {`"123"`, "123", true},
+		"number":          {`123`, "123", true},
+		"exponent":        {`1e30`, "1" + strings.Repeat("0", 30), true},
+		"max":             {`"` + maxString + `"`, maxString, true},
+		"null":            {`null`, "0", true},
+		"fraction":        {`1.5`, "0", false},
+		"negative":        {`-5`, "0", false},
+		"negative string": {`"-5"`, "0", false},
+		"exponent max":    {`1e80`, "0", false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var res struct {
+				Coins Amount `json:"coins"`
+			}
+
+			if err := json.Unmarshal([]byte(`{"coins":`+tc.input+`}`), &res); (err == nil) != tc.valid {
+				t.Fatalf("expected valid %v, got %v", tc.valid, err)
+			}
+
+			if tc.valid && res.Coins.String() != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, res.Coins)
+			}
+		})
+	}
+
+	data, err := json.Marshal(map[string]Amount{"coins": Max})
+	if err != nil || string(data) != `{"coins":"`+maxString+`"}` {
+		t.Errorf("unexpected json %s %v", data, err)
+	}
+}
+
+func TestValue(t *testing.T) {
+	var prev string
+
+	// the padded values are ordered as the amounts
+	for _, a := range []Amount{Zero, New(9), New(10), New(stdmath.MaxUint64), FromFloat(1e40), Max} {
+		value, err := a.Value()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		s := value.(string)
+
+		if len(s) != digits || s <= prev {
+			t.Errorf("expected %d digits ordered after %q, got %q", digits, prev, s)
+		}
+
+		var scanned Amount
+
+		if err = scanned.Scan([]byte(s)); err != nil || scanned != a {
+			t.Errorf("expected %s, got %s %v", a, scanned, err)
+		}
+
+		prev = s
+	}
+
+	var scanned Amount
+
+	if err := scanned.Scan(int64(42)); err != nil || scanned != New(42) {
+		t.Errorf("expected 42, got %s %v", scanned, err)
+	}
+}
@@ -1,30 +1,82 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 
 	yaml "gopkg.in/yaml.v3"
 )
 
+const (
+	DriverMemory   = "memory"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+
+	// envPrefix starts the names of the environment variables overriding the config.
+	envPrefix = "CLICKER"
+)
+
 type (
 	REST struct {
-		Host    string `yaml:"host"`
-		Port    string `yaml:"port"`
-		WebPath string `yaml:"web_path"`
+		Host         string `yaml:"host"`
+		Port         string `yaml:"port"`
+		WebPath      string `yaml:"web_path"`
+		BotToken     string `yaml:"bot_token"`
+		AuthMaxAge   uint64 `yaml:"auth_max_age"`
+		LegacyRoutes bool   `yaml:"legacy_routes"`
+
+		// ShutdownTimeout is the number of seconds the in-flight requests are waited for on shutdown.
+		ShutdownTimeout uint64 `yaml:"shutdown_timeout"`
+		// LeaderboardInterval is the number of seconds between the recomputations of the leaderboards.
+		LeaderboardInterval uint64 `yaml:"leaderboard_interval"`
 	}
 
 	Storage struct {
+		Driver string `yaml:"driver"`
 		Host   string `yaml:"host"`
 		Port   string `yaml:"port"`
 		DBName string `yaml:"db_name"`
 		DBUser string `yaml:"db_user"`
 		DBPass string `yaml:"db_pass"`
+		Path   string `yaml:"path"`
+
+		// ClickFlushInterval is the number of seconds the clicks are buffered in memory
+		// before they are written to the storage. Zero writes every click through.
+		ClickFlushInterval uint64 `yaml:"click_flush_interval"`
 	}
 
 	GameVariables struct {
-		CardsPath              string  `yaml:"cards_path"`
-		EarnedCoinsForInvestor uint64  `yaml:"earned_coins_for_investor"`
-		PercentsForInvestor    float64 `yaml:"percents_for_investor"`
+		CardsPath              string      `yaml:"cards_path"`
+		RetireRemovedCards     bool        `yaml:"retire_removed_cards"`
+		ShopPath               string      `yaml:"shop_path"`
+		TasksPath              string      `yaml:"tasks_path"`
+		EarnedCoinsForInvestor uint64      `yaml:"earned_coins_for_investor"`
+		PercentsForInvestor    float64     `yaml:"percents_for_investor"`
+		MaxOfflineTime         uint64      `yaml:"max_offline_time"`
+		Milestones             []Milestone `yaml:"milestones"`
+		Referral               Referral    `yaml:"referral"`
+	}
+
+	// Milestone is the effect applied when a card reaches the next multiple of its upgrade level.
+	// Milestones are applied in order and start over when the list ends. Zero values mean no effect.
+	Milestone struct {
+		CoinsMultiplier   float64 `yaml:"coins_multiplier"`
+		TimeoutMultiplier float64 `yaml:"timeout_multiplier"`
+	}
+
+	// Referral is the reward for inviting the friends: the bonuses both sides get once and the share
+	// of the coins the friend earns afterwards which the referrer gets, 0.05 is 5%.
+	Referral struct {
+		ReferrerCoins uint64  `yaml:"referrer_coins"`
+		ReferrerGold  uint64  `yaml:"referrer_gold"`
+		RefereeCoins  uint64  `yaml:"referee_coins"`
+		RefereeGold   uint64  `yaml:"referee_gold"`
+		Percents      float64 `yaml:"percents"`
 	}
 
 	Config struct {
@@ -34,8 +86,37 @@ type (
 	}
 )
 
-// New creates a new Config instance.
-func New() *Config { return &Config{} }
+// New creates a new Config instance filled with the defaults.
+func New() *Config {
+	return &Config{
+		REST: REST{
+			Port:                "8080",
+			WebPath:             "web",
+			AuthMaxAge:          86400,
+			ShutdownTimeout:     10,
+			LeaderboardInterval: 60,
+		},
+		Storage: Storage{
+			Driver: DriverPostgres,
+			Host:   "127.0.0.1",
+			Port:   "5432",
+			Path:   "clicker.db",
+		},
+		GameVariables: GameVariables{
+			CardsPath:              "cards.json",
+			ShopPath:               "shop.json",
+			TasksPath:              "tasks.json",
+			EarnedCoinsForInvestor: 5000000,
+			PercentsForInvestor:    0.02,
+			MaxOfflineTime:         10800,
+			Referral: Referral{
+				ReferrerGold: 100,
+				RefereeGold:  50,
+				Percents:     0.05,
+			},
+		},
+	}
+}
 
 // Read loads the configuration from the given path.
 func (c *Config) Read(path string) (err error) {
@@ -51,3 +132,134 @@ func (c *Config) Read(path string) (err error) {
 
 	return nil
 }
+
+// Load reads the config from the file over the defaults, overrides it with the environment variables and validates it.
+func Load(path string) (_ *Config, err error) {
+	c := New()
+
+	if err = c.Read(path); err != nil {
+		return nil, err
+	}
+
+	if err = c.ReadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err = c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return c, nil
+}
+
+// ReadEnv overrides the fields with the environment variables named after their yaml paths,
+// e.g. CLICKER_STORAGE_DB_PASS overrides storage.db_pass. Strings are taken as is,
+// other values are parsed as yaml: CLICKER_GAME_VARIABLES_MILESTONES='[{coins_multiplier: 2}]'.
+func (c *Config) ReadEnv() (err error) {
+	return readEnv(reflect.ValueOf(c).Elem(), envPrefix, os.LookupEnv)
+}
+
+// readEnv overrides the fields of the struct with the variables found by lookup.
+func readEnv(v reflect.Value, prefix string, lookup func(key string) (string, bool)) (err error) {
+	for i := 0; i < v.NumField(); i++ {
+		var (
+			field = v.Field(i)
+			name  = prefix + "_" + strings.ToUpper(v.Type().Field(i).Tag.Get("yaml"))
+		)
+
+		if field.Kind() == reflect.Struct {
+			if err = readEnv(field, name, lookup); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+
+		if field.Kind() == reflect.String {
+			field.SetString(value)
+
+			continue
+		}
+
+		if err = yaml.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that the required values are set and the values are in range.
+// Returns all the problems found at once.
+func (c *Config) Validate() (err error) {
+	var errs []error
+
+	check := func(ok bool, field, problem string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s %s", field, problem))
+		}
+	}
+
+	check(validPort(c.REST.Port), "rest.port", "must be a port number")
+	check(c.REST.WebPath != "", "rest.web_path", "is required")
+	check(c.REST.BotToken != "", "rest.bot_token", "is required")
+	check(c.REST.LeaderboardInterval > 0, "rest.leaderboard_interval", "must be greater than zero")
+
+	switch c.Storage.Driver {
+	case DriverMemory:
+	case DriverPostgres, "":
+		check(c.Storage.Host != "", "storage.host", "is required")
+		check(validPort(c.Storage.Port), "storage.port", "must be a port number")
+		check(c.Storage.DBName != "", "storage.db_name", "is required")
+		check(c.Storage.DBUser != "", "storage.db_user", "is required")
+	case DriverSQLite:
+		check(c.Storage.Path != "", "storage.path", "is required")
+	default:
+		check(false, "storage.driver", fmt.Sprintf("must be one of %s, %s, %s", DriverMemory, DriverPostgres, DriverSQLite))
+	}
+
+	return errors.Join(append(errs, c.GameVariables.Validate())...)
+}
+
+// Validate checks the game variables alone, for the tools which don't serve the game.
+func (gv *GameVariables) Validate() (err error) {
+	var errs []error
+
+	check := func(ok bool, field, problem string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s %s", field, problem))
+		}
+	}
+
+	check(gv.CardsPath != "", "game_variables.cards_path", "is required")
+	check(gv.ShopPath != "", "game_variables.shop_path", "is required")
+	check(gv.TasksPath != "", "game_variables.tasks_path", "is required")
+	check(gv.EarnedCoinsForInvestor > 0, "game_variables.earned_coins_for_investor", "must be greater than zero")
+	check(validFactor(gv.PercentsForInvestor), "game_variables.percents_for_investor", "must be a non-negative number")
+
+	check(validFactor(gv.Referral.Percents) && gv.Referral.Percents <= 1, "game_variables.referral.percents", "must be a number from 0 to 1")
+
+	for i, milestone := range gv.Milestones {
+		check(validFactor(milestone.CoinsMultiplier), fmt.Sprintf("game_variables.milestones[%d].coins_multiplier", i), "must be a non-negative number")
+		check(validFactor(milestone.TimeoutMultiplier), fmt.Sprintf("game_variables.milestones[%d].timeout_multiplier", i), "must be a non-negative number")
+	}
+
+	return errors.Join(errs...)
+}
+
+// validPort reports whether the port is a number from 1 to 65535.
+func validPort(port string) bool {
+	n, err := strconv.ParseUint(port, 10, 16)
+
+	return err == nil && n > 0
+}
+
+// validFactor reports whether the factor is a finite number which isn't negative.
+func validFactor(f float64) bool {
+	return f >= 0 && !math.IsInf(f, 0)
+}
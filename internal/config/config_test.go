@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+rest:
+  port: 9090
+  bot_token: file-token
+storage:
+  driver: sqlite
+  db_pass: file-pass
+game_variables:
+  earned_coins_for_investor: 100
+`
+
+// newTestConfig reads the test config from the file.
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := New()
+
+	if err := cfg.Read(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return cfg
+}
+
+func TestReadPrecedence(t *testing.T) {
+	t.Setenv("CLICKER_REST_BOT_TOKEN", "env-token")
+	t.Setenv("CLICKER_STORAGE_DB_PASS", "p#ss: word")
+	t.Setenv("CLICKER_STORAGE_CLICK_FLUSH_INTERVAL", "5")
+	t.Setenv("CLICKER_GAME_VARIABLES_PERCENTS_FOR_INVESTOR", "0.5")
+	t.Setenv("CLICKER_GAME_VARIABLES_MILESTONES", "[{coins_multiplier: 2}, {timeout_multiplier: 0.5}]")
+
+	cfg := newTestConfig(t)
+
+	if err := cfg.ReadEnv(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := map[string]struct {
+		expected interface{}
+		result   interface{}
+	}{
+		"default":           {"web", cfg.REST.WebPath},
+		"default number":    {uint64(10800), cfg.GameVariables.MaxOfflineTime},
+		"file":              {"9090", cfg.REST.Port},
+		"file number":       {uint64(100), cfg.GameVariables.EarnedCoinsForInvestor},
+		"file over default": {DriverSQLite, cfg.Storage.Driver},
+		"env over file":     {"env-token", cfg.REST.BotToken},
+		"env string as is":  {"p#ss: word", cfg.Storage.DBPass},
+		"env number":        {uint64(5), cfg.Storage.ClickFlushInterval},
+		"env float":         {0.5, cfg.GameVariables.PercentsForInvestor},
+		"env list":          {[]Milestone{{CoinsMultiplier: 2}, {TimeoutMultiplier: 0.5}}, cfg.GameVariables.Milestones},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if !reflect.DeepEqual(tc.expected, tc.result) {
+				t.Errorf("expected %v, got %v", tc.expected, tc.result)
+			}
+		})
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReadEnvInvalid(t *testing.T) {
+	t.Setenv("CLICKER_REST_AUTH_MAX_AGE", "day")
+
+	if err := New().ReadEnv(); err == nil || !strings.Contains(err.Error(), "CLICKER_REST_AUTH_MAX_AGE") {
+		t.Errorf("expected error naming the variable, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := map[string]struct {
+		change func(cfg *Config)
+		errors []string
+	}{
+		"valid":             {func(cfg *Config) {}, nil},
+		"memory":            {func(cfg *Config) { cfg.Storage = Storage{Driver: DriverMemory} }, nil},
+		"no bot token":      {func(cfg *Config) { cfg.REST.BotToken = "" }, []string{"rest.bot_token is required"}},
+		"bad port":          {func(cfg *Config) { cfg.REST.Port = "80800" }, []string{"rest.port must be a port number"}},
+		"no postgres user":  {func(cfg *Config) { cfg.Storage.DBUser, cfg.Storage.DBName = "", "" }, []string{"storage.db_name is required", "storage.db_user is required"}},
+		"no sqlite path":    {func(cfg *Config) { cfg.Storage = Storage{Driver: DriverSQLite} }, []string{"storage.path is required"}},
+		"unknown driver":    {func(cfg *Config) { cfg.Storage.Driver = "mysql" }, []string{"storage.driver must be one of"}},
+		"zero coins":        {func(cfg *Config) { cfg.GameVariables.EarnedCoinsForInvestor = 0 }, []string{"game_variables.earned_coins_for_investor must be greater than zero"}},
+		"negative percents": {func(cfg *Config) { cfg.GameVariables.PercentsForInvestor = -1 }, []string{"game_variables.percents_for_investor"}},
+		"negative milestone": {func(cfg *Config) {
+			cfg.GameVariables.Milestones = []Milestone{{}, {TimeoutMultiplier: -0.5}}
+		}, []string{"game_variables.milestones[1].timeout_multiplier"}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := New()
+			cfg.REST.BotToken = "token"
+			cfg.Storage.DBName, cfg.Storage.DBUser = "clicker", "clicker"
+
+			tc.change(cfg)
+
+			err := cfg.Validate()
+			if len(tc.errors) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected errors %q, got nil", tc.errors)
+			}
+
+			if lines := strings.Split(err.Error(), "\n"); len(lines) != len(tc.errors) {
+				t.Errorf("expected %d errors, got %q", len(tc.errors), lines)
+			}
+
+			for _, expected := range tc.errors {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected error %q, got %q", expected, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+)
+
+const (
+	// BoardEarnedCoins ranks the users by the coins earned in all the runs.
+	BoardEarnedCoins = "earned_coins"
+	// BoardInvestors ranks the users by the current investors.
+	BoardInvestors = "investors"
+	// BoardDailyCoins ranks the users by the coins earned today, UTC.
+	BoardDailyCoins = "daily_coins"
+
+	secondsPerDay = 86400
+
+	// top is the number of the best users kept on every board.
+	top = 1000
+)
+
+var (
+	ErrUnknownBoard = errors.New("unknown leaderboard")
+
+	// boards are the rankings of the users by board, zero scores aren't ranked.
+	boards = map[string]storage.Ranking{
+		BoardEarnedCoins: storage.RankingLifetimeCoins,
+		BoardInvestors:   storage.RankingInvestors,
+		BoardDailyCoins:  storage.RankingDailyCoins,
+	}
+)
+
+type (
+	// Leaderboard ranks the users on the boards. The best users of every board are selected periodically
+	// and the pages are served from the last ranking, so only the best users are listed.
+	// Only the rank of a user below them is counted on request.
+	Leaderboard struct {
+		lgr      *zap.Logger
+		str      storage.Storage
+		interval time.Duration
+		top      uint64
+		ranking  atomic.Pointer[ranking]
+	}
+
+	// Entry is the place of the user on the board. The users with equal scores share the rank.
+	Entry struct {
+		Rank       uint64
+		TelegramID uint64
+		Score      amount.Amount
+	}
+
+	// Page is the part of the board with the entry of the user, which is nil if the user isn't ranked.
+	// Total counts the listed users, at most the top of the board, the entry of the user can rank below them.
+	Page struct {
+		Entries   []Entry
+		Total     uint64
+		Own       *Entry
+		UpdatedAt uint64
+	}
+
+	// ranking is the boards computed at once.
+	ranking struct {
+		boards    map[string]*board
+		day       uint64
+		updatedAt uint64
+	}
+
+	// board is the best entries ordered by rank and their indexes by the telegram ids.
+	board struct {
+		entries []Entry
+		byUser  map[uint64]int
+	}
+)
+
+// New creates the leaderboard recomputed every interval, empty until the first Refresh.
+func New(lgr *zap.Logger, str storage.Storage, interval time.Duration) *Leaderboard {
+	return &Leaderboard{lgr: lgr, str: str, interval: interval, top: top}
+}
+
+// Run refreshes the ranking at once and then every interval until the context is done.
+// The failed refreshes are logged and the previous ranking is served until the next one.
+func (l *Leaderboard) Run(ctx context.Context) (err error) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		if err = l.Refresh(time.Now()); err != nil {
+			l.lgr.Error("error while refreshing the leaderboard", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh selects the best users of every board at now.
+func (l *Leaderboard) Refresh(now time.Time) (err error) {
+	var (
+		day = uint64(now.Unix()) / secondsPerDay
+		res = &ranking{boards: make(map[string]*board, len(boards)), day: day, updatedAt: uint64(now.Unix())}
+	)
+
+	for name, rnk := range boards {
+		if res.boards[name], err = l.newBoard(rnk, day); err != nil {
+			return err
+		}
+	}
+
+	l.ranking.Store(res)
+
+	l.lgr.Debug("leaderboard refreshed", zap.Int("users", len(res.boards[BoardEarnedCoins].entries)))
+
+	return nil
+}
+
+// Page returns limit entries of the board from offset and the entry of the user.
+func (l *Leaderboard) Page(name string, telegramID, offset, limit uint64) (_ *Page, err error) {
+	rnk, ok := boards[name]
+	if !ok {
+		return nil, ErrUnknownBoard
+	}
+
+	page := &Page{Entries: []Entry{}}
+
+	res := l.ranking.Load()
+	if res == nil {
+		return page, nil
+	}
+
+	b := res.boards[name]
+
+	page.Total = uint64(len(b.entries))
+	page.UpdatedAt = res.updatedAt
+
+	if count := uint64(len(b.entries)); offset < count {
+		page.Entries = slices.Clone(b.entries[offset : offset+min(limit, count-offset)])
+	}
+
+	if i, ok := b.byUser[telegramID]; ok {
+		own := b.entries[i]
+		page.Own = &own
+
+		return page, nil
+	}
+
+	if page.Own, err = l.rank(rnk, res.day, telegramID); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// newBoard ranks the best users with the scores above zero, the highest first and the older users first on ties.
+func (l *Leaderboard) newBoard(rnk storage.Ranking, day uint64) (_ *board, err error) {
+	var (
+		users []storageModel.User
+		b     = &board{byUser: make(map[uint64]int)}
+	)
+
+	if users, err = l.str.SelectRanked(rnk, day, l.top); err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		entry := Entry{Rank: uint64(i + 1), TelegramID: users[i].TelegramID, Score: rnk.Score(&users[i], day)}
+
+		if i > 0 && entry.Score == b.entries[i-1].Score {
+			entry.Rank = b.entries[i-1].Rank
+		}
+
+		b.entries = append(b.entries, entry)
+		b.byUser[entry.TelegramID] = i
+	}
+
+	return b, nil
+}
+
+// rank returns the entry of the user below the best ones, ranked after the users with the higher scores,
+// or nil if the user isn't ranked.
+func (l *Leaderboard) rank(rnk storage.Ranking, day, telegramID uint64) (_ *Entry, err error) {
+	var (
+		user  *storageModel.User
+		above uint64
+	)
+
+	user, err = l.str.SelectUser(telegramID)
+	if errors.Is(err, storage.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	score := rnk.Score(user, day)
+	if score.IsZero() {
+		return nil, nil
+	}
+
+	if above, err = l.str.CountRanked(rnk, day, score); err != nil {
+		return nil, err
+	}
+
+	return &Entry{Rank: above + 1, TelegramID: telegramID, Score: score}, nil
+}
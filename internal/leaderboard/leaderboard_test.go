@@ -0,0 +1,235 @@
+package leaderboard
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+)
+
+// testNow is the second day from the unix epoch.
+var testNow = time.Unix(secondsPerDay+100, 0)
+
+// newTestLeaderboard creates the leaderboard of the memory storage with the users earning coins:
+// 1 and 3 tie on the earned coins, 2 earned its coins the day before and 4 earned nothing.
+func newTestLeaderboard(t *testing.T) *Leaderboard {
+	t.Helper()
+
+	str := storage.NewMemory(zap.NewNop(), nil)
+
+	for _, user := range []struct {
+		telegramID uint64
+		investors  uint64
+		coins      uint64
+		day        uint64
+	}{
+		{1, 5, 100, 1},
+		{2, 0, 300, 0},
+		{3, 7, 100, 1},
+		{4, 0, 0, 1},
+	} {
+		if _, err := str.InsertUser(user.telegramID, amount.Zero, 0, user.investors); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := str.EarnCoins(user.telegramID, amount.New(user.coins), user.day*secondsPerDay); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	return New(zap.NewNop(), str, time.Minute)
+}
+
+func TestPage(t *testing.T) {
+	ldb := newTestLeaderboard(t)
+
+	// nothing is ranked before the first refresh
+	if page, err := ldb.Page(BoardEarnedCoins, 1, 0, 10); err != nil || page.Total != 0 || page.Own != nil || len(page.Entries) != 0 {
+		t.Fatalf("expected empty page, got %+v %v", page, err)
+	}
+
+	if err := ldb.Refresh(testNow); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := map[string]struct {
+		board      string
+		telegramID uint64
+		offset     uint64
+		limit      uint64
+		entries    []Entry
+		total      uint64
+		own        *Entry
+	}{
+		"earned coins": {BoardEarnedCoins, 3, 0, 10, []Entry{
+			{1, 2, amount.New(300)}, {2, 1, amount.New(100)}, {2, 3, amount.New(100)},
+		}, 3, &Entry{2, 3, amount.New(100)}},
+		"page":                 {BoardEarnedCoins, 2, 1, 1, []Entry{{2, 1, amount.New(100)}}, 3, &Entry{1, 2, amount.New(300)}},
+		"offset after the end": {BoardEarnedCoins, 1, 5, 10, []Entry{}, 3, &Entry{2, 1, amount.New(100)}},
+		"investors": {BoardInvestors, 2, 0, 10, []Entry{
+			{1, 3, amount.New(7)}, {2, 1, amount.New(5)},
+		}, 2, nil},
+		"daily coins": {BoardDailyCoins, 4, 0, 10, []Entry{
+			{1, 1, amount.New(100)}, {1, 3, amount.New(100)},
+		}, 2, nil},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			page, err := ldb.Page(tc.board, tc.telegramID, tc.offset, tc.limit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tc.entries, page.Entries) {
+				t.Errorf("expected entries %+v, got %+v", tc.entries, page.Entries)
+			}
+
+			if page.Total != tc.total || page.UpdatedAt != uint64(testNow.Unix()) {
+				t.Errorf("expected %d ranked at %d, got %d at %d", tc.total, testNow.Unix(), page.Total, page.UpdatedAt)
+			}
+
+			if !reflect.DeepEqual(tc.own, page.Own) {
+				t.Errorf("expected own entry %+v, got %+v", tc.own, page.Own)
+			}
+		})
+	}
+
+	if _, err := ldb.Page("unknown", 1, 0, 10); err != ErrUnknownBoard {
+		t.Errorf("expected %v, got %v", ErrUnknownBoard, err)
+	}
+}
+
+func TestTies(t *testing.T) {
+	var (
+		str = storage.NewMemory(zap.NewNop(), nil)
+		ldb = New(zap.NewNop(), str, time.Minute)
+	)
+
+	// the users are inserted out of the telegram ids order, the older ones come first on ties
+	for _, user := range []struct {
+		telegramID uint64
+		coins      uint64
+	}{
+		{9, 50}, {7, 50}, {8, 50}, {5, 10}, {6, 10},
+	} {
+		if _, err := str.InsertUser(user.telegramID, amount.New(user.coins), 0, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	ldb.top = 2
+
+	if err := ldb.Refresh(testNow); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := map[string]struct {
+		telegramID uint64
+		offset     uint64
+		entries    []Entry
+		own        *Entry
+	}{
+		"best":              {7, 0, []Entry{{1, 9, amount.New(50)}, {1, 7, amount.New(50)}}, &Entry{1, 7, amount.New(50)}},
+		"tie below the top": {8, 0, []Entry{{1, 9, amount.New(50)}, {1, 7, amount.New(50)}}, &Entry{1, 8, amount.New(50)}},
+		"below the top":     {6, 1, []Entry{{1, 7, amount.New(50)}}, &Entry{4, 6, amount.New(10)}},
+		"after the top":     {5, 2, []Entry{}, &Entry{4, 5, amount.New(10)}},
+		"unknown user":      {1, 0, []Entry{{1, 9, amount.New(50)}, {1, 7, amount.New(50)}}, nil},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			page, err := ldb.Page(BoardEarnedCoins, tc.telegramID, tc.offset, 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tc.entries, page.Entries) {
+				t.Errorf("expected entries %+v, got %+v", tc.entries, page.Entries)
+			}
+
+			if page.Total != 2 {
+				t.Errorf("expected 2 listed, got %d", page.Total)
+			}
+
+			if !reflect.DeepEqual(tc.own, page.Own) {
+				t.Errorf("expected own entry %+v, got %+v", tc.own, page.Own)
+			}
+		})
+	}
+}
+
+func TestTop(t *testing.T) {
+	var (
+		str = storage.NewMemory(zap.NewNop(), nil)
+		ldb = New(zap.NewNop(), str, time.Minute)
+	)
+
+	// the user 1 has the least coins and ranks below the top
+	for i := uint64(1); i <= top+5; i++ {
+		if _, err := str.InsertUser(i, amount.New(i), 0, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := ldb.Refresh(testNow); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := map[string]struct {
+		offset  uint64
+		entries []Entry
+	}{
+		"last of the top": {top - 1, []Entry{{top, 6, amount.New(6)}}},
+		"after the top":   {top, []Entry{}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			page, err := ldb.Page(BoardEarnedCoins, 1, tc.offset, 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tc.entries, page.Entries) {
+				t.Errorf("expected entries %+v, got %+v", tc.entries, page.Entries)
+			}
+
+			if page.Total != top {
+				t.Errorf("expected %d listed, got %d", top, page.Total)
+			}
+
+			if own := (&Entry{top + 5, 1, amount.New(1)}); !reflect.DeepEqual(own, page.Own) {
+				t.Errorf("expected own entry %+v, got %+v", own, page.Own)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		ldb         = newTestLeaderboard(t)
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error, 1)
+	)
+
+	go func() { done <- ldb.Run(ctx) }()
+
+	// the ranking is refreshed at once
+	for deadline := time.Now().Add(5 * time.Second); ldb.ranking.Load() == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the leaderboard to be refreshed on start")
+		}
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+package math
+
+import (
+	"fmt"
+	stdmath "math"
+
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+// polynomialExactSteps is the number of the polynomial values added one by one before the sum is approximated.
+const polynomialExactSteps = 32
+
+const (
+	FormulaLinear     = "linear"
+	FormulaGeometric  = "geometric"
+	FormulaPolynomial = "polynomial"
+	FormulaPiecewise  = "piecewise"
+)
+
+type (
+	// Formula is the progression of a card value by level. The value of the level 1 is the base,
+	// steps count the levels from it: step 0 is the level 1, step 1 is the level 2 and so on.
+	Formula interface {
+		// Value returns the value on the step.
+		Value(base float64, step uint64) float64
+		// Sum returns the total of count values starting from the step.
+		Sum(base float64, step, count uint64) float64
+		// MaxCount returns how many values starting from the step fit in total, but not more than limit.
+		MaxCount(base float64, step, limit uint64, total float64) uint64
+	}
+
+	// Linear adds the base on every level: base * level.
+	Linear struct{}
+
+	// Geometric multiplies the value by Multiplier on every level: base * multiplier^step.
+	Geometric struct {
+		Multiplier float64
+	}
+
+	// Polynomial raises the level to Exponent: base * level^exponent. The sums have no exact closed form,
+	// they are approximated within the float precision.
+	Polynomial struct {
+		Exponent float64
+	}
+
+	// Piecewise switches the formulas by level. Every piece applies from its step until the next piece,
+	// the first piece starts from the step 0. The formulas get the steps as is, not from the piece start.
+	Piecewise struct {
+		Pieces []Piece
+	}
+
+	// Piece is the formula applied to the steps from From.
+	Piece struct {
+		From    uint64
+		Formula Formula
+	}
+)
+
+// NewFormula creates the formula described by the card formula.
+func NewFormula(spec *storageModel.Formula) (_ Formula, err error) {
+	switch spec.Type {
+	case FormulaLinear:
+		return Linear{}, nil
+	case FormulaGeometric:
+		if !(spec.Multiplier > 0) || stdmath.IsInf(spec.Multiplier, 0) {
+			return nil, fmt.Errorf("geometric formula: multiplier must be greater than zero")
+		}
+
+		return Geometric{Multiplier: spec.Multiplier}, nil
+	case FormulaPolynomial:
+		if !(spec.Exponent > 0) || stdmath.IsInf(spec.Exponent, 0) {
+			return nil, fmt.Errorf("polynomial formula: exponent must be greater than zero")
+		}
+
+		return Polynomial{Exponent: spec.Exponent}, nil
+	case FormulaPiecewise:
+		return newPiecewise(spec.Pieces)
+	default:
+		return nil, fmt.Errorf("unknown formula type: %q", spec.Type)
+	}
+}
+
+// newPiecewise creates the piecewise formula from the pieces ordered by their first levels.
+func newPiecewise(pieces []storageModel.FormulaPiece) (_ Formula, err error) {
+	if len(pieces) == 0 {
+		return nil, fmt.Errorf("piecewise formula: pieces are required")
+	}
+
+	res := Piecewise{Pieces: make([]Piece, len(pieces))}
+
+	for i, piece := range pieces {
+		switch {
+		case i == 0 && piece.FromLevel > 1:
+			return nil, fmt.Errorf("piecewise formula: the first piece must start from level 1")
+		case i > 0 && piece.FromLevel <= pieces[i-1].FromLevel:
+			return nil, fmt.Errorf("piecewise formula: piece %d must start after the previous one", i)
+		}
+
+		if res.Pieces[i].Formula, err = NewFormula(&piece.Formula); err != nil {
+			return nil, fmt.Errorf("piecewise formula: piece %d: %w", i, err)
+		}
+
+		res.Pieces[i].From = max(piece.FromLevel, 1) - 1
+	}
+
+	return res, nil
+}
+
+// CoinsFormula returns the coins per click progression of the card, linear if the card has none.
+func CoinsFormula(card *storageModel.Card) (_ Formula, err error) {
+	if card.CoinsFormula == nil {
+		return Linear{}, nil
+	}
+
+	return NewFormula(card.CoinsFormula)
+}
+
+// PriceFormula returns the price progression of the card, geometric with the price multiplier if the card has none.
+func PriceFormula(card *storageModel.Card) (_ Formula, err error) {
+	if card.PriceFormula == nil {
+		return Geometric{Multiplier: card.PriceMultiplier}, nil
+	}
+
+	return NewFormula(card.PriceFormula)
+}
+
+func (Linear) Value(base float64, step uint64) float64 {
+	return base * float64(step+1)
+}
+
+func (Linear) Sum(base float64, step, count uint64) float64 {
+	// arithmetic series of the levels step+1 ... step+count
+	return base * float64(count) * (float64(step+1) + float64(step+count)) / 2
+}
+
+func (f Linear) MaxCount(base float64, step, limit uint64, total float64) uint64 {
+	if base == 0 {
+		return limit
+	}
+
+	// inverse of the series sum: total / base = count^2 / 2 + count * (step + 1/2)
+	var (
+		b     = float64(step) + 0.5
+		count = toCount(stdmath.Sqrt(b*b+2*total/base)-b, limit)
+	)
+
+	return fitCount(f, base, step, limit, total, count)
+}
+
+func (f Geometric) Value(base float64, step uint64) float64 {
+	return base * stdmath.Pow(f.Multiplier, float64(step))
+}
+
+func (f Geometric) Sum(base float64, step, count uint64) float64 {
+	if count == 0 {
+		return 0
+	}
+
+	if f.Multiplier == 1 {
+		return base * float64(count)
+	}
+
+	return f.Value(base, step) * (stdmath.Pow(f.Multiplier, float64(count)) - 1) / (f.Multiplier - 1)
+}
+
+func (f Geometric) MaxCount(base float64, step, limit uint64, total float64) uint64 {
+	var count uint64
+
+	switch {
+	case base == 0:
+		return limit
+	case f.Multiplier == 1:
+		count = toCount(total/base, limit)
+	default:
+		// inverse of the series sum: total = first * (m^n - 1) / (m - 1)
+		x := total * (f.Multiplier - 1) / f.Value(base, step)
+
+		// the shrinking values never add up to the total
+		if x <= -1 {
+			return limit
+		}
+
+		count = toCount(stdmath.Log1p(x)/stdmath.Log(f.Multiplier), limit)
+	}
+
+	return fitCount(f, base, step, limit, total, count)
+}
+
+func (f Polynomial) Value(base float64, step uint64) float64 {
+	return base * stdmath.Pow(float64(step+1), f.Exponent)
+}
+
+func (f Polynomial) Sum(base float64, step, count uint64) (sum float64) {
+	// the first levels and the short runs are added as is, the approximation is precise for the rest
+	for ; count > 0 && (step < polynomialExactSteps || count <= polynomialExactSteps); step, count = step+1, count-1 {
+		sum += f.Value(base, step)
+	}
+
+	if count == 0 {
+		return sum
+	}
+
+	return sum + base*powerSum(float64(step+1), float64(count-1), f.Exponent)
+}
+
+func (f Polynomial) MaxCount(base float64, step, limit uint64, total float64) uint64 {
+	if base == 0 {
+		return limit
+	}
+
+	return searchCount(f, base, step, limit, total)
+}
+
+func (f Piecewise) Value(base float64, step uint64) float64 {
+	return f.Pieces[f.piece(step)].Formula.Value(base, step)
+}
+
+func (f Piecewise) Sum(base float64, step, count uint64) (sum float64) {
+	for count > 0 {
+		var (
+			i = f.piece(step)
+			n = min(count, f.length(i, step))
+		)
+
+		sum += f.Pieces[i].Formula.Sum(base, step, n)
+		step, count = step+n, count-n
+	}
+
+	return sum
+}
+
+func (f Piecewise) MaxCount(base float64, step, limit uint64, total float64) (count uint64) {
+	var (
+		start = step
+		left  = total
+	)
+
+	for count < limit {
+		var (
+			i = f.piece(step)
+			n = min(limit-count, f.length(i, step))
+			k = f.Pieces[i].Formula.MaxCount(base, step, n, left)
+		)
+
+		count += k
+
+		if k < n {
+			break
+		}
+
+		left -= f.Pieces[i].Formula.Sum(base, step, k)
+		step += k
+	}
+
+	return fitCount(f, base, start, limit, total, count)
+}
+
+// piece returns the index of the piece applied on the step.
+func (f Piecewise) piece(step uint64) (i int) {
+	for i+1 < len(f.Pieces) && f.Pieces[i+1].From <= step {
+		i++
+	}
+
+	return i
+}
+
+// length returns the number of steps from the step to the end of the piece.
+func (f Piecewise) length(i int, step uint64) uint64 {
+	if i+1 == len(f.Pieces) {
+		return stdmath.MaxUint64 - step
+	}
+
+	return f.Pieces[i+1].From - step
+}
+
+// toCount converts the count calculated in floats, which may be out of the uint64 range, capping it by limit.
+func toCount(f float64, limit uint64) uint64 {
+	if f >= float64(limit) {
+		return limit
+	}
+
+	return uint64(max(f, 0))
+}
+
+// fitCount fixes the float rounding errors of the count calculated in closed form,
+// so the sum of count values fits in total and the next value doesn't.
+func fitCount(f Formula, base float64, step, limit uint64, total float64, count uint64) uint64 {
+	count = min(count, limit)
+
+	for count > 0 && f.Sum(base, step, count) > total {
+		count--
+	}
+
+	for count < limit && f.Sum(base, step, count+1) <= total {
+		count++
+	}
+
+	return count
+}
+
+// searchCount finds the count by the binary search on the sums, for the formulas without the inverse of the sum.
+func searchCount(f Formula, base float64, step, limit uint64, total float64) (count uint64) {
+	for hi := limit; count < hi; {
+		if mid := count + (hi-count-1)/2 + 1; f.Sum(base, step, mid) <= total {
+			count = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return count
+}
+
+// powerSum returns the sum of k^p for k from a to a+d by the Euler-Maclaurin formula:
+// the integral, the mean of the ends and the corrections by the odd derivatives. The error falls
+// with the a^-6 order, so the small a are left to the callers.
+func powerSum(a, d, p float64) float64 {
+	integral := powDiff(a, d, p+1) / (p + 1)
+
+	if stdmath.IsInf(integral, 0) || stdmath.IsNaN(integral) {
+		return stdmath.Inf(1)
+	}
+
+	return integral +
+		(stdmath.Pow(a, p)+stdmath.Pow(a+d, p))/2 +
+		p/12*powDiff(a, d, p-1) -
+		p*(p-1)*(p-2)/720*powDiff(a, d, p-3) +
+		p*(p-1)*(p-2)*(p-3)*(p-4)/30240*powDiff(a, d, p-5)
+}
+
+// powDiff returns (a+d)^q - a^q without losing the precision when d is small against a.
+func powDiff(a, d, q float64) float64 {
+	return stdmath.Pow(a, q) * stdmath.Expm1(q*stdmath.Log1p(d/a))
+}
@@ -0,0 +1,210 @@
+package math
+
+import (
+	stdmath "math"
+	"testing"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+// testPiecewise is linear until the level 10 and geometric after it.
+var testPiecewise = Piecewise{Pieces: []Piece{
+	{From: 0, Formula: Linear{}},
+	{From: 9, Formula: Geometric{Multiplier: 1.5}},
+}}
+
+func TestFormulaValue(t *testing.T) {
+	testCases := map[string]struct {
+		formula  Formula
+		base     float64
+		step     uint64
+		expected float64
+	}{
+		"linear / step 0":      {Linear{}, 10, 0, 10},
+		"linear / step 4":      {Linear{}, 10, 4, 50},
+		"geometric / step 0":   {Geometric{Multiplier: 2}, 10, 0, 10},
+		"geometric / step 3":   {Geometric{Multiplier: 2}, 10, 3, 80},
+		"polynomial / step 0":  {Polynomial{Exponent: 2}, 10, 0, 10},
+		"polynomial / step 2":  {Polynomial{Exponent: 2}, 10, 2, 90},
+		"piecewise / linear":   {testPiecewise, 10, 8, 90},
+		"piecewise / switched": {testPiecewise, 10, 9, 10 * stdmath.Pow(1.5, 9)},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if result := tc.formula.Value(tc.base, tc.step); stdmath.Abs(result-tc.expected) > 1e-9 {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestFormulaSumAndMaxCount(t *testing.T) {
+	testCases := map[string]Formula{
+		"linear":           Linear{},
+		"geometric":        Geometric{Multiplier: 1.25},
+		"geometric / flat": Geometric{Multiplier: 1},
+		"geometric / down": Geometric{Multiplier: 0.5},
+		"polynomial":       Polynomial{Exponent: 1.5},
+		"piecewise":        testPiecewise,
+	}
+
+	for name, formula := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for _, step := range []uint64{0, 5, 20} {
+				var (
+					sum   float64
+					count uint64
+				)
+
+				// the values added one by one are the reference for the closed forms
+				for i := uint64(0); i < 30; i++ {
+					if result := formula.Sum(7, step, i); stdmath.Abs(result-sum) > 1e-6*max(sum, 1) {
+						t.Errorf("step %d: expected sum of %d values %v, got %v", step, i, sum, result)
+					}
+
+					sum += formula.Value(7, step+i)
+				}
+
+				for _, total := range []float64{0, 6, 7, 100, 1000, 100000} {
+					for count = 0; count < 1000 && formula.Sum(7, step, count+1) <= total; count++ {
+					}
+
+					if result := formula.MaxCount(7, step, 1000, total); result != count {
+						t.Errorf("step %d / total %v: expected %d, got %d", step, total, count, result)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewFormula(t *testing.T) {
+	testCases := map[string]struct {
+		spec  storageModel.Formula
+		valid bool
+	}{
+		"linear":                  {storageModel.Formula{Type: FormulaLinear}, true},
+		"geometric":               {storageModel.Formula{Type: FormulaGeometric, Multiplier: 1.1}, true},
+		"geometric / no mp":       {storageModel.Formula{Type: FormulaGeometric}, false},
+		"polynomial":              {storageModel.Formula{Type: FormulaPolynomial, Exponent: 2}, true},
+		"polynomial / negative":   {storageModel.Formula{Type: FormulaPolynomial, Exponent: -1}, false},
+		"piecewise / no pieces":   {storageModel.Formula{Type: FormulaPiecewise}, false},
+		"unknown":                 {storageModel.Formula{Type: "exponential"}, false},
+		"piecewise":               {storageModel.Formula{Type: FormulaPiecewise, Pieces: testPieces(1, 10)}, true},
+		"piecewise / late start":  {storageModel.Formula{Type: FormulaPiecewise, Pieces: testPieces(2, 10)}, false},
+		"piecewise / unordered":   {storageModel.Formula{Type: FormulaPiecewise, Pieces: testPieces(1, 10, 10)}, false},
+		"piecewise / invalid one": {storageModel.Formula{Type: FormulaPiecewise, Pieces: append(testPieces(1), storageModel.FormulaPiece{FromLevel: 5, Formula: storageModel.Formula{Type: FormulaGeometric}})}, false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewFormula(&tc.spec); (err == nil) != tc.valid {
+				t.Errorf("expected valid %v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+// testPieces returns the linear pieces starting from the levels.
+func testPieces(levels ...uint64) (pieces []storageModel.FormulaPiece) {
+	for _, level := range levels {
+		pieces = append(pieces, storageModel.FormulaPiece{FromLevel: level, Formula: storageModel.Formula{Type: FormulaLinear}})
+	}
+
+	return pieces
+}
+
+func TestCalculateCard(t *testing.T) {
+	var (
+		mth  = New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02})
+		card = &storageModel.Card{
+			Price:           amount.New(100),
+			PriceMultiplier: 2,
+			CoinsPerClick:   amount.New(3),
+			CoinsFormula:    &storageModel.Formula{Type: FormulaPolynomial, Exponent: 2},
+			PriceFormula:    &storageModel.Formula{Type: FormulaLinear},
+		}
+		plain = &storageModel.Card{Price: amount.New(100), PriceMultiplier: 2, CoinsPerClick: amount.New(3)}
+	)
+
+	testCases := map[string]struct {
+		result   uint64
+		expected uint64
+	}{
+		"coins / level 0":         {mth.CalculateCardCoinsPerClick(card, 0, 1).Uint64(), 0},
+		"coins / level 3":         {mth.CalculateCardCoinsPerClick(card, 3, 2).Uint64(), 54},
+		"coins / default":         {mth.CalculateCardCoinsPerClick(plain, 3, 2).Uint64(), 18},
+		"price / level 2":         {mth.CalculateCardUpgradePrice(card, 2).Uint64(), 300},
+		"price / default":         {mth.CalculateCardUpgradePrice(plain, 2).Uint64(), 400},
+		"price sum":               {mth.CalculateCardUpgradePriceSum(card, 1, 3).Uint64(), 900},
+		"price sum / default":     {mth.CalculateCardUpgradePriceSum(plain, 1, 3).Uint64(), 1400},
+		"max upgrades":            {mth.CalculateCardMaxUpgrades(card, 1, 10, amount.New(899)), 2},
+		"max upgrades / default":  {mth.CalculateCardMaxUpgrades(plain, 1, 10, amount.New(1400)), 3},
+		"max upgrades / by limit": {mth.CalculateCardMaxUpgrades(card, 1, 1, amount.New(899)), 1},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if tc.result != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, tc.result)
+			}
+		})
+	}
+}
+
+// loopSum adds the values one by one, the formula sums are checked and benchmarked against it.
+func loopSum(f Formula, base float64, step, count uint64) (sum float64) {
+	for i := uint64(0); i < count; i++ {
+		sum += f.Value(base, step+i)
+	}
+
+	return sum
+}
+
+func FuzzPolynomialSum(f *testing.F) {
+	f.Add(1.5, uint32(0), uint32(30))
+	f.Add(2.0, uint32(31), uint32(33))
+	f.Add(0.5, uint32(1000), uint32(100000))
+	f.Add(7.0, uint32(100000), uint32(1))
+	f.Add(0.01, uint32(40), uint32(5000))
+
+	f.Fuzz(func(t *testing.T, exponent float64, step, count uint32) {
+		if !(exponent > 0 && exponent <= 10) || count > 1<<20 {
+			t.Skip()
+		}
+
+		var (
+			formula  = Polynomial{Exponent: exponent}
+			expected = loopSum(formula, 3, uint64(step), uint64(count))
+			result   = formula.Sum(3, uint64(step), uint64(count))
+		)
+
+		if stdmath.Abs(result-expected) > expected*1e-9 {
+			t.Errorf("expected %v, got %v", expected, result)
+		}
+
+		if total := result / 2; formula.Sum(3, uint64(step), formula.MaxCount(3, uint64(step), uint64(count), total)) > total {
+			t.Errorf("expected the max count to fit in %v", total)
+		}
+	})
+}
+
+// BenchmarkPolynomialSum compares the approximated sum with the loop on the thousand levels.
+func BenchmarkPolynomialSum(b *testing.B) {
+	formula := Polynomial{Exponent: 2.5}
+
+	b.Run("closed form", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			formula.Sum(7, 0, 1000)
+		}
+	})
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			loopSum(formula, 7, 0, 1000)
+		}
+	})
+}
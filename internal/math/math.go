@@ -1,70 +1,243 @@
 package math
 
 import (
+	stdmath "math"
+	"sync/atomic"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
 	config "github.com/adzpm/telegram-clicker/internal/config"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
 )
 
+// MaxInvestors is the largest number of investors, 2^53. The investors multiplier is a float64,
+// which holds every integer up to it exactly, so the capped investors convert to it without rounding.
+const MaxInvestors = 1 << 53
+
 type (
+	// Math calculates the game values with the game variables, which can be swapped at runtime.
 	Math struct {
-		config *config.GameVariables
+		config atomic.Pointer[config.GameVariables]
 	}
 )
 
-func New(cfg *config.GameVariables) *Math { return &Math{cfg} }
+func New(cfg *config.GameVariables) *Math {
+	m := &Math{}
+	m.config.Store(cfg)
+
+	return m
+}
 
 // CalculateGeometricCoinsPerClick calculates the number of coins per click based on the level.
-func (m *Math) CalculateGeometricCoinsPerClick(startCoins, level uint64, coinsMultiplier, investorsMultiplier float64) uint64 {
+func (m *Math) CalculateGeometricCoinsPerClick(startCoins amount.Amount, level uint64, coinsMultiplier, investorsMultiplier float64) amount.Amount {
+	return m.calculateCoinsPerClick(Geometric{Multiplier: coinsMultiplier}, startCoins, level, investorsMultiplier)
+}
+
+// CalculateAlgebraCoinsPerClick calculates the number of coins per click based on the level.
+func (m *Math) CalculateAlgebraCoinsPerClick(startCoins amount.Amount, level uint64, investorsMultiplier float64) amount.Amount {
+	return m.calculateCoinsPerClick(Linear{}, startCoins, level, investorsMultiplier)
+}
+
+// CalculateUpgradePrice calculates the price of the upgrade to the next level in closed form.
+// The calculations in this file saturate to amount.Max instead of overflowing.
+func (m *Math) CalculateUpgradePrice(startPrice amount.Amount, level uint64, priceMultiplier float64) amount.Amount {
+	return amount.FromFloat(Geometric{Multiplier: priceMultiplier}.Value(startPrice.Float64(), level))
+}
+
+// CalculateUpgradePriceSum calculates the total price of count upgrades starting from the level.
+// The prices form a geometric series, so the sum is calculated in closed form.
+func (m *Math) CalculateUpgradePriceSum(startPrice amount.Amount, level, count uint64, priceMultiplier float64) amount.Amount {
+	return amount.FromFloat(Geometric{Multiplier: priceMultiplier}.Sum(startPrice.Float64(), level, count))
+}
+
+// CalculateMaxUpgrades calculates how many upgrades starting from the level can be bought for the coins,
+// but not more than limit.
+func (m *Math) CalculateMaxUpgrades(startPrice amount.Amount, level, limit uint64, coins amount.Amount, priceMultiplier float64) uint64 {
+	return Geometric{Multiplier: priceMultiplier}.MaxCount(startPrice.Float64(), level, limit, coins.Float64())
+}
+
+// CalculateCardCoinsPerClick calculates the number of coins per click of the card on the level
+// by the card coins formula.
+func (m *Math) CalculateCardCoinsPerClick(card *storageModel.Card, level uint64, multiplier float64) amount.Amount {
+	return m.calculateCoinsPerClick(m.coinsFormula(card), card.CoinsPerClick, level, multiplier)
+}
+
+// CalculateCardUpgradePrice calculates the price of the card upgrade to the next level by the card price formula.
+func (m *Math) CalculateCardUpgradePrice(card *storageModel.Card, level uint64) amount.Amount {
+	return amount.FromFloat(m.priceFormula(card).Value(card.Price.Float64(), level))
+}
+
+// CalculateCardUpgradePriceSum calculates the total price of count card upgrades starting from the level.
+func (m *Math) CalculateCardUpgradePriceSum(card *storageModel.Card, level, count uint64) amount.Amount {
+	return amount.FromFloat(m.priceFormula(card).Sum(card.Price.Float64(), level, count))
+}
+
+// CalculateCardMaxUpgrades calculates how many card upgrades starting from the level can be bought for the coins,
+// but not more than limit. The float calculation may round the coins up, so the count is checked with the exact sum.
+func (m *Math) CalculateCardMaxUpgrades(card *storageModel.Card, level, limit uint64, coins amount.Amount) (count uint64) {
+	count = m.priceFormula(card).MaxCount(card.Price.Float64(), level, limit, coins.Float64())
+
+	for count > 0 && m.CalculateCardUpgradePriceSum(card, level, count).Cmp(coins) > 0 {
+		count--
+	}
+
+	return count
+}
+
+// calculateCoinsPerClick calculates the number of coins per click on the level, there are none on the level 0.
+func (m *Math) calculateCoinsPerClick(f Formula, startCoins amount.Amount, level uint64, multiplier float64) amount.Amount {
 	if level == 0 {
+		return amount.Zero
+	}
+
+	return amount.FromFloat(f.Value(startCoins.Float64(), level-1) * multiplier)
+}
+
+// coinsFormula returns the coins formula of the card. The cards are validated on load,
+// so an invalid formula falls back to the default one instead of failing the request.
+func (m *Math) coinsFormula(card *storageModel.Card) Formula {
+	f, err := CoinsFormula(card)
+	if err != nil {
+		return Linear{}
+	}
+
+	return f
+}
+
+// priceFormula returns the price formula of the card, the default one if the card formula is invalid.
+func (m *Math) priceFormula(card *storageModel.Card) Formula {
+	f, err := PriceFormula(card)
+	if err != nil {
+		return Geometric{Multiplier: card.PriceMultiplier}
+	}
+
+	return f
+}
+
+// CalculateInvestorsCount calculates the number of investors based on the earned coins, capped by MaxInvestors.
+func (m *Math) CalculateInvestorsCount(earnedCoins amount.Amount) uint64 {
+	return min(earnedCoins.Div(m.GetGameVariables().EarnedCoinsForInvestor).Uint64(), MaxInvestors)
+}
+
+// CalculateInvestorsMultiplier calculates the multiplier for the investors, capped by MaxInvestors.
+func (m *Math) CalculateInvestorsMultiplier(investors uint64) float64 {
+	return 1 + float64(min(investors, MaxInvestors))*m.GetGameVariables().PercentsForInvestor
+}
+
+// CalculateOfflineTime calculates the offline time in seconds, capped by the max offline time.
+func (m *Math) CalculateOfflineTime(lastSeen, now uint64) uint64 {
+	if now <= lastSeen {
+		return 0
+	}
+
+	return min(now-lastSeen, m.GetGameVariables().MaxOfflineTime)
+}
+
+// CalculateOfflineClicks calculates the number of clicks a card could make between from and to,
+// taking into account that the card is not clickable before nextClick.
+func (m *Math) CalculateOfflineClicks(from, to, nextClick, clickTimeout uint64) uint64 {
+	start := max(from, nextClick)
+
+	if start >= to {
 		return 0
 	}
 
-	coinsPerClick := float64(startCoins)
-	for i := uint64(1); i < level; i++ {
-		coinsPerClick *= coinsMultiplier
+	return (to - start) / max(clickTimeout, 1)
+}
+
+// CalculateMilestones calculates the number of milestones the card reached on the level.
+func (m *Math) CalculateMilestones(level, upgradeLevel uint64) uint64 {
+	if upgradeLevel == 0 {
+		return 0
 	}
 
-	return uint64(coinsPerClick * investorsMultiplier)
+	return level / upgradeLevel
 }
 
-// CalculateAlgebraCoinsPerClick calculates the number of coins per click based on the level.
-func (m *Math) CalculateAlgebraCoinsPerClick(startCoins, level uint64, investorsMultiplier float64) uint64 {
-	if level == 0 {
+// CalculateNextMilestoneLevel calculates the level of the next milestone of the card.
+func (m *Math) CalculateNextMilestoneLevel(level, upgradeLevel uint64) uint64 {
+	if upgradeLevel == 0 {
 		return 0
 	}
 
-	coinsPerClick := startCoins
-	for i := uint64(1); i < level; i++ {
-		coinsPerClick += startCoins
+	return (level/upgradeLevel + 1) * upgradeLevel
+}
+
+// CalculateMilestoneMultipliers calculates the coins and click timeout multipliers of the reached milestones.
+func (m *Math) CalculateMilestoneMultipliers(milestones uint64) (coinsMultiplier, timeoutMultiplier float64) {
+	coinsMultiplier, timeoutMultiplier = 1, 1
+
+	list := m.GetGameVariables().Milestones
+
+	if len(list) == 0 {
+		return coinsMultiplier, timeoutMultiplier
+	}
+
+	// the list repeats, so the multipliers are the powers of the whole list products
+	// times the products of the milestones reached after the last repetition
+	var (
+		cycles                   = float64(milestones / uint64(len(list)))
+		rest                     = milestones % uint64(len(list))
+		cycleCoins, cycleTimeout = 1.0, 1.0
+	)
+
+	for i, milestone := range list {
+		coins, timeout := positiveOrOne(milestone.CoinsMultiplier), positiveOrOne(milestone.TimeoutMultiplier)
+
+		cycleCoins, cycleTimeout = cycleCoins*coins, cycleTimeout*timeout
+
+		if uint64(i) < rest {
+			coinsMultiplier, timeoutMultiplier = coinsMultiplier*coins, timeoutMultiplier*timeout
+		}
 	}
 
-	return uint64(float64(coinsPerClick) * investorsMultiplier)
+	return coinsMultiplier * stdmath.Pow(cycleCoins, cycles), timeoutMultiplier * stdmath.Pow(cycleTimeout, cycles)
 }
 
-// CalculateUpgradePrice calculates the price of the upgrade to the next level.
-func (m *Math) CalculateUpgradePrice(startPrice, level uint64, priceMultiplier float64) uint64 {
-	if level < 1 {
-		return startPrice
+// positiveOrOne returns the multiplier, the unset ones don't change anything.
+func positiveOrOne(multiplier float64) float64 {
+	if multiplier > 0 {
+		return multiplier
 	}
 
-	upgradePrice := float64(startPrice)
-	for i := uint64(1); i <= level; i++ {
-		upgradePrice *= priceMultiplier
+	return 1
+}
+
+// CalculateClickTimeout calculates the click timeout with the milestone multiplier, but not less than a second.
+func (m *Math) CalculateClickTimeout(clickTimeout uint64, timeoutMultiplier float64) uint64 {
+	if clickTimeout == 0 {
+		return 0
 	}
 
-	return uint64(upgradePrice)
+	return max(toCount(float64(clickTimeout)*timeoutMultiplier, stdmath.MaxUint64), 1)
 }
 
-// CalculateInvestorsCount calculates the number of investors based on the earned coins.
-func (m *Math) CalculateInvestorsCount(earnedCoins uint64) uint64 {
-	return earnedCoins / m.config.EarnedCoinsForInvestor
+// CalculateCardMilestoneMultipliers calculates the coins and click timeout multipliers of the milestones
+// the card reached on the level.
+func (m *Math) CalculateCardMilestoneMultipliers(card *storageModel.Card, level uint64) (coinsMultiplier, timeoutMultiplier float64) {
+	return m.CalculateMilestoneMultipliers(m.CalculateMilestones(level, card.UpgradeLevel))
 }
 
-// CalculateInvestorsMultiplier calculates the multiplier for the investors.
-func (m *Math) CalculateInvestorsMultiplier(investors uint64) float64 {
-	return 1 + float64(investors)*m.config.PercentsForInvestor
+// CalculateCardClickCoins calculates the coins per click of the card on the level with the investors,
+// the milestones and the purchased coinsMultiplier bonuses.
+func (m *Math) CalculateCardClickCoins(card *storageModel.Card, level, investors uint64, coinsMultiplier float64) amount.Amount {
+	milestoneMultiplier, _ := m.CalculateCardMilestoneMultipliers(card, level)
+
+	return m.CalculateCardCoinsPerClick(card, level, m.CalculateInvestorsMultiplier(investors)*milestoneMultiplier*coinsMultiplier)
+}
+
+// CalculateCardClickTimeout calculates the click timeout of the card on the level with the milestones bonuses.
+func (m *Math) CalculateCardClickTimeout(card *storageModel.Card, level uint64) uint64 {
+	_, timeoutMultiplier := m.CalculateCardMilestoneMultipliers(card, level)
+
+	return m.CalculateClickTimeout(card.ClickTimeout, timeoutMultiplier)
 }
 
 // GetGameVariables returns the game variables.
 func (m *Math) GetGameVariables() *config.GameVariables {
-	return m.config
+	return m.config.Load()
+}
+
+// SetGameVariables swaps the game variables. The calculations in progress finish with the previous ones.
+func (m *Math) SetGameVariables(cfg *config.GameVariables) {
+	m.config.Store(cfg)
 }
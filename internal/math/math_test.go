@@ -1,9 +1,12 @@
 package math
 
 import (
+	stdmath "math"
 	"testing"
 
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
 	config "github.com/adzpm/telegram-clicker/internal/config"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
 )
 
 func TestCalculateCoinsPerClick(t *testing.T) {
@@ -40,15 +43,15 @@ func TestCalculateCoinsPerClick(t *testing.T) {
 				})
 				investorsMultiplier = mth.CalculateInvestorsMultiplier(tc.investors)
 				result              = mth.CalculateGeometricCoinsPerClick(
-					tc.startCoins,
+					amount.New(tc.startCoins),
 					tc.level,
 					tc.coinsMultiplier,
 					investorsMultiplier,
 				)
 			)
 
-			if result != tc.expected {
-				t.Errorf("expected %d, got %d", tc.expected, result)
+			if result != amount.New(tc.expected) {
+				t.Errorf("expected %d, got %s", tc.expected, result)
 			}
 		})
 	}
@@ -85,14 +88,14 @@ func TestCalculateCoinsPerClickV2(t *testing.T) {
 				})
 				investorsMultiplier = mth.CalculateInvestorsMultiplier(tc.investors)
 				result              = mth.CalculateAlgebraCoinsPerClick(
-					tc.startCoins,
+					amount.New(tc.startCoins),
 					tc.level,
 					investorsMultiplier,
 				)
 			)
 
-			if result != tc.expected {
-				t.Errorf("expected %d, got %d", tc.expected, result)
+			if result != amount.New(tc.expected) {
+				t.Errorf("expected %d, got %s", tc.expected, result)
 			}
 		})
 	}
@@ -127,7 +130,77 @@ func TestCalculateUpgradePrice(t *testing.T) {
 					EarnedCoinsForInvestor: 5000000,
 					PercentsForInvestor:    0.02,
 				})
-				result = mth.CalculateUpgradePrice(tc.startPrice, tc.level, tc.priceMultiplier)
+				result = mth.CalculateUpgradePrice(amount.New(tc.startPrice), tc.level, tc.priceMultiplier)
+			)
+
+			if result != amount.New(tc.expected) {
+				t.Errorf("expected %d, got %s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestCalculateOfflineTime(t *testing.T) {
+	testCases := map[string]struct {
+		lastSeen       uint64
+		now            uint64
+		maxOfflineTime uint64
+		expected       uint64
+	}{
+		"no offline time":       {100, 100, 3600, 0},
+		"last seen in future":   {200, 100, 3600, 0},
+		"below max":             {100, 1100, 3600, 1000},
+		"equal to max":          {100, 3700, 3600, 3600},
+		"above max":             {100, 100000, 3600, 3600},
+		"offline time disabled": {100, 100000, 0, 0},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mth = New(&config.GameVariables{
+					EarnedCoinsForInvestor: 5000000,
+					PercentsForInvestor:    0.02,
+					MaxOfflineTime:         tc.maxOfflineTime,
+				})
+				result = mth.CalculateOfflineTime(tc.lastSeen, tc.now)
+			)
+
+			if result != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestCalculateOfflineClicks(t *testing.T) {
+	testCases := map[string]struct {
+		from         uint64
+		to           uint64
+		nextClick    uint64
+		clickTimeout uint64
+		expected     uint64
+	}{
+		"empty period":             {100, 100, 0, 1, 0},
+		"timeout 1":                {100, 200, 0, 1, 100},
+		"timeout 3":                {100, 200, 0, 3, 33},
+		"timeout 0":                {100, 200, 0, 0, 100},
+		"timeout above period":     {100, 200, 0, 101, 0},
+		"next click inside period": {100, 200, 150, 10, 5},
+		"next click after period":  {100, 200, 300, 10, 0},
+		"next click before period": {100, 200, 50, 10, 10},
+		"next click at period end": {100, 200, 200, 10, 0},
+		"to before from":           {200, 100, 0, 1, 0},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mth = New(&config.GameVariables{
+					EarnedCoinsForInvestor: 5000000,
+					PercentsForInvestor:    0.02,
+				})
+				result = mth.CalculateOfflineClicks(tc.from, tc.to, tc.nextClick, tc.clickTimeout)
 			)
 
 			if result != tc.expected {
@@ -136,3 +209,506 @@ func TestCalculateUpgradePrice(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateMilestones(t *testing.T) {
+	testCases := map[string]struct {
+		level         uint64
+		upgradeLevel  uint64
+		milestones    uint64
+		nextMilestone uint64
+	}{
+		"level 0 / upgrade 50":    {0, 50, 0, 50},
+		"level 1 / upgrade 50":    {1, 50, 0, 50},
+		"level 49 / upgrade 50":   {49, 50, 0, 50},
+		"level 50 / upgrade 50":   {50, 50, 1, 100},
+		"level 99 / upgrade 50":   {99, 50, 1, 100},
+		"level 100 / upgrade 50":  {100, 50, 2, 150},
+		"level 1000 / upgrade 50": {1000, 50, 20, 1050},
+		"level 10 / upgrade 0":    {10, 0, 0, 0},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mth = New(&config.GameVariables{
+					EarnedCoinsForInvestor: 5000000,
+					PercentsForInvestor:    0.02,
+				})
+				milestones    = mth.CalculateMilestones(tc.level, tc.upgradeLevel)
+				nextMilestone = mth.CalculateNextMilestoneLevel(tc.level, tc.upgradeLevel)
+			)
+
+			if milestones != tc.milestones {
+				t.Errorf("expected %d milestones, got %d", tc.milestones, milestones)
+			}
+
+			if nextMilestone != tc.nextMilestone {
+				t.Errorf("expected next milestone %d, got %d", tc.nextMilestone, nextMilestone)
+			}
+		})
+	}
+}
+
+func TestCalculateMilestoneMultipliers(t *testing.T) {
+	var (
+		doubleCoins = config.Milestone{CoinsMultiplier: 2}
+		halfTimeout = config.Milestone{TimeoutMultiplier: 0.5}
+	)
+
+	testCases := map[string]struct {
+		milestones        []config.Milestone
+		reached           uint64
+		coinsMultiplier   float64
+		timeoutMultiplier float64
+	}{
+		"no milestones configured":   {nil, 3, 1, 1},
+		"no milestones reached":      {[]config.Milestone{doubleCoins, halfTimeout}, 0, 1, 1},
+		"first milestone":            {[]config.Milestone{doubleCoins, halfTimeout}, 1, 2, 1},
+		"second milestone":           {[]config.Milestone{doubleCoins, halfTimeout}, 2, 2, 0.5},
+		"milestones start over":      {[]config.Milestone{doubleCoins, halfTimeout}, 3, 4, 0.5},
+		"two full cycles":            {[]config.Milestone{doubleCoins, halfTimeout}, 4, 4, 0.25},
+		"single effect every time":   {[]config.Milestone{doubleCoins}, 5, 32, 1},
+		"both effects at once":       {[]config.Milestone{{CoinsMultiplier: 3, TimeoutMultiplier: 0.9}}, 2, 9, 0.81},
+		"zero values have no effect": {[]config.Milestone{{}}, 10, 1, 1},
+		"many cycles and a half":     {[]config.Milestone{doubleCoins, halfTimeout, doubleCoins}, 301, 1 << 201, 1.0 / (1 << 100)},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mth = New(&config.GameVariables{
+					EarnedCoinsForInvestor: 5000000,
+					PercentsForInvestor:    0.02,
+					Milestones:             tc.milestones,
+				})
+				coinsMultiplier, timeoutMultiplier = mth.CalculateMilestoneMultipliers(tc.reached)
+			)
+
+			if coinsMultiplier != tc.coinsMultiplier {
+				t.Errorf("expected coins multiplier %f, got %f", tc.coinsMultiplier, coinsMultiplier)
+			}
+
+			if timeoutMultiplier-tc.timeoutMultiplier > 1e-9 || tc.timeoutMultiplier-timeoutMultiplier > 1e-9 {
+				t.Errorf("expected timeout multiplier %f, got %f", tc.timeoutMultiplier, timeoutMultiplier)
+			}
+		})
+	}
+}
+
+func TestCalculateClickTimeout(t *testing.T) {
+	testCases := map[string]struct {
+		clickTimeout      uint64
+		timeoutMultiplier float64
+		expected          uint64
+	}{
+		"no multiplier":       {10, 1, 10},
+		"half timeout":        {10, 0.5, 5},
+		"rounded down":        {5, 0.5, 2},
+		"at least one second": {1, 0.5, 1},
+		"tiny multiplier":     {100, 0.001, 1},
+		"no timeout":          {0, 0.5, 0},
+		"saturated":           {10, 1e300, stdmath.MaxUint64},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mth = New(&config.GameVariables{
+					EarnedCoinsForInvestor: 5000000,
+					PercentsForInvestor:    0.02,
+				})
+				result = mth.CalculateClickTimeout(tc.clickTimeout, tc.timeoutMultiplier)
+			)
+
+			if result != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestCalculateCardClick(t *testing.T) {
+	var (
+		card = &storageModel.Card{CoinsPerClick: amount.New(100), ClickTimeout: 10, UpgradeLevel: 10}
+		mth  = New(&config.GameVariables{
+			EarnedCoinsForInvestor: 5000000,
+			PercentsForInvestor:    0.5,
+			Milestones:             []config.Milestone{{CoinsMultiplier: 2, TimeoutMultiplier: 0.5}},
+		})
+	)
+
+	testCases := map[string]struct {
+		level           uint64
+		investors       uint64
+		coinsMultiplier float64
+		coins           amount.Amount
+		timeout         uint64
+	}{
+		"not bought":            {0, 2, 2, amount.Zero, 10},
+		"no bonuses":            {1, 0, 1, amount.New(100), 10},
+		"investors":             {1, 2, 1, amount.New(200), 10},
+		"purchased multiplier":  {1, 0, 3, amount.New(300), 10},
+		"milestone":             {10, 0, 1, amount.New(2000), 5},
+		"all the bonuses stack": {10, 2, 3, amount.New(12000), 5},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if coins := mth.CalculateCardClickCoins(card, tc.level, tc.investors, tc.coinsMultiplier); coins != tc.coins {
+				t.Errorf("expected %s coins, got %s", tc.coins, coins)
+			}
+
+			if timeout := mth.CalculateCardClickTimeout(card, tc.level); timeout != tc.timeout {
+				t.Errorf("expected %d timeout, got %d", tc.timeout, timeout)
+			}
+		})
+	}
+}
+
+func TestCalculateUpgradePriceSum(t *testing.T) {
+	testCases := map[string]struct {
+		startPrice      uint64
+		level           uint64
+		count           uint64
+		priceMultiplier float64
+		expected        uint64
+	}{
+		"count 0":                  {100, 0, 0, 1.5, 0},
+		"count 1 / level 0":        {100, 0, 1, 1.5, 100},
+		"count 1 / level 1":        {100, 1, 1, 1.5, 150},
+		"count 2 / level 0":        {100, 0, 2, 1.5, 250},
+		"count 3 / level 2":        {100, 2, 3, 1.5, 1068},
+		"count 4 / multiplier 3":   {10, 0, 4, 3, 400},
+		"count 10 / multiplier 1":  {10, 5, 10, 1, 100},
+		"count 10 / multiplier 2":  {1, 0, 10, 2, 1023},
+		"count 10 / level 10 / x2": {1, 10, 10, 2, 1047552},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mth = New(&config.GameVariables{
+					EarnedCoinsForInvestor: 5000000,
+					PercentsForInvestor:    0.02,
+				})
+				result = mth.CalculateUpgradePriceSum(amount.New(tc.startPrice), tc.level, tc.count, tc.priceMultiplier)
+			)
+
+			if result != amount.New(tc.expected) {
+				t.Errorf("expected %d, got %s", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestCalculateMaxUpgrades(t *testing.T) {
+	testCases := map[string]struct {
+		startPrice      uint64
+		level           uint64
+		limit           uint64
+		coins           uint64
+		priceMultiplier float64
+		expected        uint64
+	}{
+		"no coins":                {100, 0, 1000, 0, 1.5, 0},
+		"not enough for one":      {100, 0, 1000, 99, 1.5, 0},
+		"exactly one":             {100, 0, 1000, 100, 1.5, 1},
+		"exactly two":             {100, 0, 1000, 250, 1.5, 2},
+		"almost three":            {100, 0, 1000, 474, 1.5, 2},
+		"exactly three":           {100, 0, 1000, 475, 1.5, 3},
+		"from level 2":            {100, 2, 1000, 1231, 1.5, 3},
+		"capped by limit":         {1, 0, 5, 1000000, 2, 5},
+		"multiplier 1":            {10, 5, 1000, 105, 1, 10},
+		"multiplier 2 / 10 items": {1, 0, 1000, 1023, 2, 10},
+		"free card":               {0, 0, 7, 0, 2, 7},
+		"zero limit":              {100, 0, 0, 1000000, 1.5, 0},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mth = New(&config.GameVariables{
+					EarnedCoinsForInvestor: 5000000,
+					PercentsForInvestor:    0.02,
+				})
+				result = mth.CalculateMaxUpgrades(amount.New(tc.startPrice), tc.level, tc.limit, amount.New(tc.coins), tc.priceMultiplier)
+			)
+
+			if result != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestCalculateUpgradePriceExtremes(t *testing.T) {
+	var (
+		mth      = New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02})
+		card     = &storageModel.Card{Price: amount.New(5), PriceMultiplier: 1.25, CoinsPerClick: amount.New(1)}
+		uint64Mx = amount.New(stdmath.MaxUint64)
+	)
+
+	// the price of the card 1 passes uint64 around the level 200
+	price := mth.CalculateCardUpgradePrice(card, 200)
+	if expected := 5 * stdmath.Pow(1.25, 200); price.Cmp(uint64Mx) <= 0 || stdmath.Abs(price.Float64()-expected) > expected*1e-12 {
+		t.Errorf("expected %v, got %s", expected, price)
+	}
+
+	// and saturates long before the max level
+	if price = mth.CalculateCardUpgradePrice(card, 1000); price != amount.Max {
+		t.Errorf("expected max amount, got %s", price)
+	}
+
+	if sum := mth.CalculateCardUpgradePriceSum(card, 0, 1000); sum != amount.Max {
+		t.Errorf("expected max amount, got %s", sum)
+	}
+
+	count := mth.CalculateCardMaxUpgrades(card, 0, 1000, amount.Max)
+	if count < 700 || count == 1000 || mth.CalculateCardUpgradePriceSum(card, 0, count).Cmp(amount.Max) > 0 {
+		t.Errorf("expected the levels affordable with max amount, got %d", count)
+	}
+
+	if coins := mth.CalculateCardCoinsPerClick(&storageModel.Card{CoinsPerClick: amount.Max}, 3, 1); coins != amount.Max {
+		t.Errorf("expected max amount, got %s", coins)
+	}
+
+	if investors := mth.CalculateInvestorsCount(amount.Max); investors != MaxInvestors {
+		t.Errorf("expected max investors, got %d", investors)
+	}
+}
+
+func TestCalculateInvestorsCap(t *testing.T) {
+	var (
+		mth     = New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02})
+		perUnit = amount.New(5000000)
+	)
+
+	tests := map[string]struct {
+		earnedCoins amount.Amount
+		investors   uint64
+	}{
+		"below the cap":   {perUnit.Mul(MaxInvestors - 1), MaxInvestors - 1},
+		"on the cap":      {perUnit.Mul(MaxInvestors), MaxInvestors},
+		"above the cap":   {perUnit.Mul(MaxInvestors + 1), MaxInvestors},
+		"past the uint64": {perUnit.Mul(stdmath.MaxUint64).Mul(2), MaxInvestors},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if investors := mth.CalculateInvestorsCount(tc.earnedCoins); investors != tc.investors {
+				t.Errorf("expected %d, got %d", tc.investors, investors)
+			}
+		})
+	}
+
+	// the investors stored before the cap count as the capped ones
+	if expected, got := mth.CalculateInvestorsMultiplier(MaxInvestors), mth.CalculateInvestorsMultiplier(stdmath.MaxUint64); got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if expected, got := 1+float64(MaxInvestors)*0.02, mth.CalculateInvestorsMultiplier(MaxInvestors); got != expected {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// loopCoinsPerClick, loopUpgradePrice and loopMilestoneMultipliers are the former calculations
+// multiplying the values level by level, the closed forms are checked and benchmarked against them.
+func loopCoinsPerClick(startCoins, level uint64, coinsMultiplier, investorsMultiplier float64) float64 {
+	if level == 0 {
+		return 0
+	}
+
+	coinsPerClick := float64(startCoins)
+	for i := uint64(1); i < level; i++ {
+		coinsPerClick *= coinsMultiplier
+	}
+
+	return coinsPerClick * investorsMultiplier
+}
+
+func loopUpgradePrice(startPrice, level uint64, priceMultiplier float64) float64 {
+	upgradePrice := float64(startPrice)
+	for i := uint64(1); i <= level; i++ {
+		upgradePrice *= priceMultiplier
+	}
+
+	return upgradePrice
+}
+
+func loopUpgradePriceSum(startPrice, level, count uint64, priceMultiplier float64) (sum float64) {
+	for i := uint64(0); i < count; i++ {
+		sum += loopUpgradePrice(startPrice, level+i, priceMultiplier)
+	}
+
+	return sum
+}
+
+func loopMilestoneMultipliers(list []config.Milestone, milestones uint64) (coinsMultiplier, timeoutMultiplier float64) {
+	coinsMultiplier, timeoutMultiplier = 1, 1
+
+	for i := uint64(0); i < milestones && len(list) > 0; i++ {
+		milestone := list[i%uint64(len(list))]
+		coinsMultiplier *= positiveOrOne(milestone.CoinsMultiplier)
+		timeoutMultiplier *= positiveOrOne(milestone.TimeoutMultiplier)
+	}
+
+	return coinsMultiplier, timeoutMultiplier
+}
+
+// mustBeClose fails if the amount differs from the float value by more than the relative tolerance,
+// the values above the max amount must saturate.
+func mustBeClose(t *testing.T, field string, expected float64, result amount.Amount) {
+	t.Helper()
+
+	// the amounts drop the fraction
+	expected = min(expected, amount.Max.Float64())
+
+	if diff := stdmath.Abs(result.Float64() - expected); diff > 1+expected*1e-9 {
+		t.Errorf("%s: expected %v, got %s", field, expected, result)
+	}
+}
+
+func FuzzCalculateUpgradePrice(f *testing.F) {
+	f.Add(uint64(5), uint16(200), 1.25, uint16(10))
+	f.Add(uint64(100), uint16(0), 2.0, uint16(0))
+	f.Add(uint64(1), uint16(1000), 1.25, uint16(1000))
+	f.Add(uint64(stdmath.MaxUint64), uint16(65535), 1.01, uint16(3))
+	f.Add(uint64(1000), uint16(50), 0.5, uint16(100))
+
+	mth := New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02})
+
+	f.Fuzz(func(t *testing.T, startPrice uint64, level uint16, priceMultiplier float64, count uint16) {
+		if !(priceMultiplier > 0 && priceMultiplier <= 10) {
+			t.Skip()
+		}
+
+		start := amount.New(startPrice)
+
+		mustBeClose(t, "price", loopUpgradePrice(startPrice, uint64(level), priceMultiplier),
+			mth.CalculateUpgradePrice(start, uint64(level), priceMultiplier))
+		mustBeClose(t, "price sum", loopUpgradePriceSum(startPrice, uint64(level), uint64(count), priceMultiplier),
+			mth.CalculateUpgradePriceSum(start, uint64(level), uint64(count), priceMultiplier))
+	})
+}
+
+func FuzzCalculateCoinsPerClick(f *testing.F) {
+	f.Add(uint64(1), uint16(1), 1.5, 1.0)
+	f.Add(uint64(30), uint16(1000), 1.1, 1.2)
+	f.Add(uint64(stdmath.MaxUint64), uint16(65535), 2.0, 1.0)
+	f.Add(uint64(7), uint16(0), 1.5, 3.0)
+
+	mth := New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02})
+
+	f.Fuzz(func(t *testing.T, startCoins uint64, level uint16, coinsMultiplier, investorsMultiplier float64) {
+		if !(coinsMultiplier > 0 && coinsMultiplier <= 10 && investorsMultiplier >= 0 && investorsMultiplier <= 1e6) {
+			t.Skip()
+		}
+
+		start := amount.New(startCoins)
+
+		mustBeClose(t, "geometric", loopCoinsPerClick(startCoins, uint64(level), coinsMultiplier, investorsMultiplier),
+			mth.CalculateGeometricCoinsPerClick(start, uint64(level), coinsMultiplier, investorsMultiplier))
+		mustBeClose(t, "algebra", loopCoinsPerClick(startCoins, 1, 1, 1)*float64(level)*investorsMultiplier,
+			mth.CalculateAlgebraCoinsPerClick(start, uint64(level), investorsMultiplier))
+	})
+}
+
+func FuzzCalculateMilestoneMultipliers(f *testing.F) {
+	f.Add(2.0, 0.5, 1.5, 0.0, uint16(7))
+	f.Add(1.1, 0.9, 0.0, 0.0, uint16(1000))
+	f.Add(0.0, 0.0, 3.0, 0.99, uint16(0))
+
+	f.Fuzz(func(t *testing.T, coins1, timeout1, coins2, timeout2 float64, milestones uint16) {
+		for _, v := range []float64{coins1, timeout1, coins2, timeout2} {
+			if !(v >= 0 && v <= 4) {
+				t.Skip()
+			}
+		}
+
+		var (
+			list = []config.Milestone{
+				{CoinsMultiplier: coins1, TimeoutMultiplier: timeout1},
+				{CoinsMultiplier: coins2, TimeoutMultiplier: timeout2},
+			}
+			mth                  = New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02, Milestones: list})
+			coins, timeout       = mth.CalculateMilestoneMultipliers(uint64(milestones))
+			loopCoins, loopTimes = loopMilestoneMultipliers(list, uint64(milestones))
+		)
+
+		for _, pair := range [][2]float64{{loopCoins, coins}, {loopTimes, timeout}} {
+			if diff := stdmath.Abs(pair[0] - pair[1]); diff > max(stdmath.Abs(pair[0])*1e-9, 1e-300) && !(stdmath.IsInf(pair[0], 1) && stdmath.IsInf(pair[1], 1)) {
+				t.Errorf("expected %v, got %v", pair[0], pair[1])
+			}
+		}
+	})
+}
+
+// BenchmarkCalculateUpgradePrice compares the closed forms with the loops on the max level of the cards.
+func BenchmarkCalculateUpgradePrice(b *testing.B) {
+	var (
+		mth   = New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02})
+		start = amount.New(5)
+	)
+
+	b.Run("closed form", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mth.CalculateUpgradePrice(start, 1000, 1.25)
+		}
+	})
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			amount.FromFloat(loopUpgradePrice(5, 1000, 1.25))
+		}
+	})
+
+	b.Run("sum / closed form", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mth.CalculateUpgradePriceSum(start, 0, 1000, 1.25)
+		}
+	})
+
+	b.Run("sum / loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			amount.FromFloat(loopUpgradePriceSum(5, 0, 1000, 1.25))
+		}
+	})
+}
+
+// BenchmarkCalculateCoinsPerClick compares the closed form with the loop on the max level of the cards.
+func BenchmarkCalculateCoinsPerClick(b *testing.B) {
+	var (
+		mth   = New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02})
+		start = amount.New(1)
+	)
+
+	b.Run("closed form", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mth.CalculateGeometricCoinsPerClick(start, 1000, 1.1, 1.2)
+		}
+	})
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			amount.FromFloat(loopCoinsPerClick(1, 1000, 1.1, 1.2))
+		}
+	})
+}
+
+// BenchmarkCalculateMilestoneMultipliers compares the closed form with the loop on the milestones of the max level.
+func BenchmarkCalculateMilestoneMultipliers(b *testing.B) {
+	list := []config.Milestone{{CoinsMultiplier: 2}, {TimeoutMultiplier: 0.9}}
+	mth := New(&config.GameVariables{EarnedCoinsForInvestor: 5000000, PercentsForInvestor: 0.02, Milestones: list})
+
+	b.Run("closed form", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mth.CalculateMilestoneMultipliers(1000)
+		}
+	})
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			loopMilestoneMultipliers(list, 1000)
+		}
+	})
+}
@@ -1,11 +1,18 @@
 package rest
 
+import (
+	"encoding/json"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+)
+
 type (
 	Game struct {
 		UserID                        uint64               `json:"user_id"`
 		TelegramID                    uint64               `json:"telegram_id"`
+		Version                       uint64               `json:"version"`
 		LastSeen                      uint64               `json:"last_seen"`
-		CurrentCoins                  uint64               `json:"current_coins"`
+		CurrentCoins                  amount.Amount        `json:"current_coins"`
 		CurrentGold                   uint64               `json:"current_gold"`
 		CurrentInvestors              uint64               `json:"current_investors"`
 		InvestorsAfterReset           uint64               `json:"investors_after_reset"`
@@ -13,6 +20,42 @@ type (
 		InvestorsMultiplierAfterReset float64              `json:"investors_multiplier_after_reset"`
 		PercentsPerInvestor           uint64               `json:"percents_per_investor"`
 		Cards                         map[uint64]*GameCard `json:"cards"`
+		Effects                       *GameEffects         `json:"effects"`
+		OfflineEarnings               *OfflineEarnings     `json:"offline_earnings,omitempty"`
+	}
+
+	GameEffects struct {
+		CoinsMultiplier          float64 `json:"coins_multiplier"`
+		CoinsMultiplierExpiresAt uint64  `json:"coins_multiplier_expires_at"`
+		SkipTimeouts             uint64  `json:"skip_timeouts"`
+	}
+
+	Shop struct {
+		CurrentGold uint64       `json:"current_gold"`
+		Items       []*ShopItem  `json:"items"`
+		Effects     *GameEffects `json:"effects"`
+	}
+
+	ShopItem struct {
+		ID       uint64  `json:"id"`
+		Name     string  `json:"name"`
+		ImageURL string  `json:"image_url"`
+		Type     string  `json:"type"`
+		Price    uint64  `json:"price"`
+		Value    float64 `json:"value"`
+		Duration uint64  `json:"duration"`
+	}
+
+	OfflineEarnings struct {
+		OfflineTime uint64                          `json:"offline_time"`
+		Coins       amount.Amount                   `json:"coins"`
+		Cards       map[uint64]*OfflineCardEarnings `json:"cards"`
+	}
+
+	OfflineCardEarnings struct {
+		CardID uint64        `json:"card_id"`
+		Clicks uint64        `json:"clicks"`
+		Coins  amount.Amount `json:"coins"`
 	}
 
 	GameCard struct {
@@ -22,15 +65,161 @@ type (
 
 		CurrentLevel uint64 `json:"current_level"`
 		MaxLevel     uint64 `json:"max_level"`
+		IsMaxed      bool   `json:"is_maxed"`
 
-		CurrentPrice   uint64 `json:"current_price"`
-		NextLevelPrice uint64 `json:"upgrade_price"`
+		CurrentPrice   amount.Amount `json:"current_price"`
+		NextLevelPrice amount.Amount `json:"upgrade_price"`
 
 		ClickTimeout uint64 `json:"click_timeout"`
 		NextClick    uint64 `json:"next_click"`
 		LastClick    uint64 `json:"last_click"`
 
-		CurrentCoinsPerClick   uint64 `json:"current_coins_per_click"`
-		NextLevelCoinsPerClick uint64 `json:"next_level_coins_per_click"`
+		CurrentCoinsPerClick   amount.Amount `json:"current_coins_per_click"`
+		NextLevelCoinsPerClick amount.Amount `json:"next_level_coins_per_click"`
+
+		NextMilestoneLevel  uint64  `json:"next_milestone_level"`
+		MilestoneMultiplier float64 `json:"milestone_multiplier"`
+	}
+
+	Tasks struct {
+		Tasks []*Task `json:"tasks"`
+	}
+
+	Task struct {
+		ID          uint64        `json:"id"`
+		Name        string        `json:"name"`
+		Description string        `json:"description"`
+		ImageURL    string        `json:"image_url"`
+		Type        string        `json:"type"`
+		Channel     string        `json:"channel,omitempty"`
+		Target      uint64        `json:"target"`
+		Progress    uint64        `json:"progress"`
+		IsDone      bool          `json:"is_done"`
+		IsClaimed   bool          `json:"is_claimed"`
+		RewardCoins amount.Amount `json:"reward_coins"`
+		RewardGold  uint64        `json:"reward_gold"`
+	}
+
+	// Leaderboard is the page of the board from Offset, Total is the number of the listed users, at most
+	// the top of the board, and Own is the entry of the user, omitted if the user isn't ranked.
+	Leaderboard struct {
+		Board     string              `json:"board"`
+		Entries   []*LeaderboardEntry `json:"entries"`
+		Offset    uint64              `json:"offset"`
+		Total     uint64              `json:"total"`
+		Own       *LeaderboardEntry   `json:"own,omitempty"`
+		UpdatedAt uint64              `json:"updated_at"`
+	}
+
+	LeaderboardEntry struct {
+		Rank       uint64        `json:"rank"`
+		TelegramID uint64        `json:"telegram_id"`
+		Score      amount.Amount `json:"score"`
+	}
+
+	// Referrals is the friends the user invited with StartParam, the rewards the user got for them in total
+	// and the share of the coins the friends earn the user gets. CurrentCoins include the collected rewards,
+	// PendingCoins are the share of the coins the friends earned since the last collection.
+	Referrals struct {
+		StartParam   string        `json:"start_param"`
+		CurrentCoins amount.Amount `json:"current_coins"`
+		RewardCoins  amount.Amount `json:"reward_coins"`
+		RewardGold   uint64        `json:"reward_gold"`
+		PendingCoins amount.Amount `json:"pending_coins"`
+		Percents     float64       `json:"percents"`
+		Referrals    []*Referral   `json:"referrals"`
+	}
+
+	Referral struct {
+		TelegramID    uint64        `json:"telegram_id"`
+		LifetimeCoins amount.Amount `json:"lifetime_coins"`
+		RewardCoins   amount.Amount `json:"reward_coins"`
+		RewardGold    uint64        `json:"reward_gold"`
+	}
+
+	// ClickRequest clicks the card. If Compact is set, the response is the GameDiff instead of the Game.
+	ClickRequest struct {
+		CardID      uint64 `json:"card_id"`
+		SkipTimeout bool   `json:"skip_timeout"`
+		Compact     bool   `json:"compact"`
+	}
+
+	// BuyRequest buys Count levels of the card, 1 by default, or as many as the user can afford if Max is set.
+	// If Compact is set, the response is the GameDiff instead of the Game.
+	BuyRequest struct {
+		CardID  uint64 `json:"card_id"`
+		Count   uint64 `json:"count"`
+		Max     bool   `json:"max"`
+		Compact bool   `json:"compact"`
+	}
+
+	ShopBuyRequest struct {
+		ItemID uint64 `json:"item_id"`
+	}
+
+	ClaimTaskRequest struct {
+		TaskID uint64 `json:"task_id"`
+	}
+
+	// SocketRequest is the command the client sends over the websocket. ID is echoed in the answer.
+	SocketRequest struct {
+		ID   string          `json:"id"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+
+	// SocketMessage is the message the server pushes over the websocket: the game state, its diff,
+	// the cards becoming ready or the error of the command.
+	SocketMessage struct {
+		ID   string      `json:"id,omitempty"`
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}
+
+	// GameDiff holds the totals of the game and only the cards and effects which changed.
+	// Version grows with every change of the user, a gap means the client missed some diffs.
+	GameDiff struct {
+		Version                       uint64               `json:"version"`
+		CurrentCoins                  amount.Amount        `json:"current_coins"`
+		CurrentGold                   uint64               `json:"current_gold"`
+		CurrentInvestors              uint64               `json:"current_investors"`
+		InvestorsAfterReset           uint64               `json:"investors_after_reset"`
+		CurrentInvestorsMultiplier    float64              `json:"current_investors_multiplier"`
+		InvestorsMultiplierAfterReset float64              `json:"investors_multiplier_after_reset"`
+		Cards                         map[uint64]*GameCard `json:"cards,omitempty"`
+		Effects                       *GameEffects         `json:"effects,omitempty"`
+	}
+
+	CardsReady struct {
+		CardIDs []uint64 `json:"card_ids"`
+	}
+
+	Error struct {
+		Error     string                 `json:"error"`
+		Code      string                 `json:"code"`
+		Details   map[string]interface{} `json:"details,omitempty"`
+		Fields    []*FieldError          `json:"fields,omitempty"`
+		RequestID string                 `json:"request_id,omitempty"`
+	}
+
+	FieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+
+	InitData struct {
+		QueryID    string        `json:"query_id"`
+		AuthDate   uint64        `json:"auth_date"`
+		StartParam string        `json:"start_param"`
+		User       *TelegramUser `json:"user"`
+	}
+
+	TelegramUser struct {
+		ID           uint64 `json:"id"`
+		FirstName    string `json:"first_name"`
+		LastName     string `json:"last_name"`
+		Username     string `json:"username"`
+		LanguageCode string `json:"language_code"`
+		IsPremium    bool   `json:"is_premium"`
 	}
 )
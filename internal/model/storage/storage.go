@@ -1,34 +1,98 @@
 package storage
 
+import (
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+)
+
 type (
 	User struct {
-		ID          uint64 `json:"id"`
-		TelegramID  uint64 `json:"telegram_id"`
-		LastSeen    uint64 `json:"last_seen"`
-		Coins       uint64 `json:"coins"`
-		EarnedCoins uint64 `json:"earned_coins"`
-		Gold        uint64 `json:"gold"`
-		Investors   uint64 `json:"investors"`
+		ID          uint64        `json:"id"`
+		TelegramID  uint64        `json:"telegram_id" gorm:"uniqueIndex"`
+		LastSeen    uint64        `json:"last_seen"`
+		Coins       amount.Amount `json:"coins"`
+		EarnedCoins amount.Amount `json:"earned_coins"`
+		Gold        uint64        `json:"gold"`
+		Investors   uint64        `json:"investors" gorm:"index"`
+		Clicks      uint64        `json:"clicks"`
+		Prestiges   uint64        `json:"prestiges"`
+		Version     uint64        `json:"version" gorm:"not null;default:0"`
+
+		// LifetimeCoins are the coins earned in all the runs, the prestige doesn't reset them.
+		// DailyCoins are the coins earned on DailyCoinsDay, the day counted from the unix epoch in UTC.
+		// The leaderboards rank by them and the investors, so they are indexed.
+		LifetimeCoins amount.Amount `json:"lifetime_coins" gorm:"index"`
+		DailyCoins    amount.Amount `json:"daily_coins" gorm:"index:idx_users_daily_coins,priority:2"`
+		DailyCoinsDay uint64        `json:"daily_coins_day" gorm:"index:idx_users_daily_coins,priority:1"`
+
+		// ReferredBy is the telegram id of the user who invited this one, zero if nobody did.
+		// ReferralCoins and ReferralGold are the rewards the referrer got for this user and
+		// ReferralSettledCoins are the lifetime coins of this user the referrer already got the share of.
+		ReferredBy           uint64        `json:"referred_by" gorm:"index"`
+		ReferralCoins        amount.Amount `json:"referral_coins"`
+		ReferralGold         uint64        `json:"referral_gold"`
+		ReferralSettledCoins amount.Amount `json:"referral_settled_coins"`
 	}
 
 	UserCard struct {
 		ID         uint64 `json:"id"`
-		TelegramID uint64 `json:"telegram_id"`
-		CardID     uint64 `json:"card_id"`
+		TelegramID uint64 `json:"telegram_id" gorm:"uniqueIndex:idx_user_cards_user_card"`
+		CardID     uint64 `json:"card_id" gorm:"uniqueIndex:idx_user_cards_user_card"`
 		Level      uint64 `json:"level"`
 		NextClick  uint64 `json:"next_click"`
 		LastClick  uint64 `json:"last_click"`
 	}
 
+	UserPurchase struct {
+		ID              uint64        `json:"id"`
+		TelegramID      uint64        `json:"telegram_id"`
+		ItemID          uint64        `json:"item_id"`
+		Price           uint64        `json:"price"`
+		Coins           amount.Amount `json:"coins"`
+		RefreshClicks   bool          `json:"refresh_clicks"`
+		CoinsMultiplier float64       `json:"coins_multiplier"`
+		SkipTimeouts    uint64        `json:"skip_timeouts"`
+		CreatedAt       uint64        `json:"created_at"`
+		ExpiresAt       uint64        `json:"expires_at"`
+	}
+
+	UserTask struct {
+		ID         uint64 `json:"id"`
+		TelegramID uint64 `json:"telegram_id"`
+		TaskID     uint64 `json:"task_id"`
+		Progress   uint64 `json:"progress"`
+		Claimed    bool   `json:"claimed"`
+	}
+
 	Card struct {
-		ID              uint64  `json:"id"`
-		Name            string  `json:"name"`
-		ImageURL        string  `json:"image_url"`
-		Price           uint64  `json:"price"`
-		PriceMultiplier float64 `json:"price_multiplier"`
-		CoinsPerClick   uint64  `json:"coins_per_click"`
-		ClickTimeout    uint64  `json:"click_timeout"`
-		UpgradeLevel    uint64  `json:"upgrade_level"`
-		MaxLevel        uint64  `json:"max_level"`
+		ID              uint64        `json:"id"`
+		Name            string        `json:"name"`
+		ImageURL        string        `json:"image_url"`
+		Price           amount.Amount `json:"price"`
+		PriceMultiplier float64       `json:"price_multiplier"`
+		CoinsPerClick   amount.Amount `json:"coins_per_click"`
+		ClickTimeout    uint64        `json:"click_timeout"`
+		UpgradeLevel    uint64        `json:"upgrade_level"`
+		MaxLevel        uint64        `json:"max_level"`
+		Retired         bool          `json:"retired"`
+
+		// CoinsFormula and PriceFormula are the progressions of the coins per click and the price by level.
+		// The coins grow linearly and the price geometrically by PriceMultiplier if they aren't set.
+		CoinsFormula *Formula `json:"coins_formula,omitempty" gorm:"serializer:json"`
+		PriceFormula *Formula `json:"price_formula,omitempty" gorm:"serializer:json"`
+	}
+
+	// Formula is the progression of a card value by level: linear, geometric with the multiplier,
+	// polynomial with the exponent or piecewise made of the pieces.
+	Formula struct {
+		Type       string         `json:"type"`
+		Multiplier float64        `json:"multiplier,omitempty"`
+		Exponent   float64        `json:"exponent,omitempty"`
+		Pieces     []FormulaPiece `json:"pieces,omitempty"`
+	}
+
+	// FormulaPiece is the formula applied from the level until the next piece.
+	FormulaPiece struct {
+		FromLevel uint64 `json:"from_level"`
+		Formula
 	}
 )
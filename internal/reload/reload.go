@@ -0,0 +1,227 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	fsnotify "github.com/fsnotify/fsnotify"
+	zap "go.uber.org/zap"
+
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	math "github.com/adzpm/telegram-clicker/internal/math"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+)
+
+// debounce is how long the file events are collected before the reload, editors write files in several steps.
+const debounce = 200 * time.Millisecond
+
+type (
+	// Reloader applies the game variables and the card catalog from the config file at runtime.
+	// The game variables and the cards are both validated before either is applied, the cards
+	// are synchronized in one transaction and the game variables are swapped only after it commits,
+	// so a failed reload applies nothing. Everything else needs a restart.
+	Reloader struct {
+		mu   sync.Mutex
+		lgr  *zap.Logger
+		path string
+		cfg  *config.Config
+		mth  *math.Math
+		str  storage.Storage
+	}
+)
+
+// New creates the reloader of the config file the running cfg was loaded from.
+func New(lgr *zap.Logger, path string, cfg *config.Config, mth *math.Math, str storage.Storage) *Reloader {
+	return &Reloader{
+		lgr:  lgr,
+		path: path,
+		cfg:  cfg,
+		mth:  mth,
+		str:  str,
+	}
+}
+
+// Run reloads the config on SIGHUP and when the config file or the cards file changes, until the context is done.
+// Failed reloads are logged and the running config is kept.
+func (r *Reloader) Run(ctx context.Context) (err error) {
+	var (
+		watcher *fsnotify.Watcher
+		hup     = make(chan os.Signal, 1)
+		timer   = time.NewTimer(debounce)
+	)
+
+	timer.Stop()
+
+	if watcher, err = fsnotify.NewWatcher(); err != nil {
+		return err
+	}
+
+	defer func() { _ = watcher.Close() }()
+
+	if err = r.watch(watcher); err != nil {
+		return err
+	}
+
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			r.lgr.Info("reloading config on SIGHUP")
+			r.reload(watcher)
+		case event := <-watcher.Events:
+			if r.watched(event.Name) && event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				timer.Reset(debounce)
+			}
+		case <-timer.C:
+			r.lgr.Info("reloading config on file change")
+			r.reload(watcher)
+		case err = <-watcher.Errors:
+			r.lgr.Warn("error while watching config", zap.Error(err))
+		}
+	}
+}
+
+// Reload loads the config file and applies its game variables and cards, or nothing if either is invalid.
+func (r *Reloader) Reload() (err error) {
+	var (
+		next    *config.Config
+		sync    *storage.CardsSync
+		changes []string
+	)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if next, err = config.Load(r.path); err != nil {
+		return err
+	}
+
+	cards, err := storage.ReadCardsFile(next.GameVariables.CardsPath)
+	if err != nil {
+		return err
+	}
+
+	if err = storage.ValidateCards(cards); err != nil {
+		return err
+	}
+
+	if sync, err = r.str.SyncCards(cards, next.GameVariables.RetireRemovedCards); err != nil {
+		return err
+	}
+
+	prev := r.cfg
+	changes = diff("", reflect.ValueOf(prev.GameVariables), reflect.ValueOf(next.GameVariables))
+
+	r.mth.SetGameVariables(&next.GameVariables)
+
+	r.lgr.Info("config reloaded",
+		zap.Strings("changes", changes),
+		zap.Uint64s("inserted_cards", sync.Inserted),
+		zap.Uint64s("updated_cards", sync.Updated),
+		zap.Uint64s("retired_cards", sync.Retired),
+	)
+
+	if !reflect.DeepEqual(prev.REST, next.REST) || !reflect.DeepEqual(prev.Storage, next.Storage) {
+		r.lgr.Warn("rest and storage changes are applied on restart")
+	}
+
+	if prev.GameVariables.ShopPath != next.GameVariables.ShopPath || prev.GameVariables.TasksPath != next.GameVariables.TasksPath {
+		r.lgr.Warn("shop and tasks changes are applied on restart")
+	}
+
+	// the sections applied on restart are kept, so the warning repeats until then
+	next.REST, next.Storage = prev.REST, prev.Storage
+	next.GameVariables.ShopPath, next.GameVariables.TasksPath = prev.GameVariables.ShopPath, prev.GameVariables.TasksPath
+	r.cfg = next
+
+	return nil
+}
+
+// reload reloads the config and logs the error. The cards file may have moved, so it's watched again.
+func (r *Reloader) reload(watcher *fsnotify.Watcher) {
+	if err := r.Reload(); err != nil {
+		r.lgr.Error("error while reloading config, keeping the running one", zap.Error(err))
+
+		return
+	}
+
+	if err := r.watch(watcher); err != nil {
+		r.lgr.Warn("error while watching config", zap.Error(err))
+	}
+}
+
+// watch adds the directories of the watched files to the watcher.
+// The directories are watched because editors replace the files instead of writing them.
+func (r *Reloader) watch(watcher *fsnotify.Watcher) (err error) {
+	for _, dir := range r.watchedDirs() {
+		if err = watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// files returns the files which changes trigger the reload.
+func (r *Reloader) files() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return []string{r.path, r.cfg.GameVariables.CardsPath}
+}
+
+// watchedDirs returns the directories of the watched files.
+func (r *Reloader) watchedDirs() (dirs []string) {
+	seen := make(map[string]bool)
+
+	for _, file := range r.files() {
+		if dir := filepath.Dir(file); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// watched reports whether the changed file is one of the watched files.
+func (r *Reloader) watched(name string) bool {
+	for _, file := range r.files() {
+		if filepath.Clean(file) == filepath.Clean(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diff describes the changed fields of the structs as "path: old -> new", paths are made of the yaml names.
+func diff(prefix string, prev, next reflect.Value) (changes []string) {
+	for i := 0; i < prev.NumField(); i++ {
+		var (
+			name = prefix + prev.Type().Field(i).Tag.Get("yaml")
+			a    = prev.Field(i)
+			b    = next.Field(i)
+		)
+
+		switch {
+		case a.Kind() == reflect.Struct:
+			changes = append(changes, diff(name+".", a, b)...)
+		case !reflect.DeepEqual(a.Interface(), b.Interface()):
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, a.Interface(), b.Interface()))
+		}
+	}
+
+	return changes
+}
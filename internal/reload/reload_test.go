@@ -0,0 +1,205 @@
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	math "github.com/adzpm/telegram-clicker/internal/math"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+)
+
+const testConfig = `
+rest:
+  bot_token: token
+storage:
+  driver: memory
+game_variables:
+  cards_path: %CARDS%
+  earned_coins_for_investor: %COINS%
+  percents_for_investor: 0.02
+`
+
+var testCards = []storageModel.Card{
+	{ID: 1, Name: "Card 1", Price: amount.New(5), PriceMultiplier: 1.25, CoinsPerClick: amount.New(1), ClickTimeout: 10, UpgradeLevel: 50, MaxLevel: 1000},
+	{ID: 2, Name: "Card 2", Price: amount.New(60), PriceMultiplier: 1.25, CoinsPerClick: amount.New(30), ClickTimeout: 20, UpgradeLevel: 50, MaxLevel: 1000},
+}
+
+// newTestReloader loads the test config written to a temporary directory and creates the reloader
+// of the math and the memory storage made from it.
+func newTestReloader(t *testing.T) (*Reloader, *math.Math, storage.Storage, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	writeTestCards(t, dir, testCards)
+	writeTestConfig(t, dir, "5000")
+
+	var (
+		path     = filepath.Join(dir, "config.yaml")
+		cfg, err = config.Load(path)
+	)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var (
+		mth = math.New(&cfg.GameVariables)
+		str = storage.NewMemory(zap.NewNop(), testCards)
+	)
+
+	return New(zap.NewNop(), path, cfg, mth, str), mth, str, dir
+}
+
+func writeTestConfig(t *testing.T, dir, coins string) {
+	t.Helper()
+
+	data := strings.NewReplacer("%CARDS%", filepath.Join(dir, "cards.json"), "%COINS%", coins).Replace(testConfig)
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(data), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func writeTestCards(t *testing.T, dir string, cards []storageModel.Card) {
+	t.Helper()
+
+	data, err := json.Marshal(cards)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "cards.json"), data, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	rld, mth, str, dir := newTestReloader(t)
+
+	changed := testCards[1]
+	changed.Price = amount.New(70)
+
+	writeTestConfig(t, dir, "100")
+	writeTestCards(t, dir, []storageModel.Card{testCards[0], changed})
+
+	if err := rld.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if coins := mth.GetGameVariables().EarnedCoinsForInvestor; coins != 100 {
+		t.Errorf("expected 100 coins for investor, got %d", coins)
+	}
+
+	if card, err := str.SelectCard(2); err != nil || card.Price != amount.New(70) {
+		t.Errorf("expected card price 70, got %+v %v", card, err)
+	}
+
+	// nothing is swapped if the config is invalid
+	writeTestConfig(t, dir, "0")
+	writeTestCards(t, dir, testCards)
+
+	if err := rld.Reload(); err == nil || !strings.Contains(err.Error(), "earned_coins_for_investor") {
+		t.Errorf("expected validation error, got %v", err)
+	}
+
+	if coins := mth.GetGameVariables().EarnedCoinsForInvestor; coins != 100 {
+		t.Errorf("expected 100 coins for investor, got %d", coins)
+	}
+
+	if card, _ := str.SelectCard(2); card.Price != amount.New(70) {
+		t.Errorf("expected card price 70, got %s", card.Price)
+	}
+
+	// nor if the cards are invalid
+	writeTestConfig(t, dir, "200")
+	writeTestCards(t, dir, []storageModel.Card{testCards[0], testCards[0]})
+
+	if err := rld.Reload(); err == nil {
+		t.Errorf("expected duplicated id error")
+	}
+
+	if coins := mth.GetGameVariables().EarnedCoinsForInvestor; coins != 100 {
+		t.Errorf("expected 100 coins for investor, got %d", coins)
+	}
+}
+
+// failingSync is the storage failing to synchronize the cards.
+type failingSync struct {
+	storage.Storage
+}
+
+func (failingSync) SyncCards([]storageModel.Card, bool) (*storage.CardsSync, error) {
+	return nil, errors.New("sync failed")
+}
+
+func TestReloadFailedSync(t *testing.T) {
+	rld, mth, str, dir := newTestReloader(t)
+
+	rld.str = failingSync{str}
+
+	writeTestConfig(t, dir, "100")
+
+	// the game variables are kept with the cards the sync failed to change
+	if err := rld.Reload(); err == nil || err.Error() != "sync failed" {
+		t.Errorf("expected sync error, got %v", err)
+	}
+
+	if coins := mth.GetGameVariables().EarnedCoinsForInvestor; coins != 5000 {
+		t.Errorf("expected 5000 coins for investor, got %d", coins)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		rld, mth, _, dir = newTestReloader(t)
+		ctx, cancel      = context.WithCancel(context.Background())
+		done             = make(chan error, 1)
+	)
+
+	defer cancel()
+
+	go func() { done <- rld.Run(ctx) }()
+
+	// the watcher may start after the first write, so the config is written until it's applied,
+	// slower than the debounce which would put the reload off otherwise
+	for deadline := time.Now().Add(5 * time.Second); mth.GetGameVariables().EarnedCoinsForInvestor != 300; time.Sleep(2 * debounce) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the config to be reloaded on change")
+		}
+
+		writeTestConfig(t, dir, "300")
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	var (
+		prev = config.GameVariables{EarnedCoinsForInvestor: 10, PercentsForInvestor: 0.02}
+		next = config.GameVariables{EarnedCoinsForInvestor: 20, PercentsForInvestor: 0.02, Milestones: []config.Milestone{{CoinsMultiplier: 2}}}
+	)
+
+	changes := diff("", reflect.ValueOf(prev), reflect.ValueOf(next))
+	expected := []string{"earned_coins_for_investor: 10 -> 20", "milestones: [] -> [{2 0}]"}
+
+	if !reflect.DeepEqual(expected, changes) {
+		t.Errorf("expected %q, got %q", expected, changes)
+	}
+}
@@ -0,0 +1,175 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	zap "go.uber.org/zap"
+
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+const (
+	headerInitData = "X-Telegram-Init-Data"
+	queryInitData  = "init_data"
+	localsInitData = "init_data"
+
+	// secretKeyWebAppData is the HMAC key used to derive the secret from the bot token,
+	// see https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+	secretKeyWebAppData = "WebAppData"
+
+	// authDateSkew is how far auth_date may be ahead of the server clock.
+	authDateSkew = 5 * time.Minute
+)
+
+var (
+	errInitDataHashIsRequired = errors.New("hash is required")
+	errInitDataHashMismatch   = errors.New("hash mismatch")
+	errInitDataUserIsRequired = errors.New("user is required")
+	errInitDataExpired        = errors.New("init data is expired")
+	errInitDataFromFuture     = errors.New("auth date is in the future")
+)
+
+// ValidateInitData checks the signature of the Telegram WebApp init data against the bot token,
+// rejects it if auth_date is older than maxAge (0 disables the check) or further than the allowed clock skew
+// in the future and returns the parsed data.
+func ValidateInitData(raw, botToken string, maxAge time.Duration, now time.Time) (_ *restModel.InitData, err error) {
+	var (
+		values   url.Values
+		hash     []byte
+		authDate uint64
+		data     = &restModel.InitData{}
+	)
+
+	if values, err = url.ParseQuery(raw); err != nil {
+		return nil, err
+	}
+
+	if hash, err = hex.DecodeString(values.Get("hash")); err != nil {
+		return nil, err
+	}
+
+	if len(hash) == 0 {
+		return nil, errInitDataHashIsRequired
+	}
+
+	if !hmac.Equal(hash, signInitData(values, botToken)) {
+		return nil, errInitDataHashMismatch
+	}
+
+	if authDate, err = strconv.ParseUint(values.Get("auth_date"), 10, 64); err != nil {
+		return nil, err
+	}
+
+	if time.Unix(int64(authDate), 0).Sub(now) > authDateSkew {
+		return nil, errInitDataFromFuture
+	}
+
+	if maxAge > 0 && now.Sub(time.Unix(int64(authDate), 0)) > maxAge {
+		return nil, errInitDataExpired
+	}
+
+	if values.Get("user") == "" {
+		return nil, errInitDataUserIsRequired
+	}
+
+	if err = json.Unmarshal([]byte(values.Get("user")), &data.User); err != nil {
+		return nil, err
+	}
+
+	if data.User == nil || data.User.ID == 0 {
+		return nil, errInitDataUserIsRequired
+	}
+
+	data.QueryID = values.Get("query_id")
+	data.AuthDate = authDate
+	data.StartParam = values.Get("start_param")
+
+	return data, nil
+}
+
+// signInitData calculates the init data signature: all fields except hash are sorted
+// by key, joined as "key=value" lines and signed with the secret derived from the bot token.
+func signInitData(values url.Values, botToken string) []byte {
+	var (
+		keys   = make([]string, 0, len(values))
+		lines  = make([]string, 0, len(values))
+		secret = hmac.New(sha256.New, []byte(secretKeyWebAppData))
+	)
+
+	for key := range values {
+		if key != "hash" {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		lines = append(lines, key+"="+values.Get(key))
+	}
+
+	secret.Write([]byte(botToken))
+
+	sign := hmac.New(sha256.New, secret.Sum(nil))
+	sign.Write([]byte(strings.Join(lines, "\n")))
+
+	return sign.Sum(nil)
+}
+
+// Authorize is a middleware that validates the Telegram WebApp init data passed in the
+// X-Telegram-Init-Data header (or init_data query parameter) and stores it in the context.
+func (r *REST) Authorize(c *fiber.Ctx) (err error) {
+	var (
+		data *restModel.InitData
+		raw  = c.Get(headerInitData, c.Query(queryInitData))
+	)
+
+	if raw == "" {
+		return ErrInitDataIsRequired
+	}
+
+	if data, err = ValidateInitData(
+		raw,
+		r.cfg.BotToken,
+		time.Duration(r.cfg.AuthMaxAge)*time.Second,
+		time.Now(),
+	); err != nil {
+		r.lgr.Warn("error while validating init data", zap.Error(err))
+
+		if errors.Is(err, errInitDataExpired) {
+			return ErrInitDataIsExpired
+		}
+
+		return ErrInitDataIsInvalid
+	}
+
+	c.Locals(localsInitData, data)
+
+	return c.Next()
+}
+
+// getInitData returns the init data verified by Authorize.
+func getInitData(c *fiber.Ctx) *restModel.InitData {
+	data, _ := c.Locals(localsInitData).(*restModel.InitData)
+
+	return data
+}
+
+// getTelegramID returns the telegram id of the user verified by Authorize.
+func getTelegramID(c *fiber.Ctx) uint64 {
+	if data := getInitData(c); data != nil && data.User != nil {
+		return data.User.ID
+	}
+
+	return 0
+}
@@ -0,0 +1,163 @@
+package rest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	zap "go.uber.org/zap"
+
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+const (
+	testBotToken = "123456789:fake-bot-token"
+	testUser     = `{"id":42,"first_name":"John","last_name":"Doe","username":"johndoe"}`
+)
+
+// signTestInitData builds the init data query string signed with the given bot token.
+func signTestInitData(botToken string, authDate time.Time, fields map[string]string) string {
+	values := url.Values{}
+
+	for key, value := range fields {
+		values.Set(key, value)
+	}
+
+	values.Set("auth_date", strconv.FormatInt(authDate.Unix(), 10))
+	values.Set("hash", hex.EncodeToString(signInitData(values, botToken)))
+
+	return values.Encode()
+}
+
+// tamperTestInitData replaces a signed field without updating the hash.
+func tamperTestInitData(raw, key, value string) string {
+	values, _ := url.ParseQuery(raw)
+	values.Set(key, value)
+
+	return values.Encode()
+}
+
+func TestValidateInitData(t *testing.T) {
+	var (
+		now    = time.Unix(1720000000, 0)
+		fields = map[string]string{"query_id": "AAHdF6IQAAAAAN0XohDhrOrc", "user": testUser, "start_param": "ref_1"}
+	)
+
+	testCases := map[string]struct {
+		raw      string
+		maxAge   time.Duration
+		expected uint64
+		wantErr  bool
+	}{
+		"valid":                 {signTestInitData(testBotToken, now, fields), time.Hour, 42, false},
+		"valid / no max age":    {signTestInitData(testBotToken, now.Add(-48*time.Hour), fields), 0, 42, false},
+		"wrong bot token":       {signTestInitData("987654321:other-token", now, fields), time.Hour, 0, true},
+		"stale auth date":       {signTestInitData(testBotToken, now.Add(-2*time.Hour), fields), time.Hour, 0, true},
+		"tampered user":         {tamperTestInitData(signTestInitData(testBotToken, now, fields), "user", `{"id":1}`), time.Hour, 0, true},
+		"missing hash":          {"auth_date=1720000000&user=" + url.QueryEscape(testUser), time.Hour, 0, true},
+		"missing user":          {signTestInitData(testBotToken, now, map[string]string{"query_id": "1"}), time.Hour, 0, true},
+		"malformed hash":        {"hash=zz&auth_date=1720000000", time.Hour, 0, true},
+		"malformed user":        {signTestInitData(testBotToken, now, map[string]string{"user": "{"}), time.Hour, 0, true},
+		"malformed auth date":   {"auth_date=abc&hash=" + hex.EncodeToString(signInitData(url.Values{"auth_date": {"abc"}}, testBotToken)), 0, 0, true},
+		"empty init data":       {"", time.Hour, 0, true},
+		"user without id":       {signTestInitData(testBotToken, now, map[string]string{"user": `{"first_name":"John"}`}), time.Hour, 0, true},
+		"auth date from future": {signTestInitData(testBotToken, now.Add(time.Minute), fields), time.Hour, 42, false},
+		"auth date too far":     {signTestInitData(testBotToken, now.Add(time.Hour), fields), time.Hour, 0, true},
+		"far / no max age":      {signTestInitData(testBotToken, now.Add(time.Hour), fields), 0, 0, true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			data, err := ValidateInitData(tc.raw, testBotToken, tc.maxAge, now)
+
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", data)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if data.User.ID != tc.expected {
+				t.Errorf("expected user id %d, got %d", tc.expected, data.User.ID)
+			}
+
+			if data.StartParam != fields["start_param"] {
+				t.Errorf("expected start param %q, got %q", fields["start_param"], data.StartParam)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	var (
+		now = time.Now()
+		rst = &REST{
+			lgr: zap.NewNop(),
+			cfg: &config.REST{BotToken: testBotToken, AuthMaxAge: 3600},
+		}
+		valid = signTestInitData(testBotToken, now, map[string]string{"user": testUser})
+	)
+
+	rst.srv = fiber.New(fiber.Config{ErrorHandler: rst.handleError})
+	rst.srv.Get("/me", rst.Authorize, func(c *fiber.Ctx) error {
+		return c.SendString(strconv.FormatUint(getTelegramID(c), 10))
+	})
+
+	testCases := map[string]struct {
+		header   string
+		query    string
+		expected int
+		code     string
+	}{
+		"no init data":        {"", "", http.StatusUnauthorized, ErrInitDataIsRequired.Code},
+		"valid header":        {valid, "", http.StatusOK, ""},
+		"valid query":         {"", valid, http.StatusOK, ""},
+		"invalid header":      {signTestInitData("0:wrong", now, map[string]string{"user": testUser}), "", http.StatusUnauthorized, ErrInitDataIsInvalid.Code},
+		"expired header":      {signTestInitData(testBotToken, now.Add(-2*time.Hour), map[string]string{"user": testUser}), "", http.StatusUnauthorized, ErrInitDataIsExpired.Code},
+		"header wins on both": {valid, "garbage", http.StatusOK, ""},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/me?"+url.Values{queryInitData: {tc.query}}.Encode(), nil)
+
+			if tc.header != "" {
+				req.Header.Set(headerInitData, tc.header)
+			}
+
+			res, err := rst.srv.Test(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if res.StatusCode != tc.expected {
+				t.Errorf("expected status %d, got %d", tc.expected, res.StatusCode)
+			}
+
+			if tc.code == "" {
+				return
+			}
+
+			response := &restModel.Error{}
+			if err = json.NewDecoder(res.Body).Decode(response); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if response.Code != tc.code {
+				t.Errorf("expected code %q, got %q", tc.code, response.Code)
+			}
+		})
+	}
+}
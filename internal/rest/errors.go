@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	fiber "github.com/gofiber/fiber/v2"
+	requestid "github.com/gofiber/fiber/v2/middleware/requestid"
+	zap "go.uber.org/zap"
+
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+)
+
+const (
+	detailRetryAfter   = "retry_after"
+	detailMissingCoins = "missing_coins"
+	detailMissingGold  = "missing_gold"
+)
+
+type (
+	// Error is an error of the catalogue: Code is stable for the client logic, Message is for humans
+	// and Details carry the data the client needs to recover, like the seconds to wait before retrying.
+	Error struct {
+		Code    string
+		Status  int
+		Message string
+		Details map[string]interface{}
+		Fields  []*restModel.FieldError
+	}
+)
+
+var (
+	ErrRequestIsInvalid   = &Error{Code: "request_invalid", Status: http.StatusBadRequest, Message: "request is invalid"}
+	ErrInitDataIsRequired = &Error{Code: "init_data_required", Status: http.StatusUnauthorized, Message: "init data is required"}
+	ErrInitDataIsInvalid  = &Error{Code: "init_data_invalid", Status: http.StatusUnauthorized, Message: "init data is invalid"}
+	ErrInitDataIsExpired  = &Error{Code: "init_data_expired", Status: http.StatusUnauthorized, Message: "init data is expired"}
+	ErrNotFound           = &Error{Code: "not_found", Status: http.StatusNotFound, Message: "not found"}
+	ErrCantClickNow       = &Error{Code: "cant_click_now", Status: http.StatusBadRequest, Message: "you can't click now"}
+	ErrCardNotBought      = &Error{Code: "card_not_bought", Status: http.StatusBadRequest, Message: "card is not bought"}
+	ErrNotEnoughCoins     = &Error{Code: "not_enough_coins", Status: http.StatusBadRequest, Message: "not enough coins"}
+	ErrMaxLevelReached    = &Error{Code: "max_level_reached", Status: http.StatusBadRequest, Message: "max level reached"}
+	ErrNotEnoughGold      = &Error{Code: "not_enough_gold", Status: http.StatusBadRequest, Message: "not enough gold"}
+	ErrTaskNotDone        = &Error{Code: "task_not_done", Status: http.StatusBadRequest, Message: "task is not done"}
+	ErrTaskClaimed        = &Error{Code: "task_claimed", Status: http.StatusConflict, Message: "task is already claimed"}
+	ErrCantRefer          = &Error{Code: "cant_refer", Status: http.StatusConflict, Message: "user can't be referred"}
+	ErrInternal           = &Error{Code: "internal", Status: http.StatusInternalServerError, Message: "internal server error"}
+)
+
+func (e *Error) Error() string { return e.Message }
+
+// Is matches the errors by code, so the errors with details still match the catalogue.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+
+	return ok && t.Code == e.Code
+}
+
+// WithDetail returns a copy of the error with the detail added.
+func (e *Error) WithDetail(key string, value interface{}) *Error {
+	res := *e
+	res.Details = make(map[string]interface{}, len(e.Details)+1)
+
+	for k, v := range e.Details {
+		res.Details[k] = v
+	}
+
+	res.Details[key] = value
+
+	return &res
+}
+
+// WithFields returns a copy of the error with the invalid fields of the request.
+func (e *Error) WithFields(fields []*restModel.FieldError) *Error {
+	res := *e
+	res.Fields = fields
+
+	return &res
+}
+
+// toError finds the catalogue error for err. Unknown errors are internal.
+func toError(err error) *Error {
+	var (
+		catalogued   *Error
+		invalid      *validationError
+		clickTimeout *storage.ClickTimeoutError
+		noCoins      *storage.NotEnoughCoinsError
+		noGold       *storage.NotEnoughGoldError
+		fiberError   *fiber.Error
+	)
+
+	switch {
+	case errors.As(err, &catalogued):
+		return catalogued
+	case errors.As(err, &invalid):
+		return ErrRequestIsInvalid.WithFields(invalid.fields)
+	case errors.As(err, &clickTimeout):
+		return ErrCantClickNow.WithDetail(detailRetryAfter, clickTimeout.NextClick-min(clickTimeout.Now, clickTimeout.NextClick))
+	case errors.As(err, &noCoins):
+		return ErrNotEnoughCoins.WithDetail(detailMissingCoins, noCoins.Price.Sub(noCoins.Coins))
+	case errors.As(err, &noGold):
+		return ErrNotEnoughGold.WithDetail(detailMissingGold, noGold.Price-min(noGold.Gold, noGold.Price))
+	case errors.Is(err, storage.ErrCantClickNow):
+		return ErrCantClickNow
+	case errors.Is(err, storage.ErrCardNotBought):
+		return ErrCardNotBought
+	case errors.Is(err, storage.ErrNotEnoughCoins):
+		return ErrNotEnoughCoins
+	case errors.Is(err, storage.ErrNotEnoughGold):
+		return ErrNotEnoughGold
+	case errors.Is(err, storage.ErrMaxLevelReached):
+		return ErrMaxLevelReached
+	case errors.Is(err, storage.ErrTaskNotDone):
+		return ErrTaskNotDone
+	case errors.Is(err, storage.ErrTaskClaimed):
+		return ErrTaskClaimed
+	case errors.Is(err, storage.ErrCantRefer):
+		return ErrCantRefer
+	case errors.Is(err, storage.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.As(err, &fiberError) && fiberError.Code == http.StatusNotFound:
+		return ErrNotFound
+	case errors.As(err, &fiberError) && fiberError.Code < http.StatusInternalServerError:
+		return &Error{Code: "request_failed", Status: fiberError.Code, Message: fiberError.Message}
+	default:
+		return ErrInternal
+	}
+}
+
+// handleError is the fiber error handler: it writes the catalogue error for err.
+// Internal errors are logged with the request id, which is the only thing the client gets about them.
+func (r *REST) handleError(c *fiber.Ctx, err error) error {
+	var (
+		e   = toError(err)
+		rid = getRequestID(c)
+	)
+
+	if e.Status >= http.StatusInternalServerError {
+		r.lgr.Error("error while handling request",
+			zap.String("request_id", rid),
+			zap.String("method", c.Method()),
+			zap.String("path", c.Path()),
+			zap.Error(err),
+		)
+	}
+
+	return c.Status(e.Status).JSON(&restModel.Error{
+		Error:     e.Message,
+		Code:      e.Code,
+		Details:   e.Details,
+		Fields:    e.Fields,
+		RequestID: rid,
+	})
+}
+
+// getRequestID returns the id the requestid middleware gave the request.
+func getRequestID(c *fiber.Ctx) string {
+	rid, _ := c.Locals(requestid.ConfigDefault.ContextKey).(string)
+
+	return rid
+}
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	fiber "github.com/gofiber/fiber/v2"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+)
+
+func TestToError(t *testing.T) {
+	testCases := map[string]struct {
+		err     error
+		code    string
+		status  int
+		details map[string]interface{}
+	}{
+		"catalogue":     {ErrTaskClaimed, ErrTaskClaimed.Code, http.StatusConflict, nil},
+		"validation":    {newValidationError(fieldCardID, ErrorCardIDIsRequired), ErrRequestIsInvalid.Code, http.StatusBadRequest, nil},
+		"click timeout": {&storage.ClickTimeoutError{Now: 10, NextClick: 70}, ErrCantClickNow.Code, http.StatusBadRequest, map[string]interface{}{detailRetryAfter: uint64(60)}},
+		"no coins":      {&storage.NotEnoughCoinsError{Price: amount.New(100), Coins: amount.New(40)}, ErrNotEnoughCoins.Code, http.StatusBadRequest, map[string]interface{}{detailMissingCoins: amount.New(60)}},
+		"no gold":       {&storage.NotEnoughGoldError{Price: 10, Gold: 3}, ErrNotEnoughGold.Code, http.StatusBadRequest, map[string]interface{}{detailMissingGold: uint64(7)}},
+		"sentinel":      {storage.ErrMaxLevelReached, ErrMaxLevelReached.Code, http.StatusBadRequest, nil},
+		"not bought":    {storage.ErrCardNotBought, ErrCardNotBought.Code, http.StatusBadRequest, nil},
+		"cant refer":    {storage.ErrCantRefer, ErrCantRefer.Code, http.StatusConflict, nil},
+		"not found":     {storage.ErrRecordNotFound, ErrNotFound.Code, http.StatusNotFound, nil},
+		"fiber":         {fiber.ErrNotFound, ErrNotFound.Code, http.StatusNotFound, nil},
+		"internal":      {errors.New("pq: connection refused"), ErrInternal.Code, http.StatusInternalServerError, nil},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			e := toError(tc.err)
+
+			if e.Code != tc.code || e.Status != tc.status || len(e.Details) != len(tc.details) {
+				t.Fatalf("unexpected error: %+v", e)
+			}
+
+			for key, value := range tc.details {
+				if e.Details[key] != value {
+					t.Errorf("expected detail %s = %v, got %v", key, value, e.Details[key])
+				}
+			}
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	rst.srv.Post("/api/v1/fail", func(c *fiber.Ctx) error {
+		return errors.New("pq: connection refused")
+	})
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/click", `{"card_id":1}`)
+
+	testCases := map[string]struct {
+		target   string
+		body     string
+		expected int
+		code     string
+		detail   string
+	}{
+		"retry after":   {"/api/v1/click", `{"card_id":1}`, http.StatusBadRequest, ErrCantClickNow.Code, detailRetryAfter},
+		"missing coins": {"/api/v1/buy", `{"card_id":2}`, http.StatusBadRequest, ErrNotEnoughCoins.Code, detailMissingCoins},
+		"not owned":     {"/api/v1/click", `{"card_id":2}`, http.StatusNotFound, ErrNotFound.Code, ""},
+		"internal":      {"/api/v1/fail", ``, http.StatusInternalServerError, ErrInternal.Code, ""},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			response := &restModel.Error{}
+
+			if status := doTestJSONRequest(t, rst, http.MethodPost, tc.target, tc.body, response); status != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, status)
+			}
+
+			if response.Code != tc.code || response.RequestID == "" {
+				t.Fatalf("unexpected error response: %+v", response)
+			}
+
+			if _, ok := response.Details[tc.detail]; tc.detail != "" && !ok {
+				t.Errorf("expected detail %s, got %+v", tc.detail, response.Details)
+			}
+
+			if tc.code == ErrInternal.Code && response.Error != ErrInternal.Message {
+				t.Errorf("expected internal error to be hidden, got %q", response.Error)
+			}
+		})
+	}
+}
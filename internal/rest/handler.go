@@ -2,111 +2,171 @@ package rest
 
 import (
 	"errors"
+	stdmath "math"
 	"net/http"
 	"time"
 
 	fiber "github.com/gofiber/fiber/v2"
 	zap "go.uber.org/zap"
-	gorm "gorm.io/gorm"
 
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
 	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
 	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+	shop "github.com/adzpm/telegram-clicker/internal/shop"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
 )
 
 const (
-	keyError = "error"
-
-	ErrorNotEnoughCoins       = "not enough coins"
-	ErrorCantClickNow         = "you can't click now"
-	ErrorTelegramIDIsRequired = "telegram_id is required"
-	ErrorCardIDIsRequired     = "card_id is required"
+	startCardID uint64 = 1
+
+	ErrorCardIDIsRequired = "card_id is required"
+	ErrorCountIsInvalid   = "count must be a positive number or max"
+	ErrorCountWithMax     = "count can't be set together with max"
+	ErrorBodyIsInvalid    = "body must be a json object with known fields"
+	ErrorItemIDIsRequired = "item_id is required"
+	ErrorItemNotFound     = "item not found"
+	ErrorTaskIDIsRequired = "task_id is required"
+	ErrorTaskNotFound     = "task not found"
+	ErrorTypeIsUnknown    = "type is unknown"
+	ErrorBoardIsUnknown   = "board is unknown"
+	ErrorOffsetIsInvalid  = "offset must be a non-negative number"
+	ErrorLimitIsInvalid   = "limit must be a number from 1 to 100"
 )
 
 func (r *REST) mergeCards(
 	user *storageModel.User,
 	allCards []storageModel.Card,
 	userCards []storageModel.UserCard,
+	coinsMp float64,
 ) map[uint64]*restModel.GameCard {
 	var (
 		cards        = make(map[uint64]*restModel.GameCard, len(allCards))
-		allCardsMap  = make(map[uint64]*storageModel.Card, len(allCards))
 		userCardsMap = make(map[uint64]*storageModel.UserCard, len(userCards))
 	)
 
-	// prepare allCards map
-	for _, card := range allCards {
-		allCardsMap[card.ID] = &card
-	}
-
 	// prepare userCards map
 	for _, userCard := range userCards {
 		userCardsMap[userCard.CardID] = &userCard
 	}
 
-	// fill cards map
+	// fill cards map with userCards data
 	for _, card := range allCards {
-		cards[card.ID] = &restModel.GameCard{
-			ID:                     card.ID,
-			Name:                   card.Name,
-			ImageURL:               card.ImageURL,
-			MaxLevel:               card.MaxLevel,
-			NextLevelPrice:         card.Price,
-			ClickTimeout:           card.ClickTimeout,
-			NextLevelCoinsPerClick: card.CoinsPerClick,
-		}
+		cards[card.ID] = r.createGameCard(user, &card, userCardsMap[card.ID], coinsMp)
 	}
 
-	// update cards map with userCards data
-	for _, userCard := range userCards {
-		if _, ok := cards[userCard.CardID]; !ok {
-			continue
-		}
+	return cards
+}
 
-		if _, ok := allCardsMap[userCard.CardID]; !ok {
-			continue
+// createGameCard creates the card of the game with the user card data. userCard is nil if the card isn't bought.
+func (r *REST) createGameCard(
+	user *storageModel.User,
+	card *storageModel.Card,
+	userCard *storageModel.UserCard,
+	coinsMp float64,
+) *restModel.GameCard {
+	gameCard := &restModel.GameCard{
+		ID:                     card.ID,
+		Name:                   card.Name,
+		ImageURL:               card.ImageURL,
+		MaxLevel:               card.MaxLevel,
+		NextLevelPrice:         card.Price,
+		ClickTimeout:           card.ClickTimeout,
+		NextLevelCoinsPerClick: card.CoinsPerClick,
+		NextMilestoneLevel:     r.mth.CalculateNextMilestoneLevel(0, card.UpgradeLevel),
+		MilestoneMultiplier:    1,
+	}
+
+	if userCard == nil || userCard.Level < 1 {
+		return gameCard
+	}
+
+	level := userCard.Level
+
+	gameCard.CurrentLevel = level
+	gameCard.CurrentPrice = r.mth.CalculateCardUpgradePrice(card, level-1)
+	gameCard.NextLevelPrice = r.mth.CalculateCardUpgradePrice(card, level)
+	gameCard.CurrentCoinsPerClick = r.mth.CalculateCardClickCoins(card, level, user.Investors, coinsMp)
+	gameCard.NextLevelCoinsPerClick = r.mth.CalculateCardClickCoins(card, level+1, user.Investors, coinsMp)
+	gameCard.NextClick = userCard.NextClick
+	gameCard.LastClick = userCard.LastClick
+	gameCard.ClickTimeout = r.mth.CalculateCardClickTimeout(card, level)
+	gameCard.NextMilestoneLevel = r.mth.CalculateNextMilestoneLevel(level, card.UpgradeLevel)
+	gameCard.MilestoneMultiplier, _ = r.mth.CalculateCardMilestoneMultipliers(card, level)
+	gameCard.IsMaxed = r.calculateUpgradeLimit(card, level) == 0
+
+	return gameCard
+}
+
+// clickCoins returns the function calculating the number of coins the user earns by clicking the card
+// with the purchased coinsMp and the timeout before the next click.
+func (r *REST) clickCoins(coinsMp float64) storage.ClickFunc {
+	return func(user *storageModel.User, card *storageModel.Card, userCard *storageModel.UserCard) (amount.Amount, uint64) {
+		return r.mth.CalculateCardClickCoins(card, userCard.Level, user.Investors, coinsMp), r.mth.CalculateCardClickTimeout(card, userCard.Level)
+	}
+}
+
+// calculateUpgradeLimit returns how many levels of the card can be bought before the max level.
+func (r *REST) calculateUpgradeLimit(card *storageModel.Card, level uint64) uint64 {
+	if card.MaxLevel == 0 {
+		return stdmath.MaxUint32
+	}
+
+	if level >= card.MaxLevel {
+		return 0
+	}
+
+	return card.MaxLevel - level
+}
+
+// buyLevels returns the function calculating the levels to buy and their total price.
+// count 0 buys as many levels as the user can afford, otherwise count is capped by the max level.
+func (r *REST) buyLevels(count uint64) storage.BuyFunc {
+	return func(user *storageModel.User, card *storageModel.Card, userCard *storageModel.UserCard) (levels uint64, price amount.Amount, err error) {
+		limit := r.calculateUpgradeLimit(card, userCard.Level)
+
+		if limit == 0 {
+			return 0, amount.Zero, storage.ErrMaxLevelReached
 		}
 
-		if userCard.Level < 1 {
-			continue
+		if levels = min(count, limit); count == 0 {
+			levels = r.mth.CalculateCardMaxUpgrades(card, userCard.Level, limit, user.Coins)
 		}
 
-		var (
-			cardID             = userCard.CardID
-			level              = userCard.Level
-			nextClick          = userCard.NextClick
-			lastClick          = userCard.LastClick
-			startPrice         = allCardsMap[cardID].Price
-			startCoinsPerClick = allCardsMap[cardID].CoinsPerClick
-			priceMp            = allCardsMap[cardID].PriceMultiplier
-			invMp              = r.mth.CalculateInvestorsMultiplier(user.Investors)
-			nextPrice          = r.mth.CalculateUpgradePrice(startPrice, level, priceMp)
-			curPrice           = r.mth.CalculateUpgradePrice(startPrice, level-1, priceMp)
-			nextCoins          = r.mth.CalculateAlgebraCoinsPerClick(startCoinsPerClick, level+1, invMp)
-			curCoins           = r.mth.CalculateAlgebraCoinsPerClick(startCoinsPerClick, level, invMp)
-		)
+		if levels == 0 {
+			return 0, amount.Zero, &storage.NotEnoughCoinsError{
+				Price: r.mth.CalculateCardUpgradePrice(card, userCard.Level),
+				Coins: user.Coins,
+			}
+		}
 
-		cards[cardID].CurrentLevel = level
-		cards[cardID].CurrentPrice = curPrice
-		cards[cardID].NextLevelPrice = nextPrice
-		cards[cardID].CurrentCoinsPerClick = curCoins
-		cards[cardID].NextLevelCoinsPerClick = nextCoins
-		cards[cardID].NextClick = nextClick
-		cards[cardID].LastClick = lastClick
+		return levels, r.mth.CalculateCardUpgradePriceSum(card, userCard.Level, levels), nil
 	}
+}
 
-	return cards
+// calculateReset returns the number of investors the user has after the reset and the progress of the tasks,
+// both calculated from the locked state the reset drops.
+func (r *REST) calculateReset(user *storageModel.User, userCards []storageModel.UserCard) (uint64, map[uint64]uint64) {
+	return r.mth.CalculateInvestorsCount(user.EarnedCoins), r.calculateTasksProgress(user, userCards)
 }
 
-func (r *REST) createGameResponse(user *storageModel.User, allCards []storageModel.Card, userCards []storageModel.UserCard) *restModel.Game {
+func (r *REST) createGameResponse(
+	user *storageModel.User,
+	allCards []storageModel.Card,
+	userCards []storageModel.UserCard,
+	purchases []storageModel.UserPurchase,
+	now uint64,
+) *restModel.Game {
 	var (
-		icount = r.mth.CalculateInvestorsCount(user.EarnedCoins)
-		curmlt = r.mth.CalculateInvestorsMultiplier(user.Investors)
-		nxtmlt = r.mth.CalculateInvestorsMultiplier(icount)
+		icount  = r.mth.CalculateInvestorsCount(user.EarnedCoins)
+		curmlt  = r.mth.CalculateInvestorsMultiplier(user.Investors)
+		nxtmlt  = r.mth.CalculateInvestorsMultiplier(icount)
+		effects = createGameEffects(purchases, now)
 	)
 
 	return &restModel.Game{
 		UserID:                        user.ID,
 		TelegramID:                    user.TelegramID,
+		Version:                       user.Version,
 		LastSeen:                      user.LastSeen,
 		CurrentCoins:                  user.Coins,
 		CurrentGold:                   user.Gold,
@@ -115,321 +175,376 @@ func (r *REST) createGameResponse(user *storageModel.User, allCards []storageMod
 		InvestorsMultiplierAfterReset: nxtmlt,
 		InvestorsAfterReset:           icount,
 		PercentsPerInvestor:           uint64(r.mth.GetGameVariables().PercentsForInvestor * 100),
-		Cards:                         r.mergeCards(user, allCards, userCards),
+		Cards:                         r.mergeCards(user, allCards, userCards, effects.CoinsMultiplier),
+		Effects:                       effects,
 	}
 }
 
-func Throw500Error(c *fiber.Ctx, dst interface{}) (err error) {
-	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{keyError: dst})
-}
-
-func Throw400Error(c *fiber.Ctx, dst interface{}) (err error) {
-	return c.Status(http.StatusBadRequest).JSON(fiber.Map{keyError: dst})
-}
-
-func Throw200Response(c *fiber.Ctx, dst interface{}) (err error) {
-	return c.Status(http.StatusOK).JSON(dst)
-}
-
-func (r *REST) EnterGame(c *fiber.Ctx) (err error) {
+func (r *REST) calculateOfflineEarnings(
+	user *storageModel.User,
+	allCards []storageModel.Card,
+	userCards []storageModel.UserCard,
+	now uint64,
+) *restModel.OfflineEarnings {
 	var (
-		user *storageModel.User
-		tgID int
+		offlineTime  = r.mth.CalculateOfflineTime(user.LastSeen, now)
+		allCardsMap  = make(map[uint64]storageModel.Card, len(allCards))
+		offlineCoins = &restModel.OfflineEarnings{
+			OfflineTime: offlineTime,
+			Cards:       make(map[uint64]*restModel.OfflineCardEarnings, len(userCards)),
+		}
 	)
 
-	if tgID = c.QueryInt("telegram_id"); tgID == 0 {
-		return Throw400Error(c, ErrorTelegramIDIsRequired)
+	if offlineTime == 0 {
+		return offlineCoins
+	}
+
+	for _, card := range allCards {
+		allCardsMap[card.ID] = card
 	}
 
-	r.lgr.Info("try to enter game", zap.Int("telegram_id", tgID))
+	for _, userCard := range userCards {
+		card, ok := allCardsMap[userCard.CardID]
+		if !ok || userCard.Level < 1 {
+			continue
+		}
 
-	if user, err = r.str.SelectUser(uint64(tgID)); err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			r.lgr.Warn("error while selecting user. Try to create new account", zap.Error(err))
+		var (
+			timeout = r.mth.CalculateCardClickTimeout(&card, userCard.Level)
+			clicks  = r.mth.CalculateOfflineClicks(now-offlineTime, now, userCard.NextClick, timeout)
+			coins   = r.mth.CalculateCardClickCoins(&card, userCard.Level, user.Investors, 1).Mul(clicks)
+		)
 
-			if user, err = r.str.InsertUser(uint64(tgID), 0, 1000, 0); err != nil {
-				return Throw500Error(c, err)
-			}
+		if clicks == 0 {
+			continue
+		}
 
-			if _, err = r.str.InsertUserCard(user.TelegramID, 1, 1); err != nil {
-				return Throw500Error(c, err)
-			}
-		} else {
-			return Throw500Error(c, err)
+		offlineCoins.Coins = offlineCoins.Coins.Add(coins)
+		offlineCoins.Cards[card.ID] = &restModel.OfflineCardEarnings{
+			CardID: card.ID,
+			Clicks: clicks,
+			Coins:  coins,
 		}
 	}
 
+	return offlineCoins
+}
+
+func Throw200Response(c *fiber.Ctx, dst interface{}) (err error) {
+	return c.Status(http.StatusOK).JSON(dst)
+}
+
+// selectGame selects the rest of the game state of the user and creates the game response.
+// userCards are selected from the storage if they are nil.
+func (r *REST) selectGame(user *storageModel.User, userCards []storageModel.UserCard, now uint64) (_ *restModel.Game, err error) {
 	var (
-		timeNow   = uint64(time.Now().Unix())
 		allCards  []storageModel.Card
-		userCards []storageModel.UserCard
+		purchases []storageModel.UserPurchase
 	)
 
 	if allCards, err = r.str.SelectCards(); err != nil {
-		return Throw500Error(c, err)
+		return nil, err
 	}
 
-	if userCards, err = r.str.SelectUserCards(user.TelegramID); err != nil {
-		return Throw500Error(c, err)
+	if userCards == nil {
+		if userCards, err = r.str.SelectUserCards(user.TelegramID); err != nil {
+			return nil, err
+		}
 	}
 
-	// add coins for offline time
-
-	if user, err = r.str.UpdateUserLastSeen(user.TelegramID, timeNow); err != nil {
-		return Throw500Error(c, err)
+	if purchases, err = r.str.SelectUserPurchases(user.TelegramID, now); err != nil {
+		return nil, err
 	}
 
-	return Throw200Response(c, r.createGameResponse(user, allCards, userCards))
+	return r.createGameResponse(user, allCards, userCards, purchases, now), nil
 }
 
-func (r *REST) ClickCard(c *fiber.Ctx) (err error) {
+// selectGameDiff creates the diff of the game after the action changed the user and the only user card.
+// Unlike selectGame it doesn't select all the cards, so the other cards must be unchanged by the action.
+func (r *REST) selectGameDiff(user *storageModel.User, userCard *storageModel.UserCard, now uint64) (_ *restModel.GameDiff, err error) {
 	var (
-		tn   = uint64(time.Now().Unix())
-		tgID int
-		cdID int
+		card      *storageModel.Card
+		purchases []storageModel.UserPurchase
 	)
 
-	if tgID = c.QueryInt("telegram_id"); tgID == 0 {
-		return Throw400Error(c, ErrorTelegramIDIsRequired)
+	if card, err = r.str.SelectCard(userCard.CardID); err != nil {
+		return nil, err
 	}
 
-	if cdID = c.QueryInt("card_id"); cdID == 0 {
-		return Throw400Error(c, ErrorCardIDIsRequired)
+	if purchases, err = r.str.SelectUserPurchases(user.TelegramID, now); err != nil {
+		return nil, err
 	}
 
-	r.lgr.Info("try to click", zap.Int("telegram_id", tgID), zap.Int("card_id", cdID))
-
 	var (
-		user         *storageModel.User
-		card         *storageModel.Card
-		userCard     *storageModel.UserCard
-		coinsClicked uint64 = 0
+		effects = createGameEffects(purchases, now)
+		icount  = r.mth.CalculateInvestorsCount(user.EarnedCoins)
 	)
 
-	if user, err = r.str.SelectUser(uint64(tgID)); err != nil {
-		return Throw500Error(c, err)
-	}
+	return &restModel.GameDiff{
+		Version:                       user.Version,
+		CurrentCoins:                  user.Coins,
+		CurrentGold:                   user.Gold,
+		CurrentInvestors:              user.Investors,
+		InvestorsAfterReset:           icount,
+		CurrentInvestorsMultiplier:    r.mth.CalculateInvestorsMultiplier(user.Investors),
+		InvestorsMultiplierAfterReset: r.mth.CalculateInvestorsMultiplier(icount),
+		Cards:                         map[uint64]*restModel.GameCard{card.ID: r.createGameCard(user, card, userCard, effects.CoinsMultiplier)},
+		Effects:                       effects,
+	}, nil
+}
 
-	if card, err = r.str.SelectCard(uint64(cdID)); err != nil {
-		return Throw500Error(c, err)
+// createUser creates the user with the start card, invited by the referrer of the start param if any.
+// If the concurrent enter has created the user first, that user is returned.
+func (r *REST) createUser(tgID uint64, startParam string) (user *storageModel.User, err error) {
+	user, err = r.str.InsertUser(tgID, amount.Zero, 1000, 0)
+	if errors.Is(err, storage.ErrDuplicatedKey) {
+		return r.str.SelectUser(tgID)
 	}
 
-	if userCard, err = r.str.SelectUserCard(user.TelegramID, card.ID); err != nil {
-		return Throw500Error(c, err)
+	if err != nil {
+		return nil, err
 	}
 
-	if userCard.Level > 0 {
-		coinsClicked = r.mth.CalculateAlgebraCoinsPerClick(
-			card.CoinsPerClick,
-			userCard.Level,
-			r.mth.CalculateInvestorsMultiplier(user.Investors),
-		)
+	if _, err = r.str.InsertUserCard(user.TelegramID, startCardID, 1); err != nil {
+		return nil, err
 	}
 
-	if userCard.NextClick == 0 {
-		userCard.NextClick = tn
-	}
+	return r.refer(user, startParam, uint64(time.Now().Unix()))
+}
 
-	if tn < userCard.NextClick {
-		return Throw400Error(c, ErrorCantClickNow)
-	}
+// enterGame creates the game of the new user, invited by the referrer of the start param if any,
+// or gives the returning one the coins earned while offline.
+func (r *REST) enterGame(tgID uint64, startParam string) (_ *restModel.Game, err error) {
+	var user *storageModel.User
 
-	if user, err = r.str.UpdateUserCoins(user.TelegramID, user.Coins+coinsClicked); err != nil {
-		return Throw500Error(c, err)
-	}
+	r.lgr.Info("try to enter game", zap.Uint64("telegram_id", tgID))
 
-	if user, err = r.str.UpdateUserEarnedCoins(user.TelegramID, user.EarnedCoins+coinsClicked); err != nil {
-		return Throw500Error(c, err)
-	}
+	if user, err = r.str.SelectUser(tgID); err != nil {
+		if !errors.Is(err, storage.ErrRecordNotFound) {
+			return nil, err
+		}
 
-	if userCard, err = r.str.UpdateUserCardLastClick(user.TelegramID, card.ID, tn); err != nil {
-		return Throw500Error(c, err)
-	}
+		r.lgr.Warn("error while selecting user. Try to create new account", zap.Error(err))
 
-	if userCard, err = r.str.UpdateUserCardNextClick(user.TelegramID, card.ID, tn+card.ClickTimeout); err != nil {
-		return Throw500Error(c, err)
+		if user, err = r.createUser(tgID, startParam); err != nil {
+			return nil, err
+		}
 	}
 
 	var (
-		allCards  []storageModel.Card
-		userCards []storageModel.UserCard
+		timeNow         = uint64(time.Now().Unix())
+		allCards        []storageModel.Card
+		userCards       []storageModel.UserCard
+		game            *restModel.Game
+		offlineEarnings *restModel.OfflineEarnings
 	)
 
 	if allCards, err = r.str.SelectCards(); err != nil {
-		return Throw500Error(c, err)
+		return nil, err
 	}
 
-	if userCards, err = r.str.SelectUserCards(user.TelegramID); err != nil {
-		return Throw500Error(c, err)
-	}
+	// the offline time is calculated from the last seen time locked with the user,
+	// so the concurrent enters give the coins for it once
+	user, userCards, err = r.str.Enter(user.TelegramID, timeNow, func(user *storageModel.User, userCards []storageModel.UserCard) amount.Amount {
+		offlineEarnings = r.calculateOfflineEarnings(user, allCards, userCards, timeNow)
 
-	return Throw200Response(c, r.createGameResponse(user, allCards, userCards))
-}
-
-func (r *REST) BuyCard(c *fiber.Ctx) (err error) {
-	var (
-		tgID int
-		prID int
-	)
+		return offlineEarnings.Coins
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	if tgID = c.QueryInt("telegram_id"); tgID == 0 {
-		return Throw400Error(c, ErrorTelegramIDIsRequired)
+	if !offlineEarnings.Coins.IsZero() {
+		r.lgr.Info("added coins for offline time",
+			zap.Uint64("telegram_id", user.TelegramID),
+			zap.Uint64("offline_time", offlineEarnings.OfflineTime),
+			zap.Stringer("coins", offlineEarnings.Coins),
+		)
 	}
 
-	if prID = c.QueryInt("card_id"); prID == 0 {
-		return Throw400Error(c, ErrorCardIDIsRequired)
+	if game, err = r.selectGame(user, userCards, timeNow); err != nil {
+		return nil, err
 	}
 
-	r.lgr.Info("try to buy card", zap.Int("telegram_id", tgID), zap.Int("card_id", prID))
+	game.OfflineEarnings = offlineEarnings
 
-	var (
-		user      *storageModel.User
-		card      *storageModel.Card
-		userCard  *storageModel.UserCard
-		allCards  []storageModel.Card
-		userCards []storageModel.UserCard
-	)
-
-	if user, err = r.str.SelectUser(uint64(tgID)); err != nil {
-		return Throw500Error(c, err)
-	}
+	return game, nil
+}
 
-	if card, err = r.str.SelectCard(uint64(prID)); err != nil {
-		return Throw500Error(c, err)
+// click clicks the card, spending a timeout skip if the request asks to.
+func (r *REST) click(tgID uint64, req *restModel.ClickRequest, now uint64) (user *storageModel.User, userCard *storageModel.UserCard, err error) {
+	if err = validateClickRequest(req); err != nil {
+		return nil, nil, err
 	}
 
-	if allCards, err = r.str.SelectCards(); err != nil {
-		return Throw500Error(c, err)
-	}
+	r.lgr.Info("try to click", zap.Uint64("telegram_id", tgID), zap.Uint64("card_id", req.CardID))
 
-	if userCards, err = r.str.SelectUserCards(user.TelegramID); err != nil {
-		return Throw500Error(c, err)
+	var purchases []storageModel.UserPurchase
 
+	if purchases, err = r.str.SelectUserPurchases(tgID, now); err != nil {
+		return nil, nil, err
 	}
 
-	if userCard, err = r.str.SelectUserCard(user.TelegramID, card.ID); err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			priceToBuy := r.mth.CalculateUpgradePrice(card.Price, 0, card.PriceMultiplier)
-
-			if user.Coins < priceToBuy {
-				return Throw400Error(c, ErrorNotEnoughCoins)
-			}
-
-			if userCard, err = r.str.InsertUserCard(user.TelegramID, card.ID, 1); err != nil {
-				return Throw500Error(c, err)
-			}
+	coinsMp, _ := shop.CoinsMultiplier(purchases, now)
 
-			if user, err = r.str.UpdateUserCoins(user.TelegramID, user.Coins-priceToBuy); err != nil {
-				return Throw500Error(c, err)
-			}
+	return r.str.Click(tgID, req.CardID, now, req.SkipTimeout, r.clickCoins(coinsMp))
+}
 
-			if userCards, err = r.str.SelectUserCards(user.TelegramID); err != nil {
-				return Throw500Error(c, err)
-			}
+// clickCard clicks the card and returns the whole game.
+func (r *REST) clickCard(tgID uint64, req *restModel.ClickRequest) (_ *restModel.Game, err error) {
+	var (
+		tn   = uint64(time.Now().Unix())
+		user *storageModel.User
+	)
 
-			return Throw200Response(c, r.createGameResponse(user, allCards, userCards))
-		} else {
-			return Throw500Error(c, err)
-		}
+	if user, _, err = r.click(tgID, req, tn); err != nil {
+		return nil, err
 	}
 
-	priceToBuy := r.mth.CalculateUpgradePrice(
-		card.Price,
-		userCard.Level,
-		card.PriceMultiplier,
+	return r.selectGame(user, nil, tn)
+}
+
+// clickCardCompact clicks the card and returns the diff with the clicked card only.
+func (r *REST) clickCardCompact(tgID uint64, req *restModel.ClickRequest) (_ *restModel.GameDiff, err error) {
+	var (
+		tn       = uint64(time.Now().Unix())
+		user     *storageModel.User
+		userCard *storageModel.UserCard
 	)
 
-	if user.Coins < priceToBuy {
-		return Throw400Error(c, ErrorNotEnoughCoins)
+	if user, userCard, err = r.click(tgID, req, tn); err != nil {
+		return nil, err
 	}
 
-	if user, err = r.str.UpdateUserCoins(user.TelegramID, user.Coins-priceToBuy); err != nil {
-		return Throw500Error(c, err)
+	return r.selectGameDiff(user, userCard, tn)
+}
+
+// buy buys the levels of the card, as many as the user can afford if the request asks for max.
+func (r *REST) buy(tgID uint64, req *restModel.BuyRequest) (user *storageModel.User, userCard *storageModel.UserCard, err error) {
+	if err = validateBuyRequest(req); err != nil {
+		return nil, nil, err
 	}
 
-	if userCard, err = r.str.UpdateUserCardLevel(user.TelegramID, card.ID, userCard.Level+1); err != nil {
-		return Throw500Error(c, err)
+	count := max(req.Count, 1)
+
+	if req.Max {
+		count = 0
 	}
 
-	if userCards, err = r.str.SelectUserCards(user.TelegramID); err != nil {
-		return Throw500Error(c, err)
+	r.lgr.Info("try to buy card", zap.Uint64("telegram_id", tgID), zap.Uint64("card_id", req.CardID), zap.Uint64("count", count))
+
+	return r.str.Buy(tgID, req.CardID, r.buyLevels(count))
+}
+
+// buyCard buys the levels of the card and returns the whole game.
+func (r *REST) buyCard(tgID uint64, req *restModel.BuyRequest) (_ *restModel.Game, err error) {
+	var (
+		tn   = uint64(time.Now().Unix())
+		user *storageModel.User
+	)
+
+	if user, _, err = r.buy(tgID, req); err != nil {
+		return nil, err
 	}
 
-	return Throw200Response(c, r.createGameResponse(user, allCards, userCards))
+	return r.selectGame(user, nil, tn)
 }
 
-func (r *REST) ResetGame(c *fiber.Ctx) (err error) {
+// buyCardCompact buys the levels of the card and returns the diff with the bought card only.
+func (r *REST) buyCardCompact(tgID uint64, req *restModel.BuyRequest) (_ *restModel.GameDiff, err error) {
 	var (
-		tgID int
+		tn       = uint64(time.Now().Unix())
+		user     *storageModel.User
+		userCard *storageModel.UserCard
 	)
 
-	if tgID = c.QueryInt("telegram_id"); tgID == 0 {
-		return Throw400Error(c, ErrorTelegramIDIsRequired)
+	if user, userCard, err = r.buy(tgID, req); err != nil {
+		return nil, err
 	}
 
-	r.lgr.Info("try to reset game", zap.Int("telegram_id", tgID))
+	return r.selectGameDiff(user, userCard, tn)
+}
 
+// resetGame resets the game progress of the user for the investors. The tasks progress is saved by the reset
+// itself, so the progress of the earned coins and the card levels is calculated from the state the reset drops.
+func (r *REST) resetGame(tgID uint64) (_ *restModel.Game, err error) {
 	var (
+		tn        = uint64(time.Now().Unix())
 		user      *storageModel.User
-		allCards  []storageModel.Card
 		userCards []storageModel.UserCard
 	)
 
-	if user, err = r.str.SelectUser(uint64(tgID)); err != nil {
-		return Throw500Error(c, err)
-	}
+	r.lgr.Info("try to reset game", zap.Uint64("telegram_id", tgID))
 
-	if user, err = r.str.UpdateUserInvestors(user.TelegramID, r.mth.CalculateInvestorsCount(user.EarnedCoins)); err != nil {
-		return Throw500Error(c, err)
+	if user, userCards, err = r.str.Prestige(tgID, startCardID, r.calculateReset); err != nil {
+		return nil, err
 	}
 
-	if allCards, err = r.str.SelectCards(); err != nil {
-		return Throw500Error(c, err)
+	return r.selectGame(user, userCards, tn)
+}
+
+func (r *REST) EnterGame(c *fiber.Ctx) (err error) {
+	game, err := r.enterGame(getTelegramID(c), getInitData(c).StartParam)
+	if err != nil {
+		return err
 	}
 
-	if userCards, err = r.str.SelectUserCards(user.TelegramID); err != nil {
-		return Throw500Error(c, err)
+	return r.sendGame(c, game)
+}
+
+func (r *REST) ClickCard(c *fiber.Ctx) (err error) {
+	req := &restModel.ClickRequest{}
+
+	if err = bindJSON(c, req); err != nil {
+		return err
 	}
 
-	if user, err = r.str.UpdateUserEarnedCoins(user.TelegramID, 0); err != nil {
-		return Throw500Error(c, err)
+	if req.Compact {
+		diff, err := r.clickCardCompact(getTelegramID(c), req)
+		if err != nil {
+			return err
+		}
+
+		return r.sendGameDiff(c, diff)
 	}
 
-	if user, err = r.str.UpdateUserCoins(user.TelegramID, 0); err != nil {
-		return Throw500Error(c, err)
+	game, err := r.clickCard(getTelegramID(c), req)
+	if err != nil {
+		return err
 	}
 
-	for _, userCard := range userCards {
-		if userCard.Level > 0 {
-			if _, err = r.str.UpdateUserCardLevel(user.TelegramID, userCard.CardID, 0); err != nil {
-				return Throw500Error(c, err)
-			}
+	return r.sendGame(c, game)
+}
 
-			if _, err = r.str.UpdateUserCardLastClick(user.TelegramID, userCard.CardID, 0); err != nil {
-				return Throw500Error(c, err)
-			}
+func (r *REST) BuyCard(c *fiber.Ctx) (err error) {
+	req := &restModel.BuyRequest{}
 
-			if _, err = r.str.UpdateUserCardNextClick(user.TelegramID, userCard.CardID, 0); err != nil {
-				return Throw500Error(c, err)
-			}
-		}
+	if err = bindJSON(c, req); err != nil {
+		return err
 	}
 
-	if _, err = r.str.UpdateUserCardLevel(user.TelegramID, 1, 1); err != nil {
-		return Throw500Error(c, err)
-	}
+	if req.Compact {
+		diff, err := r.buyCardCompact(getTelegramID(c), req)
+		if err != nil {
+			return err
+		}
 
-	if user, err = r.str.SelectUser(user.TelegramID); err != nil {
-		return Throw500Error(c, err)
+		return r.sendGameDiff(c, diff)
 	}
 
-	if allCards, err = r.str.SelectCards(); err != nil {
-		return Throw500Error(c, err)
+	game, err := r.buyCard(getTelegramID(c), req)
+	if err != nil {
+		return err
 	}
 
-	if userCards, err = r.str.SelectUserCards(user.TelegramID); err != nil {
-		return Throw500Error(c, err)
+	return r.sendGame(c, game)
+}
+
+func (r *REST) ResetGame(c *fiber.Ctx) (err error) {
+	game, err := r.resetGame(getTelegramID(c))
+	if err != nil {
+		return err
 	}
 
-	return Throw200Response(c, r.createGameResponse(user, allCards, userCards))
+	return r.sendGame(c, game)
 }
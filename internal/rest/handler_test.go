@@ -0,0 +1,474 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	leaderboard "github.com/adzpm/telegram-clicker/internal/leaderboard"
+	math "github.com/adzpm/telegram-clicker/internal/math"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+	shop "github.com/adzpm/telegram-clicker/internal/shop"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+	task "github.com/adzpm/telegram-clicker/internal/task"
+)
+
+const testTelegramID = 42
+
+var testCards = []storageModel.Card{
+	{ID: 1, Name: "Card 1", Price: amount.New(5), PriceMultiplier: 1.25, CoinsPerClick: amount.New(1), ClickTimeout: 100, UpgradeLevel: 50, MaxLevel: 1000},
+	{ID: 2, Name: "Card 2", Price: amount.New(60), PriceMultiplier: 1.25, CoinsPerClick: amount.New(30), ClickTimeout: 200, UpgradeLevel: 50, MaxLevel: 1000},
+	{ID: 3, Name: "Card 3", Price: amount.New(10), PriceMultiplier: 2, CoinsPerClick: amount.New(5), ClickTimeout: 10, UpgradeLevel: 2, MaxLevel: 3},
+}
+
+var testShopItems = []shop.Item{
+	{ID: 1, Name: "Refresh", Type: shop.ItemTypeClickRefresh, Price: 20},
+	{ID: 2, Name: "x2", Type: shop.ItemTypeCoinsMultiplier, Price: 50, Value: 2, Duration: 600},
+	{ID: 3, Name: "Skip", Type: shop.ItemTypeSkipTimeout, Price: 30, Value: 1},
+	{ID: 4, Name: "Pack", Type: shop.ItemTypeCoinsPack, Price: 1000, Value: 100},
+}
+
+var testTasks = []task.Task{
+	{ID: 1, Name: "Clicks", Type: task.TypeClicks, Target: 1, RewardCoins: amount.New(100)},
+	{ID: 2, Name: "Card", Type: task.TypeCardLevel, CardID: 2, Target: 1, RewardGold: 10},
+	{ID: 3, Name: "Prestige", Type: task.TypePrestige, Target: 1, RewardGold: 20},
+	{ID: 4, Name: "Channel", Type: task.TypeJoinChannel, Channel: "@test", RewardGold: 30},
+}
+
+// newTestREST creates the REST server backed by the in-memory storage.
+func newTestREST(t *testing.T) (*REST, storage.Storage) {
+	t.Helper()
+
+	return newTestRESTWithRoutes(t, false)
+}
+
+// newTestRESTWithRoutes creates the REST server backed by the in-memory storage, optionally with the legacy routes.
+func newTestRESTWithRoutes(t *testing.T, legacyRoutes bool) (*REST, storage.Storage) {
+	t.Helper()
+
+	shp, err := shop.New(testShopItems)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tsk, err := task.New(testTasks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var (
+		str = storage.NewMemory(zap.NewNop(), testCards)
+		mth = math.New(&config.GameVariables{
+			EarnedCoinsForInvestor: 100,
+			PercentsForInvestor:    0.02,
+			MaxOfflineTime:         3600,
+			Referral:               config.Referral{ReferrerGold: 100, RefereeGold: 50, Percents: 0.1},
+		})
+		ldb = leaderboard.New(zap.NewNop(), str, time.Minute)
+		rst = New(zap.NewNop(), str, mth, shp, tsk, ldb, &config.REST{
+			WebPath:      t.TempDir(),
+			BotToken:     testBotToken,
+			AuthMaxAge:   3600,
+			LegacyRoutes: legacyRoutes,
+		})
+	)
+
+	rst.chk = testChannelChecker{}
+	rst.setupRoutes(context.Background())
+
+	return rst, str
+}
+
+// doTestRequest sends the request signed as the test user and decodes the game response.
+func doTestRequest(t *testing.T, rst *REST, method, target, body string) (int, *restModel.Game) {
+	t.Helper()
+
+	game := &restModel.Game{}
+
+	if status := doTestJSONRequest(t, rst, method, target, body, game); status != http.StatusOK {
+		return status, nil
+	}
+
+	return http.StatusOK, game
+}
+
+// doTestJSONRequest sends the request with the JSON body signed as the test user
+// and decodes the JSON response into dst, whether it's successful or not.
+func doTestJSONRequest(t *testing.T, rst *REST, method, target, body string, dst interface{}) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(headerInitData, signTestInitData(testBotToken, time.Now(), map[string]string{
+		"user": `{"id":` + strconv.Itoa(testTelegramID) + `}`,
+	}))
+
+	res, err := rst.srv.Test(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if !strings.HasPrefix(res.Header.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		return res.StatusCode
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return res.StatusCode
+}
+
+func TestEnterGame(t *testing.T) {
+	rst, str := newTestREST(t)
+
+	status, game := doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if game.TelegramID != testTelegramID || game.CurrentGold != 1000 || game.CurrentCoins != amount.New(0) {
+		t.Errorf("unexpected new game: %+v", game)
+	}
+
+	if game.Cards[1].CurrentLevel != 1 || game.Cards[2].CurrentLevel != 0 {
+		t.Errorf("expected only the start card to be bought, got %+v %+v", game.Cards[1], game.Cards[2])
+	}
+
+	// pretend the user left an hour ago with card 1 ready to click
+	if _, err := str.UpdateUserLastSeen(testTelegramID, uint64(time.Now().Add(-time.Hour).Unix())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	status, game = doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if game.OfflineEarnings == nil || game.OfflineEarnings.Coins != amount.New(36) || game.CurrentCoins != amount.New(36) {
+		t.Errorf("expected 36 offline coins, got %+v", game.OfflineEarnings)
+	}
+
+	// the enter which lost the race to create the user gets the created one
+	if user, err := rst.createUser(testTelegramID, ""); err != nil || user.Coins != amount.New(36) {
+		t.Errorf("expected the existing user, got %+v %v", user, err)
+	}
+}
+
+func TestClickCard(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected int
+		coins    uint64
+	}{
+		{"card id is required", http.MethodPost, "/api/v1/click", ``, http.StatusBadRequest, 0},
+		{"first click", http.MethodPost, "/api/v1/click", `{"card_id":1}`, http.StatusOK, 1},
+		{"click before timeout", http.MethodPost, "/api/v1/click", `{"card_id":1}`, http.StatusBadRequest, 0},
+		{"card is not bought", http.MethodPost, "/api/v1/click", `{"card_id":2}`, http.StatusNotFound, 0},
+	}
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, game := doTestRequest(t, rst, tc.method, tc.target, tc.body)
+			if status != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, status)
+			}
+
+			if game != nil && game.CurrentCoins != amount.New(tc.coins) {
+				t.Errorf("expected %d coins, got %s", tc.coins, game.CurrentCoins)
+			}
+		})
+	}
+}
+
+func TestBuyCard(t *testing.T) {
+	rst, str := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	if status, _ := doTestRequest(t, rst, http.MethodPost, "/api/v1/buy", `{"card_id":2}`); status != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, status)
+	}
+
+	if _, err := str.UpdateUserCoins(testTelegramID, amount.New(100)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	status, game := doTestRequest(t, rst, http.MethodPost, "/api/v1/buy", `{"card_id":2}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if game.CurrentCoins != amount.New(40) || game.Cards[2].CurrentLevel != 1 {
+		t.Errorf("expected 40 coins and card level 1, got %s and %d", game.CurrentCoins, game.Cards[2].CurrentLevel)
+	}
+
+	status, game = doTestRequest(t, rst, http.MethodPost, "/api/v1/buy", `{"card_id":1}`)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if game.CurrentCoins != amount.New(34) || game.Cards[1].CurrentLevel != 2 {
+		t.Errorf("expected 34 coins and card level 2, got %s and %d", game.CurrentCoins, game.Cards[1].CurrentLevel)
+	}
+}
+
+func TestBuyCardCount(t *testing.T) {
+	rst, str := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	if _, err := str.UpdateUserCoins(testTelegramID, amount.New(1000)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected int
+		cardID   uint64
+		coins    uint64
+		level    uint64
+		isMaxed  bool
+	}{
+		{"invalid count", http.MethodPost, "/api/v1/buy", `{"card_id":3,"count":"abc"}`, http.StatusBadRequest, 0, 0, 0, false},
+		{"count with max", http.MethodPost, "/api/v1/buy", `{"card_id":3,"count":2,"max":true}`, http.StatusBadRequest, 0, 0, 0, false},
+		{"buy 2 levels", http.MethodPost, "/api/v1/buy", `{"card_id":3,"count":2}`, http.StatusOK, 3, 970, 2, false},
+		{"buy 10 levels capped by max level", http.MethodPost, "/api/v1/buy", `{"card_id":3,"count":10}`, http.StatusOK, 3, 930, 3, true},
+		{"max level reached", http.MethodPost, "/api/v1/buy", `{"card_id":3}`, http.StatusBadRequest, 0, 0, 0, false},
+		{"max level reached / buy max", http.MethodPost, "/api/v1/buy", `{"card_id":3,"max":true}`, http.StatusBadRequest, 0, 0, 0, false},
+		{"buy max", http.MethodPost, "/api/v1/buy", `{"card_id":2,"max":true}`, http.StatusOK, 2, 26, 7, false},
+		{"buy max without coins", http.MethodPost, "/api/v1/buy", `{"card_id":2,"max":true}`, http.StatusBadRequest, 0, 0, 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, game := doTestRequest(t, rst, tc.method, tc.target, tc.body)
+			if status != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, status)
+			}
+
+			if game == nil {
+				return
+			}
+
+			card := game.Cards[tc.cardID]
+
+			if game.CurrentCoins != amount.New(tc.coins) || card.CurrentLevel != tc.level || card.IsMaxed != tc.isMaxed {
+				t.Errorf("expected %d coins, level %d and maxed %t, got %s, %d and %t",
+					tc.coins, tc.level, tc.isMaxed, game.CurrentCoins, card.CurrentLevel, card.IsMaxed)
+			}
+		})
+	}
+}
+
+func TestCompactResponse(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	_, game := doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	testCases := []struct {
+		name     string
+		target   string
+		body     string
+		expected int
+		version  uint64
+		coins    uint64
+		card     uint64
+	}{
+		{"click", "/api/v1/click", `{"card_id":1,"compact":true}`, http.StatusOK, game.Version + 1, 1, 1},
+		{"click before timeout", "/api/v1/click", `{"card_id":1,"compact":true}`, http.StatusBadRequest, 0, 0, 0},
+		{"coins pack", "/api/v1/shop/buy", `{"item_id":4}`, http.StatusOK, game.Version + 2, 101, 0},
+		{"buy", "/api/v1/buy", `{"card_id":2,"compact":true}`, http.StatusOK, game.Version + 3, 41, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			diff := &restModel.GameDiff{}
+
+			if status := doTestJSONRequest(t, rst, http.MethodPost, tc.target, tc.body, diff); status != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, status)
+			}
+
+			if tc.expected != http.StatusOK {
+				return
+			}
+
+			if diff.Version != tc.version || diff.CurrentCoins != amount.New(tc.coins) {
+				t.Errorf("expected version %d and %d coins, got %d and %s", tc.version, tc.coins, diff.Version, diff.CurrentCoins)
+			}
+
+			if tc.card == 0 {
+				return
+			}
+
+			if card, ok := diff.Cards[tc.card]; len(diff.Cards) != 1 || !ok || card.CurrentLevel != 1 {
+				t.Errorf("expected only card %d, got %+v", tc.card, diff.Cards)
+			}
+		})
+	}
+}
+
+func TestResetGame(t *testing.T) {
+	rst, str := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	if _, err := str.UpdateUserCoins(testTelegramID, amount.New(1000)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := str.UpdateUserEarnedCoins(testTelegramID, amount.New(1000)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/buy", `{"card_id":2}`)
+
+	status, game := doTestRequest(t, rst, http.MethodPost, "/api/v1/reset", ``)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if game.CurrentCoins != amount.New(0) || game.CurrentInvestors != 10 {
+		t.Errorf("expected 0 coins and 10 investors, got %s and %d", game.CurrentCoins, game.CurrentInvestors)
+	}
+
+	if game.Cards[1].CurrentLevel != 1 || game.Cards[2].CurrentLevel != 0 {
+		t.Errorf("expected only the start card after reset, got %+v %+v", game.Cards[1], game.Cards[2])
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	testCases := []struct {
+		method string
+		target string
+	}{
+		{http.MethodPost, "/api/v1/enter"},
+		{http.MethodPost, "/api/v1/click"},
+		{http.MethodPost, "/api/v1/buy"},
+		{http.MethodPost, "/api/v1/reset"},
+		{http.MethodGet, "/api/v1/shop"},
+		{http.MethodPost, "/api/v1/shop/buy"},
+		{http.MethodGet, "/api/v1/tasks"},
+		{http.MethodPost, "/api/v1/tasks/claim"},
+	}
+
+	for _, tc := range testCases {
+		res, err := rst.srv.Test(httptest.NewRequest(tc.method, tc.target, nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.target, http.StatusUnauthorized, res.StatusCode)
+		}
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	testCases := map[string]struct {
+		target string
+		body   string
+		fields []string
+	}{
+		"malformed body":  {"/api/v1/click", `{"card_id":`, []string{fieldBody}},
+		"unknown field":   {"/api/v1/click", `{"card":1}`, []string{fieldBody}},
+		"wrong type":      {"/api/v1/click", `{"card_id":"1"}`, []string{fieldBody}},
+		"no card id":      {"/api/v1/click", `{}`, []string{fieldCardID}},
+		"several fields":  {"/api/v1/buy", `{"count":2,"max":true}`, []string{fieldCardID, fieldCount}},
+		"no item id":      {"/api/v1/shop/buy", ``, []string{fieldItemID}},
+		"unknown item id": {"/api/v1/shop/buy", `{"item_id":100}`, []string{fieldItemID}},
+		"no task id":      {"/api/v1/tasks/claim", `{}`, []string{fieldTaskID}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			response := &restModel.Error{}
+
+			if status := doTestJSONRequest(t, rst, http.MethodPost, tc.target, tc.body, response); status != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d", http.StatusBadRequest, status)
+			}
+
+			if response.Code != ErrRequestIsInvalid.Code || len(response.Fields) != len(tc.fields) {
+				t.Fatalf("unexpected error response: %+v", response)
+			}
+
+			for i, field := range tc.fields {
+				if response.Fields[i].Field != field {
+					t.Errorf("expected field %q, got %q", field, response.Fields[i].Field)
+				}
+			}
+		})
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		target   string
+		expected int
+	}{
+		{"enter", "/enter", http.StatusOK},
+		{"click", "/click?card_id=1", http.StatusOK},
+		{"invalid count", "/buy?card_id=1&count=0", http.StatusBadRequest},
+		{"buy max", "/buy?card_id=1&count=max", http.StatusOK},
+		{"tasks", "/tasks", http.StatusOK},
+		{"shop buy", "/shop/buy?item_id=1", http.StatusOK},
+		{"reset", "/reset", http.StatusOK},
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		rst, str := newTestRESTWithRoutes(t, true)
+
+		doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+		if _, err := str.UpdateUserCoins(testTelegramID, amount.New(100)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for _, tc := range testCases {
+			status := doTestJSONRequest(t, rst, http.MethodGet, tc.target, ``, &restModel.Game{})
+			if status != tc.expected {
+				t.Errorf("%s: expected status %d, got %d", tc.name, tc.expected, status)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		rst, _ := newTestREST(t)
+
+		for _, tc := range testCases {
+			if status := doTestJSONRequest(t, rst, http.MethodGet, tc.target, ``, &restModel.Game{}); status != http.StatusNotFound {
+				t.Errorf("%s: expected status %d, got %d", tc.name, http.StatusNotFound, status)
+			}
+		}
+	})
+}
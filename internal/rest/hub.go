@@ -0,0 +1,324 @@
+package rest
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	zap "go.uber.org/zap"
+
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+const (
+	messageState = "state"
+	messageDiff  = "diff"
+	messageReady = "ready"
+	messageError = "error"
+
+	hubClientBuffer = 16
+)
+
+type (
+	// hub keeps the websocket clients of every user, so all the open tabs of the user get the same updates.
+	// It remembers the last game state of the user to send the diffs and the cards becoming ready.
+	hub struct {
+		mu    sync.Mutex
+		lgr   *zap.Logger
+		now   func() time.Time
+		users map[uint64]*hubUser
+	}
+
+	hubUser struct {
+		clients map[*hubClient]struct{}
+		game    *restModel.Game
+		readyAt uint64
+		timer   *time.Timer
+	}
+
+	// hubClient is the websocket connection of the user. Messages are written from send by the connection.
+	hubClient struct {
+		send   chan *restModel.SocketMessage
+		closed bool
+	}
+)
+
+func newHub(lgr *zap.Logger) *hub {
+	return &hub{
+		lgr:   lgr,
+		now:   time.Now,
+		users: make(map[uint64]*hubUser),
+	}
+}
+
+// subscribe adds the client of the user.
+func (h *hub) subscribe(tgID uint64) *hubClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	user, ok := h.users[tgID]
+	if !ok {
+		user = &hubUser{clients: make(map[*hubClient]struct{})}
+		h.users[tgID] = user
+	}
+
+	cl := &hubClient{send: make(chan *restModel.SocketMessage, hubClientBuffer)}
+	user.clients[cl] = struct{}{}
+
+	return cl
+}
+
+// unsubscribe removes the client of the user and closes its send channel.
+// The user is forgotten with the last client.
+func (h *hub) unsubscribe(tgID uint64, cl *hubClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	user, ok := h.users[tgID]
+	if !ok {
+		return
+	}
+
+	h.drop(user, cl)
+
+	if len(user.clients) > 0 {
+		return
+	}
+
+	if user.timer != nil {
+		user.timer.Stop()
+	}
+
+	delete(h.users, tgID)
+}
+
+// close drops the clients of every user, their connections go away. Used when the server shuts down.
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for tgID, user := range h.users {
+		for cl := range user.clients {
+			h.drop(user, cl)
+		}
+
+		if user.timer != nil {
+			user.timer.Stop()
+		}
+
+		delete(h.users, tgID)
+	}
+}
+
+// publish sends the diff between the last known and the new game state to every client of the user.
+// src gets the message with id, and the whole state instead of the diff if full is set.
+// src may be nil if the state changed out of the websocket.
+func (h *hub) publish(tgID uint64, game *restModel.Game, src *hubClient, id string, full bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	user, ok := h.users[tgID]
+	if !ok {
+		return
+	}
+
+	diff := &restModel.SocketMessage{Type: messageDiff, Data: diffGame(user.game, game)}
+
+	for cl := range user.clients {
+		msg := diff
+
+		if cl == src {
+			msg = &restModel.SocketMessage{ID: id, Type: messageDiff, Data: diff.Data}
+
+			if full {
+				msg = &restModel.SocketMessage{ID: id, Type: messageState, Data: game}
+			}
+		}
+
+		h.send(user, cl, msg)
+	}
+
+	user.game = game
+	user.readyAt = uint64(h.now().Unix())
+
+	h.schedule(tgID, user)
+}
+
+// publishDiff sends the diff made out of the websocket to every client of the user
+// and applies it to the last known game state.
+func (h *hub) publishDiff(tgID uint64, diff *restModel.GameDiff) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	user, ok := h.users[tgID]
+	if !ok {
+		return
+	}
+
+	msg := &restModel.SocketMessage{Type: messageDiff, Data: diff}
+
+	for cl := range user.clients {
+		h.send(user, cl, msg)
+	}
+
+	if user.game == nil {
+		return
+	}
+
+	user.game = applyDiff(user.game, diff)
+	user.readyAt = uint64(h.now().Unix())
+
+	h.schedule(tgID, user)
+}
+
+// reply sends the message to the client only.
+func (h *hub) reply(tgID uint64, cl *hubClient, msg *restModel.SocketMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if user, ok := h.users[tgID]; ok {
+		h.send(user, cl, msg)
+	}
+}
+
+// ready tells the clients of the user which cards can be clicked again since the last check.
+func (h *hub) ready(tgID uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	user, ok := h.users[tgID]
+	if !ok || user.game == nil {
+		return
+	}
+
+	var (
+		now = uint64(h.now().Unix())
+		ids = make([]uint64, 0, len(user.game.Cards))
+	)
+
+	for _, card := range user.game.Cards {
+		if card.CurrentLevel > 0 && card.NextClick > user.readyAt && card.NextClick <= now {
+			ids = append(ids, card.ID)
+		}
+	}
+
+	user.readyAt = now
+
+	if len(ids) > 0 {
+		slices.Sort(ids)
+
+		msg := &restModel.SocketMessage{Type: messageReady, Data: &restModel.CardsReady{CardIDs: ids}}
+
+		for cl := range user.clients {
+			h.send(user, cl, msg)
+		}
+	}
+
+	h.schedule(tgID, user)
+}
+
+// schedule starts the timer of the next card becoming ready.
+func (h *hub) schedule(tgID uint64, user *hubUser) {
+	if user.timer != nil {
+		user.timer.Stop()
+		user.timer = nil
+	}
+
+	var next uint64
+
+	for _, card := range user.game.Cards {
+		if card.CurrentLevel > 0 && card.NextClick > user.readyAt && (next == 0 || card.NextClick < next) {
+			next = card.NextClick
+		}
+	}
+
+	if next == 0 {
+		return
+	}
+
+	wait := time.Unix(int64(next), 0).Sub(h.now())
+
+	user.timer = time.AfterFunc(max(wait, 0), func() { h.ready(tgID) })
+}
+
+// send queues the message for the client. A client too slow to drain its buffer is dropped,
+// its connection notices the closed channel and goes away.
+func (h *hub) send(user *hubUser, cl *hubClient, msg *restModel.SocketMessage) {
+	if cl.closed {
+		return
+	}
+
+	select {
+	case cl.send <- msg:
+	default:
+		h.lgr.Warn("websocket client is too slow, dropping it")
+		h.drop(user, cl)
+	}
+}
+
+func (h *hub) drop(user *hubUser, cl *hubClient) {
+	if cl.closed {
+		return
+	}
+
+	cl.closed = true
+	close(cl.send)
+	delete(user.clients, cl)
+}
+
+// diffGame returns the totals of next with the cards and effects changed since prev.
+// Every card is changed if there is no prev.
+func diffGame(prev, next *restModel.Game) *restModel.GameDiff {
+	diff := &restModel.GameDiff{
+		Version:                       next.Version,
+		CurrentCoins:                  next.CurrentCoins,
+		CurrentGold:                   next.CurrentGold,
+		CurrentInvestors:              next.CurrentInvestors,
+		InvestorsAfterReset:           next.InvestorsAfterReset,
+		CurrentInvestorsMultiplier:    next.CurrentInvestorsMultiplier,
+		InvestorsMultiplierAfterReset: next.InvestorsMultiplierAfterReset,
+		Cards:                         make(map[uint64]*restModel.GameCard),
+	}
+
+	for id, card := range next.Cards {
+		if prev != nil && prev.Cards[id] != nil && *prev.Cards[id] == *card {
+			continue
+		}
+
+		diff.Cards[id] = card
+	}
+
+	if next.Effects != nil && (prev == nil || prev.Effects == nil || *prev.Effects != *next.Effects) {
+		diff.Effects = next.Effects
+	}
+
+	return diff
+}
+
+// applyDiff returns the copy of the game with the diff applied.
+func applyDiff(game *restModel.Game, diff *restModel.GameDiff) *restModel.Game {
+	res := *game
+
+	res.Version = diff.Version
+	res.CurrentCoins = diff.CurrentCoins
+	res.CurrentGold = diff.CurrentGold
+	res.CurrentInvestors = diff.CurrentInvestors
+	res.InvestorsAfterReset = diff.InvestorsAfterReset
+	res.CurrentInvestorsMultiplier = diff.CurrentInvestorsMultiplier
+	res.InvestorsMultiplierAfterReset = diff.InvestorsMultiplierAfterReset
+	res.Cards = make(map[uint64]*restModel.GameCard, len(game.Cards))
+
+	for id, card := range game.Cards {
+		res.Cards[id] = card
+	}
+
+	for id, card := range diff.Cards {
+		res.Cards[id] = card
+	}
+
+	if diff.Effects != nil {
+		res.Effects = diff.Effects
+	}
+
+	return &res
+}
@@ -0,0 +1,166 @@
+package rest
+
+import (
+	"testing"
+	"time"
+
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+// newTestGame creates the game with the cards of the given levels and next clicks.
+func newTestGame(coins uint64, levels, nextClicks []uint64) *restModel.Game {
+	game := &restModel.Game{
+		CurrentCoins: amount.New(coins),
+		Cards:        make(map[uint64]*restModel.GameCard, len(levels)),
+		Effects:      &restModel.GameEffects{CoinsMultiplier: 1},
+	}
+
+	for i := range levels {
+		id := uint64(i + 1)
+		game.Cards[id] = &restModel.GameCard{ID: id, CurrentLevel: levels[i], NextClick: nextClicks[i]}
+	}
+
+	return game
+}
+
+// receiveTestMessage returns the message queued for the client or fails.
+func receiveTestMessage(t *testing.T, cl *hubClient) *restModel.SocketMessage {
+	t.Helper()
+
+	select {
+	case msg := <-cl.send:
+		return msg
+	default:
+		t.Fatalf("expected message, got nothing")
+	}
+
+	return nil
+}
+
+func TestDiffGame(t *testing.T) {
+	testCases := map[string]struct {
+		prev    *restModel.Game
+		next    *restModel.Game
+		cards   []uint64
+		effects bool
+	}{
+		"no prev":      {nil, newTestGame(10, []uint64{1, 0}, []uint64{0, 0}), []uint64{1, 2}, true},
+		"nothing":      {newTestGame(10, []uint64{1, 0}, []uint64{0, 0}), newTestGame(10, []uint64{1, 0}, []uint64{0, 0}), nil, false},
+		"coins only":   {newTestGame(10, []uint64{1, 0}, []uint64{0, 0}), newTestGame(20, []uint64{1, 0}, []uint64{0, 0}), nil, false},
+		"clicked card": {newTestGame(10, []uint64{1, 1}, []uint64{0, 0}), newTestGame(11, []uint64{1, 1}, []uint64{0, 100}), []uint64{2}, false},
+		"bought card":  {newTestGame(10, []uint64{1, 0}, []uint64{0, 0}), newTestGame(0, []uint64{1, 1}, []uint64{0, 0}), []uint64{2}, false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			diff := diffGame(tc.prev, tc.next)
+
+			if diff.CurrentCoins != tc.next.CurrentCoins {
+				t.Errorf("expected %s coins, got %s", tc.next.CurrentCoins, diff.CurrentCoins)
+			}
+
+			if len(diff.Cards) != len(tc.cards) {
+				t.Fatalf("expected cards %v, got %+v", tc.cards, diff.Cards)
+			}
+
+			for _, id := range tc.cards {
+				if diff.Cards[id] != tc.next.Cards[id] {
+					t.Errorf("expected card %d in diff", id)
+				}
+			}
+
+			if (diff.Effects != nil) != tc.effects {
+				t.Errorf("expected effects %t, got %+v", tc.effects, diff.Effects)
+			}
+		})
+	}
+}
+
+func TestHub(t *testing.T) {
+	var (
+		now = time.Unix(1000, 0)
+		h   = newHub(zap.NewNop())
+		a   = h.subscribe(testTelegramID)
+		b   = h.subscribe(testTelegramID)
+		c   = h.subscribe(testTelegramID + 1)
+	)
+
+	h.now = func() time.Time { return now }
+
+	h.publish(testTelegramID, newTestGame(10, []uint64{1, 1, 0}, []uint64{1100, 1200, 1050}), a, "1", true)
+
+	if msg := receiveTestMessage(t, a); msg.ID != "1" || msg.Type != messageState {
+		t.Errorf("expected state of request 1, got %+v", msg)
+	}
+
+	if msg := receiveTestMessage(t, b); msg.ID != "" || msg.Type != messageDiff {
+		t.Errorf("expected diff without id, got %+v", msg)
+	}
+
+	if len(c.send) != 0 {
+		t.Errorf("expected no messages for the other user, got %d", len(c.send))
+	}
+
+	now = time.Unix(1150, 0)
+	h.ready(testTelegramID)
+
+	for _, cl := range []*hubClient{a, b} {
+		msg := receiveTestMessage(t, cl)
+
+		if ready, ok := msg.Data.(*restModel.CardsReady); msg.Type != messageReady || !ok || len(ready.CardIDs) != 1 || ready.CardIDs[0] != 1 {
+			t.Errorf("expected card 1 to be ready, got %+v", msg.Data)
+		}
+	}
+
+	h.ready(testTelegramID)
+
+	if len(a.send) != 0 {
+		t.Errorf("expected ready cards to be sent once, got %d messages", len(a.send))
+	}
+
+	h.unsubscribe(testTelegramID, a)
+	h.unsubscribe(testTelegramID, b)
+
+	if _, ok := <-a.send; ok {
+		t.Errorf("expected send channel to be closed")
+	}
+
+	if _, ok := h.users[testTelegramID]; ok {
+		t.Errorf("expected user to be forgotten with the last client")
+	}
+}
+
+func TestApplyDiff(t *testing.T) {
+	var (
+		game = newTestGame(10, []uint64{1, 0}, []uint64{0, 0})
+		diff = diffGame(game, newTestGame(0, []uint64{1, 1}, []uint64{0, 0}))
+		res  = applyDiff(game, diff)
+	)
+
+	if res.CurrentCoins != amount.New(0) || res.Cards[2].CurrentLevel != 1 || res.Cards[1] != game.Cards[1] {
+		t.Errorf("expected diff to be applied, got %+v", res)
+	}
+
+	if game.CurrentCoins != amount.New(10) || game.Cards[2].CurrentLevel != 0 {
+		t.Errorf("expected game to stay unchanged, got %+v", game)
+	}
+}
+
+func TestHubSlowClient(t *testing.T) {
+	var (
+		h    = newHub(zap.NewNop())
+		cl   = h.subscribe(testTelegramID)
+		game = newTestGame(10, []uint64{1}, []uint64{0})
+	)
+
+	for i := 0; i <= hubClientBuffer; i++ {
+		h.publish(testTelegramID, game, nil, "", false)
+	}
+
+	if !cl.closed || len(h.users[testTelegramID].clients) != 0 {
+		t.Errorf("expected slow client to be dropped")
+	}
+}
@@ -0,0 +1,79 @@
+package rest
+
+import (
+	"errors"
+	"strconv"
+
+	fiber "github.com/gofiber/fiber/v2"
+
+	leaderboard "github.com/adzpm/telegram-clicker/internal/leaderboard"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+const (
+	defaultLeaderboardLimit uint64 = 50
+	maxLeaderboardLimit     uint64 = 100
+)
+
+func createLeaderboardEntry(entry *leaderboard.Entry) *restModel.LeaderboardEntry {
+	return &restModel.LeaderboardEntry{Rank: entry.Rank, TelegramID: entry.TelegramID, Score: entry.Score}
+}
+
+// getLeaderboard returns the page of the board with the rank of the user.
+func (r *REST) getLeaderboard(tgID uint64, board string, offset, limit uint64) (_ *restModel.Leaderboard, err error) {
+	page, err := r.ldb.Page(board, tgID, offset, limit)
+	if errors.Is(err, leaderboard.ErrUnknownBoard) {
+		return nil, newValidationError(fieldBoard, ErrorBoardIsUnknown)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := &restModel.Leaderboard{
+		Board:     board,
+		Entries:   make([]*restModel.LeaderboardEntry, 0, len(page.Entries)),
+		Offset:    offset,
+		Total:     page.Total,
+		UpdatedAt: page.UpdatedAt,
+	}
+
+	for i := range page.Entries {
+		response.Entries = append(response.Entries, createLeaderboardEntry(&page.Entries[i]))
+	}
+
+	if page.Own != nil {
+		response.Own = createLeaderboardEntry(page.Own)
+	}
+
+	return response, nil
+}
+
+// GetLeaderboard serves the board from the query params: board, earned_coins by default,
+// offset and limit, 50 by default and 100 at most.
+func (r *REST) GetLeaderboard(c *fiber.Ctx) (err error) {
+	var (
+		board  = c.Query(fieldBoard, leaderboard.BoardEarnedCoins)
+		offset uint64
+		limit  = defaultLeaderboardLimit
+	)
+
+	if value := c.Query(fieldOffset); value != "" {
+		if offset, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return newValidationError(fieldOffset, ErrorOffsetIsInvalid)
+		}
+	}
+
+	if value := c.Query(fieldLimit); value != "" {
+		if limit, err = strconv.ParseUint(value, 10, 64); err != nil || limit == 0 || limit > maxLeaderboardLimit {
+			return newValidationError(fieldLimit, ErrorLimitIsInvalid)
+		}
+	}
+
+	response, err := r.getLeaderboard(getTelegramID(c), board, offset, limit)
+	if err != nil {
+		return err
+	}
+
+	return Throw200Response(c, response)
+}
@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+func TestGetLeaderboard(t *testing.T) {
+	rst, str := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/click", `{"card_id":1}`)
+
+	if _, err := str.InsertUser(testTelegramID+1, amount.New(500), 0, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := rst.ldb.Refresh(time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := map[string]struct {
+		target   string
+		expected int
+		entries  int
+		own      *restModel.LeaderboardEntry
+	}{
+		"earned coins by default": {"/api/v1/leaderboard", http.StatusOK, 2, &restModel.LeaderboardEntry{Rank: 2, TelegramID: testTelegramID, Score: amount.New(1)}},
+		"daily coins":             {"/api/v1/leaderboard?board=daily_coins", http.StatusOK, 1, &restModel.LeaderboardEntry{Rank: 1, TelegramID: testTelegramID, Score: amount.New(1)}},
+		"investors unranked":      {"/api/v1/leaderboard?board=investors", http.StatusOK, 1, nil},
+		"page":                    {"/api/v1/leaderboard?offset=1&limit=1", http.StatusOK, 1, &restModel.LeaderboardEntry{Rank: 2, TelegramID: testTelegramID, Score: amount.New(1)}},
+		"unknown board":           {"/api/v1/leaderboard?board=gold", http.StatusBadRequest, 0, nil},
+		"invalid offset":          {"/api/v1/leaderboard?offset=-1", http.StatusBadRequest, 0, nil},
+		"limit above max":         {"/api/v1/leaderboard?limit=101", http.StatusBadRequest, 0, nil},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			response := &restModel.Leaderboard{}
+
+			if status := doTestJSONRequest(t, rst, http.MethodGet, tc.target, ``, response); status != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, status)
+			}
+
+			if tc.expected != http.StatusOK {
+				return
+			}
+
+			if len(response.Entries) != tc.entries {
+				t.Errorf("expected %d entries, got %+v", tc.entries, response.Entries)
+			}
+
+			if (tc.own == nil) != (response.Own == nil) || tc.own != nil && *tc.own != *response.Own {
+				t.Errorf("expected own entry %+v, got %+v", tc.own, response.Own)
+			}
+		})
+	}
+}
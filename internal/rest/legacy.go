@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"strconv"
+
+	fiber "github.com/gofiber/fiber/v2"
+
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+// legacyBuyCountMax buys as many levels as the user can afford.
+const legacyBuyCountMax = "max"
+
+// The legacy handlers serve the deprecated GET routes: they read the request from the query params
+// and run the same game actions as the /api/v1 handlers.
+
+func (r *REST) LegacyClickCard(c *fiber.Ctx) (err error) {
+	game, err := r.clickCard(getTelegramID(c), &restModel.ClickRequest{
+		CardID:      uint64(max(c.QueryInt(fieldCardID), 0)),
+		SkipTimeout: c.QueryBool("skip_timeout"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.sendGame(c, game)
+}
+
+func (r *REST) LegacyBuyCard(c *fiber.Ctx) (err error) {
+	req := &restModel.BuyRequest{CardID: uint64(max(c.QueryInt(fieldCardID), 0))}
+
+	switch count := c.Query(fieldCount); count {
+	case "":
+	case legacyBuyCountMax:
+		req.Max = true
+	default:
+		if req.Count, err = strconv.ParseUint(count, 10, 64); err != nil || req.Count == 0 {
+			return newValidationError(fieldCount, ErrorCountIsInvalid)
+		}
+	}
+
+	game, err := r.buyCard(getTelegramID(c), req)
+	if err != nil {
+		return err
+	}
+
+	return r.sendGame(c, game)
+}
+
+func (r *REST) LegacyBuyShopItem(c *fiber.Ctx) (err error) {
+	game, err := r.buyShopItem(getTelegramID(c), &restModel.ShopBuyRequest{ItemID: uint64(max(c.QueryInt(fieldItemID), 0))})
+	if err != nil {
+		return err
+	}
+
+	return r.sendGame(c, game)
+}
+
+func (r *REST) LegacyClaimTask(c *fiber.Ctx) (err error) {
+	game, err := r.claimTask(getTelegramID(c), &restModel.ClaimTaskRequest{TaskID: uint64(max(c.QueryInt(fieldTaskID), 0))})
+	if err != nil {
+		return err
+	}
+
+	return r.sendGame(c, game)
+}
@@ -0,0 +1,162 @@
+package rest
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+)
+
+// referralPrefix starts the start param of the invite links, the telegram id of the referrer follows it.
+const referralPrefix = "ref_"
+
+// parseReferrer returns the telegram id of the referrer from the start param, ok is false if it isn't an invite.
+func parseReferrer(startParam string) (referrerID uint64, ok bool) {
+	id, found := strings.CutPrefix(startParam, referralPrefix)
+	if !found {
+		return 0, false
+	}
+
+	referrerID, err := strconv.ParseUint(id, 10, 64)
+
+	return referrerID, err == nil && referrerID > 0
+}
+
+// refer marks the new user as invited by the referrer of the start param and gives both of them the bonuses.
+// The invalid invites are only logged, so a wrong link never keeps the user out of the game.
+func (r *REST) refer(user *storageModel.User, startParam string, now uint64) (_ *storageModel.User, err error) {
+	referrerID, ok := parseReferrer(startParam)
+	if !ok {
+		return user, nil
+	}
+
+	var (
+		cfg      = r.mth.GetGameVariables().Referral
+		referred *storageModel.User
+	)
+
+	referred, err = r.str.Refer(user.TelegramID, referrerID, now, storage.ReferralBonus{
+		ReferrerCoins: amount.New(cfg.ReferrerCoins),
+		ReferrerGold:  cfg.ReferrerGold,
+		RefereeCoins:  amount.New(cfg.RefereeCoins),
+		RefereeGold:   cfg.RefereeGold,
+	})
+
+	switch {
+	case errors.Is(err, storage.ErrRecordNotFound), errors.Is(err, storage.ErrCantRefer):
+		r.lgr.Warn("invalid referral",
+			zap.Uint64("telegram_id", user.TelegramID),
+			zap.Uint64("referrer_id", referrerID),
+			zap.Error(err),
+		)
+
+		return user, nil
+	case err != nil:
+		return nil, err
+	}
+
+	r.lgr.Info("user referred", zap.Uint64("telegram_id", user.TelegramID), zap.Uint64("referrer_id", referrerID))
+
+	return referred, nil
+}
+
+// referralShare returns the function calculating the share of the referrer in the coins the referee earned.
+func referralShare(percents float64) storage.ReferralFunc {
+	return func(_ *storageModel.User, coins amount.Amount) amount.Amount {
+		return coins.MulFloat(percents)
+	}
+}
+
+// createReferrals returns the friends of the user with the rewards the user got for each of them
+// and the share of the coins they earned since the last collection.
+func createReferrals(user *storageModel.User, referees []storageModel.User, percents float64) *restModel.Referrals {
+	var (
+		share    = referralShare(percents)
+		response = &restModel.Referrals{
+			StartParam:   referralPrefix + strconv.FormatUint(user.TelegramID, 10),
+			CurrentCoins: user.Coins,
+			Percents:     percents,
+			Referrals:    make([]*restModel.Referral, 0, len(referees)),
+		}
+	)
+
+	for i := range referees {
+		referee := &referees[i]
+
+		response.RewardCoins = response.RewardCoins.Add(referee.ReferralCoins)
+		response.RewardGold += referee.ReferralGold
+		response.PendingCoins = response.PendingCoins.Add(share(referee, referee.LifetimeCoins.Sub(referee.ReferralSettledCoins)))
+
+		response.Referrals = append(response.Referrals, &restModel.Referral{
+			TelegramID:    referee.TelegramID,
+			LifetimeCoins: referee.LifetimeCoins,
+			RewardCoins:   referee.ReferralCoins,
+			RewardGold:    referee.ReferralGold,
+		})
+	}
+
+	return response
+}
+
+// getReferrals returns the friends of the user without collecting the rewards.
+func (r *REST) getReferrals(tgID uint64) (_ *restModel.Referrals, err error) {
+	var (
+		user     *storageModel.User
+		referees []storageModel.User
+	)
+
+	if user, err = r.str.SelectUser(tgID); err != nil {
+		return nil, err
+	}
+
+	if referees, err = r.str.SelectReferees(tgID); err != nil {
+		return nil, err
+	}
+
+	return createReferrals(user, referees, r.mth.GetGameVariables().Referral.Percents), nil
+}
+
+// collectReferrals gives the user the share of the coins the friends earned since the last time
+// and returns the friends with the rewards the user got for each of them.
+func (r *REST) collectReferrals(tgID uint64) (_ *restModel.Referrals, err error) {
+	var (
+		tn       = uint64(time.Now().Unix())
+		percents = r.mth.GetGameVariables().Referral.Percents
+		user     *storageModel.User
+		referees []storageModel.User
+	)
+
+	if user, referees, err = r.str.CollectReferralRewards(tgID, tn, referralShare(percents)); err != nil {
+		return nil, err
+	}
+
+	r.lgr.Info("referral rewards collected", zap.Uint64("telegram_id", tgID))
+
+	return createReferrals(user, referees, percents), nil
+}
+
+func (r *REST) GetReferrals(c *fiber.Ctx) (err error) {
+	response, err := r.getReferrals(getTelegramID(c))
+	if err != nil {
+		return err
+	}
+
+	return Throw200Response(c, response)
+}
+
+func (r *REST) CollectReferrals(c *fiber.Ctx) (err error) {
+	response, err := r.collectReferrals(getTelegramID(c))
+	if err != nil {
+		return err
+	}
+
+	return Throw200Response(c, response)
+}
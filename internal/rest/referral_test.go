@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+// doTestEnter enters the game as the user opening the app with the start param and returns the game.
+func doTestEnter(t *testing.T, rst *REST, telegramID uint64, startParam string) *restModel.Game {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/enter", nil)
+	req.Header.Set(headerInitData, signTestInitData(testBotToken, time.Now(), map[string]string{
+		"user":        `{"id":` + strconv.FormatUint(telegramID, 10) + `}`,
+		"start_param": startParam,
+	}))
+
+	res, err := rst.srv.Test(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	game := &restModel.Game{}
+
+	if err = json.NewDecoder(res.Body).Decode(game); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return game
+}
+
+func TestParseReferrer(t *testing.T) {
+	testCases := map[string]struct {
+		startParam string
+		referrerID uint64
+		ok         bool
+	}{
+		"referral":     {"ref_42", 42, true},
+		"empty":        {"", 0, false},
+		"other param":  {"promo", 0, false},
+		"not a number": {"ref_abc", 0, false},
+		"zero":         {"ref_0", 0, false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if referrerID, ok := parseReferrer(tc.startParam); referrerID != tc.referrerID || ok != tc.ok {
+				t.Errorf("expected %d %t, got %d %t", tc.referrerID, tc.ok, referrerID, ok)
+			}
+		})
+	}
+}
+
+func TestReferrals(t *testing.T) {
+	rst, str := newTestREST(t)
+
+	doTestEnter(t, rst, testTelegramID, "")
+
+	testCases := map[string]struct {
+		telegramID uint64
+		startParam string
+		gold       uint64
+	}{
+		"invited":          {testTelegramID + 1, "ref_" + strconv.Itoa(testTelegramID), 1050},
+		"self invited":     {testTelegramID + 2, "ref_" + strconv.Itoa(testTelegramID+2), 1000},
+		"unknown referrer": {testTelegramID + 3, "ref_1", 1000},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if game := doTestEnter(t, rst, tc.telegramID, tc.startParam); game.CurrentGold != tc.gold {
+				t.Errorf("expected %d gold, got %d", tc.gold, game.CurrentGold)
+			}
+		})
+	}
+
+	// the start param of a returning user is ignored
+	if game := doTestEnter(t, rst, testTelegramID+2, "ref_"+strconv.Itoa(testTelegramID)); game.CurrentGold != 1000 {
+		t.Errorf("expected 1000 gold, got %d", game.CurrentGold)
+	}
+
+	if _, err := str.EarnCoins(testTelegramID+1, amount.New(1000), uint64(time.Now().Unix())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the listing is read-only, the share waits for the collection
+	for range 2 {
+		response := &restModel.Referrals{}
+
+		if status := doTestJSONRequest(t, rst, http.MethodGet, "/api/v1/referrals", ``, response); status != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+		}
+
+		if response.StartParam != "ref_42" || !response.CurrentCoins.IsZero() || !response.RewardCoins.IsZero() || response.PendingCoins != amount.New(100) || response.RewardGold != 100 {
+			t.Errorf("unexpected referrals: %+v", response)
+		}
+	}
+
+	response := &restModel.Referrals{}
+
+	if status := doTestJSONRequest(t, rst, http.MethodPost, "/api/v1/referrals/collect", ``, response); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if response.CurrentCoins != amount.New(100) || response.RewardCoins != amount.New(100) || !response.PendingCoins.IsZero() || response.RewardGold != 100 {
+		t.Errorf("unexpected referrals: %+v", response)
+	}
+
+	if len(response.Referrals) != 1 || *response.Referrals[0] != (restModel.Referral{
+		TelegramID:    testTelegramID + 1,
+		LifetimeCoins: amount.New(1000),
+		RewardCoins:   amount.New(100),
+		RewardGold:    100,
+	}) {
+		t.Errorf("unexpected referrals: %+v", response.Referrals)
+	}
+}
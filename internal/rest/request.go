@@ -0,0 +1,108 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	fiber "github.com/gofiber/fiber/v2"
+
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+const (
+	fieldBody   = "body"
+	fieldBoard  = "board"
+	fieldCardID = "card_id"
+	fieldCount  = "count"
+	fieldItemID = "item_id"
+	fieldLimit  = "limit"
+	fieldOffset = "offset"
+	fieldTaskID = "task_id"
+	fieldType   = "type"
+)
+
+type (
+	// validationError describes why the request is invalid, field by field.
+	validationError struct {
+		fields []*restModel.FieldError
+	}
+)
+
+func newValidationError(field, message string) *validationError {
+	return &validationError{fields: []*restModel.FieldError{{Field: field, Message: message}}}
+}
+
+func (e *validationError) Error() string {
+	messages := make([]string, 0, len(e.fields))
+
+	for _, field := range e.fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// add appends the field error if the condition is broken.
+func (e *validationError) add(broken bool, field, message string) *validationError {
+	if broken {
+		e.fields = append(e.fields, &restModel.FieldError{Field: field, Message: message})
+	}
+
+	return e
+}
+
+// err returns the validation error if any field is invalid, otherwise nil.
+func (e *validationError) err() error {
+	if len(e.fields) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// bindJSON decodes the JSON body of the request into req. An empty body leaves req as is.
+func bindJSON(c *fiber.Ctx, req interface{}) error {
+	return decodeJSON(c.Body(), req)
+}
+
+// decodeJSON decodes body into req rejecting the unknown fields. An empty body leaves req as is.
+func decodeJSON(body []byte, req interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(req); err != nil {
+		return newValidationError(fieldBody, ErrorBodyIsInvalid)
+	}
+
+	return nil
+}
+
+func validateClickRequest(req *restModel.ClickRequest) error {
+	return (&validationError{}).
+		add(req.CardID == 0, fieldCardID, ErrorCardIDIsRequired).
+		err()
+}
+
+func validateBuyRequest(req *restModel.BuyRequest) error {
+	return (&validationError{}).
+		add(req.CardID == 0, fieldCardID, ErrorCardIDIsRequired).
+		add(req.Max && req.Count > 0, fieldCount, ErrorCountWithMax).
+		err()
+}
+
+func validateShopBuyRequest(req *restModel.ShopBuyRequest) error {
+	return (&validationError{}).
+		add(req.ItemID == 0, fieldItemID, ErrorItemIDIsRequired).
+		err()
+}
+
+func validateClaimTaskRequest(req *restModel.ClaimTaskRequest) error {
+	return (&validationError{}).
+		add(req.TaskID == 0, fieldTaskID, ErrorTaskIDIsRequired).
+		err()
+}
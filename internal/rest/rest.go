@@ -2,48 +2,134 @@ package rest
 
 import (
 	"context"
-	"github.com/adzpm/telegram-clicker/internal/math"
+	"time"
 
+	websocket "github.com/gofiber/contrib/websocket"
 	fiber "github.com/gofiber/fiber/v2"
+	requestid "github.com/gofiber/fiber/v2/middleware/requestid"
 	zap "go.uber.org/zap"
 
 	config "github.com/adzpm/telegram-clicker/internal/config"
+	leaderboard "github.com/adzpm/telegram-clicker/internal/leaderboard"
+	math "github.com/adzpm/telegram-clicker/internal/math"
+	shop "github.com/adzpm/telegram-clicker/internal/shop"
 	storage "github.com/adzpm/telegram-clicker/internal/storage"
+	task "github.com/adzpm/telegram-clicker/internal/task"
 )
 
+const defaultShutdownTimeout = 10 * time.Second
+
 type (
 	REST struct {
 		srv *fiber.App
 		lgr *zap.Logger
-		str *storage.Storage
+		str storage.Storage
 		cfg *config.REST
 		mth *math.Math
+		shp *shop.Shop
+		tsk *task.Tasks
+		ldb *leaderboard.Leaderboard
+		chk task.ChannelChecker
+		hub *hub
 	}
 )
 
-func New(lgr *zap.Logger, str *storage.Storage, mth *math.Math, cfg *config.REST) *REST {
-	return &REST{
-		srv: fiber.New(),
+func New(
+	lgr *zap.Logger,
+	str storage.Storage,
+	mth *math.Math,
+	shp *shop.Shop,
+	tsk *task.Tasks,
+	ldb *leaderboard.Leaderboard,
+	cfg *config.REST,
+) *REST {
+	r := &REST{
 		lgr: lgr,
 		cfg: cfg,
 		mth: mth,
 		str: str,
+		shp: shp,
+		tsk: tsk,
+		ldb: ldb,
+		chk: task.NewTelegramChecker(task.TelegramAPIURL, cfg.BotToken),
+		hub: newHub(lgr),
 	}
+
+	r.srv = fiber.New(fiber.Config{ErrorHandler: r.handleError})
+
+	return r
 }
 
 func (r *REST) setupRoutes(ctx context.Context) {
 	r.lgr.Debug("setting up routes")
 
+	r.srv.Use(requestid.New())
 	r.srv.Static("/", r.cfg.WebPath)
 
-	r.srv.Get("/enter", r.EnterGame)
-	r.srv.Get("/click", r.ClickCard)
-	r.srv.Get("/buy", r.BuyCard)
-	r.srv.Get("/reset", r.ResetGame)
+	api := r.srv.Group("/api/v1", r.Authorize)
+
+	api.Post("/enter", r.EnterGame)
+	api.Post("/click", r.ClickCard)
+	api.Post("/buy", r.BuyCard)
+	api.Post("/reset", r.ResetGame)
+	api.Get("/shop", r.GetShop)
+	api.Post("/shop/buy", r.BuyShopItem)
+	api.Get("/tasks", r.GetTasks)
+	api.Post("/tasks/claim", r.ClaimTask)
+	api.Get("/leaderboard", r.GetLeaderboard)
+	api.Get("/referrals", r.GetReferrals)
+	api.Post("/referrals/collect", r.CollectReferrals)
+	api.Get("/ws", r.UpgradeSocket, websocket.New(r.ServeSocket))
+
+	if !r.cfg.LegacyRoutes {
+		return
+	}
+
+	r.lgr.Warn("legacy GET routes are enabled")
+
+	r.srv.Get("/enter", r.Authorize, r.EnterGame)
+	r.srv.Get("/click", r.Authorize, r.LegacyClickCard)
+	r.srv.Get("/buy", r.Authorize, r.LegacyBuyCard)
+	r.srv.Get("/reset", r.Authorize, r.ResetGame)
+	r.srv.Get("/shop", r.Authorize, r.GetShop)
+	r.srv.Get("/shop/buy", r.Authorize, r.LegacyBuyShopItem)
+	r.srv.Get("/tasks", r.Authorize, r.GetTasks)
+	r.srv.Get("/tasks/claim", r.Authorize, r.LegacyClaimTask)
 }
 
-func (r *REST) Start(ctx context.Context) error {
+// Start serves the routes until the context is done and then shuts the server down gracefully:
+// stops accepting connections, closes the websockets and waits for the in-flight requests
+// for the shutdown timeout at most. Returns nil after the graceful shutdown.
+func (r *REST) Start(ctx context.Context) (err error) {
 	r.setupRoutes(ctx)
 
-	return r.srv.Listen(r.cfg.Host + ":" + r.cfg.Port)
+	return r.serve(ctx, func() error { return r.srv.Listen(r.cfg.Host + ":" + r.cfg.Port) })
+}
+
+// serve runs listen until it fails or the context is done.
+func (r *REST) serve(ctx context.Context, listen func() error) (err error) {
+	errs := make(chan error, 1)
+
+	go func() { errs <- listen() }()
+
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := defaultShutdownTimeout
+	if r.cfg.ShutdownTimeout > 0 {
+		timeout = time.Duration(r.cfg.ShutdownTimeout) * time.Second
+	}
+
+	r.lgr.Info("shutting down the server", zap.Duration("timeout", timeout))
+
+	r.hub.close()
+
+	if err = r.srv.ShutdownWithTimeout(timeout); err != nil {
+		return err
+	}
+
+	return <-errs
 }
@@ -0,0 +1,116 @@
+package rest
+
+import (
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	zap "go.uber.org/zap"
+
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+	shop "github.com/adzpm/telegram-clicker/internal/shop"
+)
+
+// createGameEffects returns the effects of the user purchases active at the moment.
+func createGameEffects(purchases []storageModel.UserPurchase, now uint64) *restModel.GameEffects {
+	coinsMp, expiresAt := shop.CoinsMultiplier(purchases, now)
+
+	return &restModel.GameEffects{
+		CoinsMultiplier:          coinsMp,
+		CoinsMultiplierExpiresAt: expiresAt,
+		SkipTimeouts:             shop.SkipTimeouts(purchases),
+	}
+}
+
+func (r *REST) createShopResponse(user *storageModel.User, purchases []storageModel.UserPurchase, now uint64) *restModel.Shop {
+	var (
+		items    = r.shp.Items()
+		response = &restModel.Shop{
+			CurrentGold: user.Gold,
+			Items:       make([]*restModel.ShopItem, 0, len(items)),
+			Effects:     createGameEffects(purchases, now),
+		}
+	)
+
+	for _, item := range items {
+		response.Items = append(response.Items, &restModel.ShopItem{
+			ID:       item.ID,
+			Name:     item.Name,
+			ImageURL: item.ImageURL,
+			Type:     item.Type,
+			Price:    item.Price,
+			Value:    item.Value,
+			Duration: item.Duration,
+		})
+	}
+
+	return response
+}
+
+// getShop returns the shop items with the gold and the active effects of the user.
+func (r *REST) getShop(tgID uint64) (_ *restModel.Shop, err error) {
+	var (
+		tn        = uint64(time.Now().Unix())
+		user      *storageModel.User
+		purchases []storageModel.UserPurchase
+	)
+
+	if user, err = r.str.SelectUser(tgID); err != nil {
+		return nil, err
+	}
+
+	if purchases, err = r.str.SelectUserPurchases(tgID, tn); err != nil {
+		return nil, err
+	}
+
+	return r.createShopResponse(user, purchases, tn), nil
+}
+
+// buyShopItem spends the gold of the user for the shop item.
+func (r *REST) buyShopItem(tgID uint64, req *restModel.ShopBuyRequest) (_ *restModel.Game, err error) {
+	if err = validateShopBuyRequest(req); err != nil {
+		return nil, err
+	}
+
+	item, ok := r.shp.Item(req.ItemID)
+	if !ok {
+		return nil, newValidationError(fieldItemID, ErrorItemNotFound)
+	}
+
+	r.lgr.Info("try to buy shop item", zap.Uint64("telegram_id", tgID), zap.Uint64("item_id", req.ItemID))
+
+	var (
+		tn   = uint64(time.Now().Unix())
+		user *storageModel.User
+	)
+
+	if user, err = r.str.Purchase(r.shp.NewPurchase(tgID, item, tn)); err != nil {
+		return nil, err
+	}
+
+	return r.selectGame(user, nil, tn)
+}
+
+func (r *REST) GetShop(c *fiber.Ctx) (err error) {
+	response, err := r.getShop(getTelegramID(c))
+	if err != nil {
+		return err
+	}
+
+	return Throw200Response(c, response)
+}
+
+func (r *REST) BuyShopItem(c *fiber.Ctx) (err error) {
+	req := &restModel.ShopBuyRequest{}
+
+	if err = bindJSON(c, req); err != nil {
+		return err
+	}
+
+	game, err := r.buyShopItem(getTelegramID(c), req)
+	if err != nil {
+		return err
+	}
+
+	return r.sendGame(c, game)
+}
@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+func TestGetShop(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	response := &restModel.Shop{}
+
+	if status := doTestJSONRequest(t, rst, http.MethodGet, "/api/v1/shop", ``, response); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if response.CurrentGold != 1000 || len(response.Items) != len(testShopItems) || response.Effects.CoinsMultiplier != 1 {
+		t.Errorf("unexpected shop: %+v", response)
+	}
+}
+
+func TestBuyShopItem(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected int
+		gold     uint64
+		coins    uint64
+	}{
+		{"item id is required", http.MethodPost, "/api/v1/shop/buy", ``, http.StatusBadRequest, 0, 0},
+		{"item not found", http.MethodPost, "/api/v1/shop/buy", `{"item_id":100}`, http.StatusBadRequest, 0, 0},
+		{"first click", http.MethodPost, "/api/v1/click", `{"card_id":1}`, http.StatusOK, 1000, 1},
+		{"click before timeout", http.MethodPost, "/api/v1/click", `{"card_id":1}`, http.StatusBadRequest, 0, 0},
+		{"click refresh", http.MethodPost, "/api/v1/shop/buy", `{"item_id":1}`, http.StatusOK, 980, 1},
+		{"click after refresh", http.MethodPost, "/api/v1/click", `{"card_id":1}`, http.StatusOK, 980, 2},
+		{"coins multiplier", http.MethodPost, "/api/v1/shop/buy", `{"item_id":2}`, http.StatusOK, 930, 2},
+		{"skip timeout without token", http.MethodPost, "/api/v1/click", `{"card_id":1,"skip_timeout":true}`, http.StatusBadRequest, 0, 0},
+		{"skip timeout token", http.MethodPost, "/api/v1/shop/buy", `{"item_id":3}`, http.StatusOK, 900, 2},
+		{"click with skip timeout", http.MethodPost, "/api/v1/click", `{"card_id":1,"skip_timeout":true}`, http.StatusOK, 900, 4},
+		{"not enough gold", http.MethodPost, "/api/v1/shop/buy", `{"item_id":4}`, http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, game := doTestRequest(t, rst, tc.method, tc.target, tc.body)
+			if status != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, status)
+			}
+
+			if game != nil && (game.CurrentGold != tc.gold || game.CurrentCoins != amount.New(tc.coins)) {
+				t.Errorf("expected %d gold and %d coins, got %d and %s", tc.gold, tc.coins, game.CurrentGold, game.CurrentCoins)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"time"
+
+	websocket "github.com/gofiber/contrib/websocket"
+	fiber "github.com/gofiber/fiber/v2"
+	zap "go.uber.org/zap"
+
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+const (
+	socketEnter     = "enter"
+	socketClick     = "click"
+	socketBuy       = "buy"
+	socketReset     = "reset"
+	socketShopBuy   = "shop_buy"
+	socketClaimTask = "claim_task"
+)
+
+// UpgradeSocket lets only the websocket upgrade requests through to the socket handler.
+func (r *REST) UpgradeSocket(c *fiber.Ctx) (err error) {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	return c.Next()
+}
+
+// ServeSocket reads the commands of the user from the websocket and answers through the hub,
+// which pushes the state diffs to every open connection of the user.
+func (r *REST) ServeSocket(conn *websocket.Conn) {
+	var (
+		data, _ = conn.Locals(localsInitData).(*restModel.InitData)
+		tgID    = data.User.ID
+		cl      = r.hub.subscribe(tgID)
+		done    = make(chan struct{})
+	)
+
+	r.lgr.Debug("websocket connected", zap.Uint64("telegram_id", tgID))
+
+	go func() {
+		defer close(done)
+
+		for msg := range cl.send {
+			if err := conn.WriteJSON(msg); err != nil {
+				r.lgr.Debug("error while writing to websocket", zap.Error(err))
+
+				break
+			}
+		}
+
+		// the hijacked connection is closed when the handler returns, so the reader is woken up
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+		_ = conn.SetReadDeadline(time.Now())
+	}()
+
+	for {
+		req := &restModel.SocketRequest{}
+
+		if err := conn.ReadJSON(req); err != nil {
+			r.lgr.Debug("websocket disconnected", zap.Uint64("telegram_id", tgID), zap.Error(err))
+
+			break
+		}
+
+		game, err := r.handleSocketRequest(tgID, data.StartParam, req)
+		if err != nil {
+			r.hub.reply(tgID, cl, r.socketError(tgID, req, err))
+
+			continue
+		}
+
+		r.hub.publish(tgID, game, cl, req.ID, req.Type == socketEnter)
+	}
+
+	r.hub.unsubscribe(tgID, cl)
+
+	<-done
+}
+
+// handleSocketRequest runs the command the same way the REST routes do.
+// The start param of the init data is used by the enter of a new user.
+func (r *REST) handleSocketRequest(tgID uint64, startParam string, req *restModel.SocketRequest) (_ *restModel.Game, err error) {
+	switch req.Type {
+	case socketEnter:
+		return r.enterGame(tgID, startParam)
+	case socketClick:
+		data := &restModel.ClickRequest{}
+
+		if err = decodeJSON(req.Data, data); err != nil {
+			return nil, err
+		}
+
+		return r.clickCard(tgID, data)
+	case socketBuy:
+		data := &restModel.BuyRequest{}
+
+		if err = decodeJSON(req.Data, data); err != nil {
+			return nil, err
+		}
+
+		return r.buyCard(tgID, data)
+	case socketReset:
+		return r.resetGame(tgID)
+	case socketShopBuy:
+		data := &restModel.ShopBuyRequest{}
+
+		if err = decodeJSON(req.Data, data); err != nil {
+			return nil, err
+		}
+
+		return r.buyShopItem(tgID, data)
+	case socketClaimTask:
+		data := &restModel.ClaimTaskRequest{}
+
+		if err = decodeJSON(req.Data, data); err != nil {
+			return nil, err
+		}
+
+		return r.claimTask(tgID, data)
+	default:
+		return nil, newValidationError(fieldType, ErrorTypeIsUnknown)
+	}
+}
+
+// socketError creates the error message of the command. Internal errors are logged and hidden like in handleError.
+func (r *REST) socketError(tgID uint64, req *restModel.SocketRequest, err error) *restModel.SocketMessage {
+	e := toError(err)
+
+	if e.Status >= fiber.StatusInternalServerError {
+		r.lgr.Error("error while handling websocket request",
+			zap.Uint64("telegram_id", tgID),
+			zap.String("id", req.ID),
+			zap.String("type", req.Type),
+			zap.Error(err),
+		)
+	}
+
+	return &restModel.SocketMessage{
+		ID:   req.ID,
+		Type: messageError,
+		Data: &restModel.Error{Error: e.Message, Code: e.Code, Details: e.Details, Fields: e.Fields},
+	}
+}
+
+// sendGame answers the REST request with the game and pushes its diff to the websockets of the user.
+func (r *REST) sendGame(c *fiber.Ctx, game *restModel.Game) (err error) {
+	r.hub.publish(getTelegramID(c), game, nil, "", false)
+
+	return Throw200Response(c, game)
+}
+
+// sendGameDiff answers the REST request with the diff and pushes it to the websockets of the user.
+func (r *REST) sendGameDiff(c *fiber.Ctx, diff *restModel.GameDiff) (err error) {
+	r.hub.publishDiff(getTelegramID(c), diff)
+
+	return Throw200Response(c, diff)
+}
@@ -0,0 +1,259 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	websocket "github.com/fasthttp/websocket"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+type (
+	// testSocketMessage is the message pushed by the server with the data left undecoded.
+	testSocketMessage struct {
+		ID   string          `json:"id"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+)
+
+// dialTestSocket serves the REST server on a random port and connects to its websocket as the test user.
+func dialTestSocket(t *testing.T, rst *REST) *websocket.Conn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	go func() { _ = rst.srv.Listener(ln) }()
+
+	t.Cleanup(func() { _ = rst.srv.Shutdown() })
+
+	initData := signTestInitData(testBotToken, time.Now(), map[string]string{
+		"user": `{"id":` + strconv.Itoa(testTelegramID) + `}`,
+	})
+
+	conn, res, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/api/v1/ws?"+url.Values{queryInitData: {initData}}.Encode(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status %d, got %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+// redialTestSocket connects one more websocket to the server started by dialTestSocket.
+func redialTestSocket(t *testing.T, conn *websocket.Conn) *websocket.Conn {
+	t.Helper()
+
+	initData := signTestInitData(testBotToken, time.Now(), map[string]string{
+		"user": `{"id":` + strconv.Itoa(testTelegramID) + `}`,
+	})
+
+	next, _, err := websocket.DefaultDialer.Dial("ws://"+conn.RemoteAddr().String()+"/api/v1/ws?"+url.Values{queryInitData: {initData}}.Encode(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Cleanup(func() { _ = next.Close() })
+
+	return next
+}
+
+// waitTestSubscribers waits for the websockets of the test user to be subscribed to the hub.
+func waitTestSubscribers(t *testing.T, rst *REST, count int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rst.hub.mu.Lock()
+		user := rst.hub.users[testTelegramID]
+		subscribed := user != nil && len(user.clients) == count
+		rst.hub.mu.Unlock()
+
+		if subscribed {
+			return
+		}
+	}
+
+	t.Fatalf("expected %d websockets to be subscribed", count)
+}
+
+// readTestSocket reads the next message from the websocket and decodes its data into dst.
+func readTestSocket(t *testing.T, conn *websocket.Conn, dst interface{}) *testSocketMessage {
+	t.Helper()
+
+	msg := &testSocketMessage{}
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := conn.ReadJSON(msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := json.Unmarshal(msg.Data, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return msg
+}
+
+func TestSocket(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	var (
+		first  = dialTestSocket(t, rst)
+		second = redialTestSocket(t, first)
+		game   = &restModel.Game{}
+		diff   = &restModel.GameDiff{}
+		errRes = &restModel.Error{}
+	)
+
+	waitTestSubscribers(t, rst, 2)
+
+	if err := first.WriteJSON(&restModel.SocketRequest{ID: "1", Type: socketEnter}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if msg := readTestSocket(t, first, game); msg.ID != "1" || msg.Type != messageState || game.CurrentGold != 1000 {
+		t.Fatalf("expected state of the new game, got %+v", msg)
+	}
+
+	if msg := readTestSocket(t, second, diff); msg.ID != "" || msg.Type != messageDiff || len(diff.Cards) != len(testCards) {
+		t.Fatalf("expected diff, got %+v", msg)
+	}
+
+	testCases := []struct {
+		name    string
+		request string
+		typ     string
+		coins   uint64
+		cards   int
+		code    string
+	}{
+		{"click", `{"id":"3","type":"click","data":{"card_id":1}}`, messageDiff, 1, 1, ""},
+		{"click before timeout", `{"id":"4","type":"click","data":{"card_id":1}}`, messageError, 0, 0, ErrCantClickNow.Code},
+		{"not enough coins", `{"id":"5","type":"buy","data":{"card_id":2}}`, messageError, 0, 0, ErrNotEnoughCoins.Code},
+		{"coins pack", `{"id":"6","type":"shop_buy","data":{"item_id":4}}`, messageDiff, 101, 0, ""},
+		{"buy", `{"id":"7","type":"buy","data":{"card_id":2}}`, messageDiff, 41, 1, ""},
+		{"unknown type", `{"id":"8","type":"jump"}`, messageError, 0, 0, ErrRequestIsInvalid.Code},
+		{"unknown field", `{"id":"9","type":"buy","data":{"card":2}}`, messageError, 0, 0, ErrRequestIsInvalid.Code},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &restModel.SocketRequest{}
+			if err := json.Unmarshal([]byte(tc.request), req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := first.WriteJSON(req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tc.typ == messageError {
+				if msg := readTestSocket(t, first, errRes); msg.ID != req.ID || msg.Type != messageError || errRes.Code != tc.code {
+					t.Fatalf("expected error %s, got %+v %+v", tc.code, msg, errRes)
+				}
+
+				return
+			}
+
+			for _, conn := range []*websocket.Conn{first, second} {
+				diff = &restModel.GameDiff{}
+
+				msg := readTestSocket(t, conn, diff)
+				if msg.Type != tc.typ || diff.CurrentCoins != amount.New(tc.coins) || len(diff.Cards) != tc.cards {
+					t.Fatalf("expected diff with %d coins and %d cards, got %+v %+v", tc.coins, tc.cards, msg, diff)
+				}
+
+				if (conn == first) != (msg.ID == req.ID) {
+					t.Errorf("expected id only for the sender, got %q", msg.ID)
+				}
+			}
+		})
+	}
+
+	// the REST routes push the diffs to the websockets too
+	if status, _ := doTestRequest(t, rst, http.MethodPost, "/api/v1/reset", ``); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if msg := readTestSocket(t, second, diff); msg.ID != "" || msg.Type != messageDiff || diff.CurrentCoins != amount.New(0) {
+		t.Fatalf("expected diff of the reset, got %+v %+v", msg, diff)
+	}
+}
+
+func TestSocketWithoutUpgrade(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	if status := doTestJSONRequest(t, rst, http.MethodGet, "/api/v1/ws", ``, &restModel.Error{}); status != http.StatusUpgradeRequired {
+		t.Errorf("expected status %d, got %d", http.StatusUpgradeRequired, status)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error, 1)
+	)
+
+	go func() { done <- rst.serve(ctx, func() error { return rst.srv.Listener(ln) }) }()
+
+	initData := signTestInitData(testBotToken, time.Now(), map[string]string{
+		"user": `{"id":` + strconv.Itoa(testTelegramID) + `}`,
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/api/v1/ws?"+url.Values{queryInitData: {initData}}.Encode(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	waitTestSubscribers(t, rst, 1)
+	cancel()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the server to shut down")
+	}
+
+	if err = conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err = conn.ReadMessage(); err == nil {
+		t.Errorf("expected the websocket to be closed")
+	}
+
+	if _, err = net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Errorf("expected the listener to be closed")
+	}
+}
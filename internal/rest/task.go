@@ -0,0 +1,207 @@
+package rest
+
+import (
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+	storage "github.com/adzpm/telegram-clicker/internal/storage"
+	task "github.com/adzpm/telegram-clicker/internal/task"
+)
+
+// updateTasksProgress saves the progress of the tasks calculated from the user state. The progress is calculated
+// when the tasks are shown or claimed and saved by the reset, not on every action, so the clicks stay in memory.
+// Errors are only logged, so a failed update never breaks the game action that triggered it.
+func (r *REST) updateTasksProgress(user *storageModel.User, userCards []storageModel.UserCard) {
+	userTasks, err := r.str.SelectUserTasks(user.TelegramID)
+	if err != nil {
+		r.lgr.Error("error while selecting user tasks", zap.Uint64("telegram_id", user.TelegramID), zap.Error(err))
+
+		return
+	}
+
+	userTasksMap := make(map[uint64]storageModel.UserTask, len(userTasks))
+
+	for _, userTask := range userTasks {
+		userTasksMap[userTask.TaskID] = userTask
+	}
+
+	for _, tsk := range r.tsk.All() {
+		var (
+			userTask = userTasksMap[tsk.ID]
+			progress = min(tsk.Progress(user, userCards), tsk.Target)
+		)
+
+		if userTask.Claimed || progress <= userTask.Progress {
+			continue
+		}
+
+		if _, err = r.str.UpdateUserTaskProgress(user.TelegramID, tsk.ID, progress); err != nil {
+			r.lgr.Error("error while updating user task progress",
+				zap.Uint64("telegram_id", user.TelegramID),
+				zap.Uint64("task_id", tsk.ID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// calculateTasksProgress returns the progress of the tasks by task id calculated from the user state.
+func (r *REST) calculateTasksProgress(user *storageModel.User, userCards []storageModel.UserCard) map[uint64]uint64 {
+	progress := make(map[uint64]uint64)
+
+	for _, tsk := range r.tsk.All() {
+		if p := min(tsk.Progress(user, userCards), tsk.Target); p > 0 {
+			progress[tsk.ID] = p
+		}
+	}
+
+	return progress
+}
+
+// claimReward returns the function giving the reward of the task if it's done.
+func (r *REST) claimReward(tsk *task.Task) storage.ClaimFunc {
+	return func(_ *storageModel.User, userTask *storageModel.UserTask) (coins amount.Amount, gold uint64, err error) {
+		if !tsk.IsDone(userTask.Progress) {
+			return amount.Zero, 0, storage.ErrTaskNotDone
+		}
+
+		return tsk.RewardCoins, tsk.RewardGold, nil
+	}
+}
+
+func (r *REST) createTasksResponse(userTasks []storageModel.UserTask) *restModel.Tasks {
+	var (
+		tasks        = r.tsk.All()
+		userTasksMap = make(map[uint64]storageModel.UserTask, len(userTasks))
+		response     = &restModel.Tasks{Tasks: make([]*restModel.Task, 0, len(tasks))}
+	)
+
+	for _, userTask := range userTasks {
+		userTasksMap[userTask.TaskID] = userTask
+	}
+
+	for _, tsk := range tasks {
+		userTask := userTasksMap[tsk.ID]
+
+		response.Tasks = append(response.Tasks, &restModel.Task{
+			ID:          tsk.ID,
+			Name:        tsk.Name,
+			Description: tsk.Description,
+			ImageURL:    tsk.ImageURL,
+			Type:        tsk.Type,
+			Channel:     tsk.Channel,
+			Target:      tsk.Target,
+			Progress:    userTask.Progress,
+			IsDone:      tsk.IsDone(userTask.Progress),
+			IsClaimed:   userTask.Claimed,
+			RewardCoins: tsk.RewardCoins,
+			RewardGold:  tsk.RewardGold,
+		})
+	}
+
+	return response
+}
+
+// getTasks returns the tasks with the progress of the user.
+func (r *REST) getTasks(tgID uint64) (_ *restModel.Tasks, err error) {
+	var (
+		user      *storageModel.User
+		userCards []storageModel.UserCard
+		userTasks []storageModel.UserTask
+	)
+
+	if user, err = r.str.SelectUser(tgID); err != nil {
+		return nil, err
+	}
+
+	if userCards, err = r.str.SelectUserCards(tgID); err != nil {
+		return nil, err
+	}
+
+	r.updateTasksProgress(user, userCards)
+
+	if userTasks, err = r.str.SelectUserTasks(tgID); err != nil {
+		return nil, err
+	}
+
+	return r.createTasksResponse(userTasks), nil
+}
+
+// claimTask gives the user the reward for the done task. The channel membership is checked right before the claim.
+func (r *REST) claimTask(tgID uint64, req *restModel.ClaimTaskRequest) (_ *restModel.Game, err error) {
+	if err = validateClaimTaskRequest(req); err != nil {
+		return nil, err
+	}
+
+	tsk, ok := r.tsk.Task(req.TaskID)
+	if !ok {
+		return nil, newValidationError(fieldTaskID, ErrorTaskNotFound)
+	}
+
+	r.lgr.Info("try to claim task", zap.Uint64("telegram_id", tgID), zap.Uint64("task_id", req.TaskID))
+
+	var (
+		tn        = uint64(time.Now().Unix())
+		user      *storageModel.User
+		userCards []storageModel.UserCard
+	)
+
+	if user, err = r.str.SelectUser(tgID); err != nil {
+		return nil, err
+	}
+
+	if userCards, err = r.str.SelectUserCards(tgID); err != nil {
+		return nil, err
+	}
+
+	r.updateTasksProgress(user, userCards)
+
+	if tsk.Type == task.TypeJoinChannel {
+		var isMember bool
+
+		if isMember, err = r.chk.IsMember(tsk.Channel, tgID); err != nil {
+			return nil, err
+		}
+
+		if isMember {
+			if _, err = r.str.UpdateUserTaskProgress(tgID, tsk.ID, tsk.Target); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if user, _, err = r.str.ClaimTask(tgID, tsk.ID, tn, r.claimReward(tsk)); err != nil {
+		return nil, err
+	}
+
+	return r.selectGame(user, userCards, tn)
+}
+
+func (r *REST) GetTasks(c *fiber.Ctx) (err error) {
+	response, err := r.getTasks(getTelegramID(c))
+	if err != nil {
+		return err
+	}
+
+	return Throw200Response(c, response)
+}
+
+func (r *REST) ClaimTask(c *fiber.Ctx) (err error) {
+	req := &restModel.ClaimTaskRequest{}
+
+	if err = bindJSON(c, req); err != nil {
+		return err
+	}
+
+	game, err := r.claimTask(getTelegramID(c), req)
+	if err != nil {
+		return err
+	}
+
+	return r.sendGame(c, game)
+}
@@ -0,0 +1,111 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	restModel "github.com/adzpm/telegram-clicker/internal/model/rest"
+)
+
+// testChannelChecker treats the test user as a member of every channel.
+type testChannelChecker struct{}
+
+func (testChannelChecker) IsMember(_ string, telegramID uint64) (bool, error) {
+	return telegramID == testTelegramID, nil
+}
+
+func TestGetTasks(t *testing.T) {
+	rst, _ := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/click", `{"card_id":1}`)
+
+	response := &restModel.Tasks{}
+
+	if status := doTestJSONRequest(t, rst, http.MethodGet, "/api/v1/tasks", ``, response); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	if len(response.Tasks) != len(testTasks) {
+		t.Fatalf("expected %d tasks, got %d", len(testTasks), len(response.Tasks))
+	}
+
+	for _, tsk := range response.Tasks {
+		if isDone := tsk.ID == 1; tsk.IsDone != isDone || tsk.IsClaimed {
+			t.Errorf("task %d: expected done %t and not claimed, got %+v", tsk.ID, isDone, tsk)
+		}
+	}
+}
+
+func TestClaimTask(t *testing.T) {
+	rst, str := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	if _, err := str.UpdateUserCoins(testTelegramID, amount.New(60)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected int
+		coins    uint64
+		gold     uint64
+	}{
+		{"task id is required", http.MethodPost, "/api/v1/tasks/claim", ``, http.StatusBadRequest, 0, 0},
+		{"task not found", http.MethodPost, "/api/v1/tasks/claim", `{"task_id":100}`, http.StatusBadRequest, 0, 0},
+		{"clicks not done", http.MethodPost, "/api/v1/tasks/claim", `{"task_id":1}`, http.StatusBadRequest, 0, 0},
+		{"click", http.MethodPost, "/api/v1/click", `{"card_id":1}`, http.StatusOK, 61, 1000},
+		{"clicks done", http.MethodPost, "/api/v1/tasks/claim", `{"task_id":1}`, http.StatusOK, 161, 1000},
+		{"clicks claimed", http.MethodPost, "/api/v1/tasks/claim", `{"task_id":1}`, http.StatusConflict, 0, 0},
+		{"buy card", http.MethodPost, "/api/v1/buy", `{"card_id":2}`, http.StatusOK, 101, 1000},
+		{"card level done", http.MethodPost, "/api/v1/tasks/claim", `{"task_id":2}`, http.StatusOK, 101, 1010},
+		{"prestige not done", http.MethodPost, "/api/v1/tasks/claim", `{"task_id":3}`, http.StatusBadRequest, 0, 0},
+		{"reset", http.MethodPost, "/api/v1/reset", ``, http.StatusOK, 0, 1010},
+		{"prestige done", http.MethodPost, "/api/v1/tasks/claim", `{"task_id":3}`, http.StatusOK, 0, 1030},
+		{"join channel", http.MethodPost, "/api/v1/tasks/claim", `{"task_id":4}`, http.StatusOK, 0, 1060},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, game := doTestRequest(t, rst, tc.method, tc.target, tc.body)
+			if status != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, status)
+			}
+
+			if game != nil && (game.CurrentCoins != amount.New(tc.coins) || game.CurrentGold != tc.gold) {
+				t.Errorf("expected %d coins and %d gold, got %s and %d", tc.coins, tc.gold, game.CurrentCoins, game.CurrentGold)
+			}
+		})
+	}
+}
+
+func TestTasksProgressAfterReset(t *testing.T) {
+	rst, str := newTestREST(t)
+
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/enter", ``)
+
+	if _, err := str.UpdateUserCoins(testTelegramID, amount.New(60)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the card level is saved by the reset, nothing saves it on the buy
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/buy", `{"card_id":2}`)
+	doTestRequest(t, rst, http.MethodPost, "/api/v1/reset", ``)
+
+	response := &restModel.Tasks{}
+
+	if status := doTestJSONRequest(t, rst, http.MethodGet, "/api/v1/tasks", ``, response); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	for _, tsk := range response.Tasks {
+		if tsk.ID == 2 && !tsk.IsDone {
+			t.Errorf("expected the card level task done after the reset, got %+v", tsk)
+		}
+	}
+}
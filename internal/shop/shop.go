@@ -0,0 +1,161 @@
+package shop
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+const (
+	// ItemTypeClickRefresh makes all cards clickable right now.
+	ItemTypeClickRefresh = "click_refresh"
+	// ItemTypeCoinsMultiplier multiplies the coins per click by Value for Duration seconds.
+	ItemTypeCoinsMultiplier = "coins_multiplier"
+	// ItemTypeSkipTimeout gives Value tokens to click a card before its timeout ends.
+	ItemTypeSkipTimeout = "skip_timeout"
+	// ItemTypeCoinsPack gives Value coins.
+	ItemTypeCoinsPack = "coins_pack"
+)
+
+type (
+	Item struct {
+		ID       uint64  `json:"id"`
+		Name     string  `json:"name"`
+		ImageURL string  `json:"image_url"`
+		Type     string  `json:"type"`
+		Price    uint64  `json:"price"`
+		Value    float64 `json:"value"`
+		Duration uint64  `json:"duration"`
+	}
+
+	Shop struct {
+		items []Item
+		index map[uint64]*Item
+	}
+)
+
+// New creates a new Shop with the given items.
+func New(items []Item) (_ *Shop, err error) {
+	s := &Shop{
+		items: items,
+		index: make(map[uint64]*Item, len(items)),
+	}
+
+	for i := range s.items {
+		item := &s.items[i]
+
+		if err = item.validate(); err != nil {
+			return nil, err
+		}
+
+		if _, ok := s.index[item.ID]; ok {
+			return nil, fmt.Errorf("shop item %d: duplicated id", item.ID)
+		}
+
+		s.index[item.ID] = item
+	}
+
+	return s, nil
+}
+
+// Read loads the shop catalog from the json file.
+func Read(path string) (_ *Shop, err error) {
+	var (
+		fb    []byte
+		items []Item
+	)
+
+	if fb, err = os.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(fb, &items); err != nil {
+		return nil, err
+	}
+
+	return New(items)
+}
+
+func (i *Item) validate() error {
+	switch {
+	case i.ID == 0:
+		return fmt.Errorf("shop item %q: id is required", i.Name)
+	case i.Price == 0:
+		return fmt.Errorf("shop item %d: price is required", i.ID)
+	case i.Type == ItemTypeCoinsMultiplier && (i.Value <= 0 || i.Duration == 0):
+		return fmt.Errorf("shop item %d: coins multiplier requires value and duration", i.ID)
+	case (i.Type == ItemTypeSkipTimeout || i.Type == ItemTypeCoinsPack) && i.Value < 1:
+		return fmt.Errorf("shop item %d: %s requires value", i.ID, i.Type)
+	case i.Type == ItemTypeClickRefresh, i.Type == ItemTypeCoinsMultiplier,
+		i.Type == ItemTypeSkipTimeout, i.Type == ItemTypeCoinsPack:
+		return nil
+	default:
+		return fmt.Errorf("shop item %d: unknown type %q", i.ID, i.Type)
+	}
+}
+
+// Items returns all items of the shop.
+func (s *Shop) Items() []Item { return s.items }
+
+// Item returns the item by id.
+func (s *Shop) Item(id uint64) (*Item, bool) {
+	item, ok := s.index[id]
+
+	return item, ok
+}
+
+// NewPurchase creates the purchase of the item, describing everything the user gets for it.
+func (s *Shop) NewPurchase(telegramID uint64, item *Item, now uint64) *storageModel.UserPurchase {
+	purchase := &storageModel.UserPurchase{
+		TelegramID: telegramID,
+		ItemID:     item.ID,
+		Price:      item.Price,
+		CreatedAt:  now,
+	}
+
+	switch item.Type {
+	case ItemTypeClickRefresh:
+		purchase.RefreshClicks = true
+	case ItemTypeCoinsMultiplier:
+		purchase.CoinsMultiplier = item.Value
+		purchase.ExpiresAt = now + item.Duration
+	case ItemTypeSkipTimeout:
+		purchase.SkipTimeouts = uint64(item.Value)
+	case ItemTypeCoinsPack:
+		purchase.Coins = amount.FromFloat(item.Value)
+	}
+
+	return purchase
+}
+
+// CoinsMultiplier returns the strongest coins multiplier active at the moment and the time it expires.
+// The multipliers don't stack, buying one more only matters if it's stronger or lasts longer.
+func CoinsMultiplier(purchases []storageModel.UserPurchase, now uint64) (multiplier float64, expiresAt uint64) {
+	multiplier = 1
+
+	for _, purchase := range purchases {
+		if purchase.ExpiresAt <= now || purchase.CoinsMultiplier < multiplier {
+			continue
+		}
+
+		if purchase.CoinsMultiplier > multiplier {
+			multiplier, expiresAt = purchase.CoinsMultiplier, purchase.ExpiresAt
+		} else if purchase.CoinsMultiplier > 1 {
+			expiresAt = max(expiresAt, purchase.ExpiresAt)
+		}
+	}
+
+	return multiplier, expiresAt
+}
+
+// SkipTimeouts returns the number of the timeout skips left.
+func SkipTimeouts(purchases []storageModel.UserPurchase) (skips uint64) {
+	for _, purchase := range purchases {
+		skips += purchase.SkipTimeouts
+	}
+
+	return skips
+}
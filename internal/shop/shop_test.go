@@ -0,0 +1,57 @@
+package shop
+
+import (
+	"testing"
+
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+func TestNew(t *testing.T) {
+	testCases := map[string]struct {
+		items   []Item
+		isError bool
+	}{
+		"valid":                    {[]Item{{ID: 1, Type: ItemTypeClickRefresh, Price: 1}, {ID: 2, Type: ItemTypeCoinsPack, Price: 1, Value: 10}}, false},
+		"empty id":                 {[]Item{{Type: ItemTypeClickRefresh, Price: 1}}, true},
+		"free item":                {[]Item{{ID: 1, Type: ItemTypeCoinsPack, Value: 10}}, true},
+		"free multiplier":          {[]Item{{ID: 1, Type: ItemTypeCoinsMultiplier, Value: 2, Duration: 60}}, true},
+		"duplicated id":            {[]Item{{ID: 1, Type: ItemTypeClickRefresh, Price: 1}, {ID: 1, Type: ItemTypeClickRefresh, Price: 1}}, true},
+		"unknown type":             {[]Item{{ID: 1, Type: "unknown", Price: 1}}, true},
+		"multiplier with duration": {[]Item{{ID: 1, Type: ItemTypeCoinsMultiplier, Price: 1, Value: 2}}, true},
+		"skip timeout with value":  {[]Item{{ID: 1, Type: ItemTypeSkipTimeout, Price: 1}}, true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(tc.items); (err != nil) != tc.isError {
+				t.Errorf("expected error %t, got %v", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestCoinsMultiplier(t *testing.T) {
+	testCases := map[string]struct {
+		purchases  []storageModel.UserPurchase
+		now        uint64
+		multiplier float64
+		expiresAt  uint64
+	}{
+		"no purchases": {nil, 100, 1, 0},
+		"active":       {[]storageModel.UserPurchase{{CoinsMultiplier: 2, ExpiresAt: 200}}, 100, 2, 200},
+		"expired":      {[]storageModel.UserPurchase{{CoinsMultiplier: 2, ExpiresAt: 100}}, 100, 1, 0},
+		"not stacked":  {[]storageModel.UserPurchase{{CoinsMultiplier: 2, ExpiresAt: 200}, {CoinsMultiplier: 3, ExpiresAt: 300}, {SkipTimeouts: 1}}, 100, 3, 300},
+		"strongest":    {[]storageModel.UserPurchase{{CoinsMultiplier: 3, ExpiresAt: 200}, {CoinsMultiplier: 2, ExpiresAt: 300}}, 100, 3, 200},
+		"same":         {[]storageModel.UserPurchase{{CoinsMultiplier: 2, ExpiresAt: 300}, {CoinsMultiplier: 2, ExpiresAt: 200}, {CoinsMultiplier: 2, ExpiresAt: 200}}, 100, 2, 300},
+		"many":         {[]storageModel.UserPurchase{{CoinsMultiplier: 2, ExpiresAt: 200}, {CoinsMultiplier: 2, ExpiresAt: 200}, {CoinsMultiplier: 2, ExpiresAt: 200}}, 100, 2, 200},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			multiplier, expiresAt := CoinsMultiplier(tc.purchases, tc.now)
+			if multiplier != tc.multiplier || expiresAt != tc.expiresAt {
+				t.Errorf("expected %f and %d, got %f and %d", tc.multiplier, tc.expiresAt, multiplier, expiresAt)
+			}
+		})
+	}
+}
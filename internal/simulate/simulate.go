@@ -0,0 +1,326 @@
+package simulate
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	stdmath "math"
+	"slices"
+	"strconv"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	math "github.com/adzpm/telegram-clicker/internal/math"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+// startCardID is the card the players start with and keep after the prestige, as in the game.
+const startCardID uint64 = 1
+
+type (
+	// Options are the length of the simulation and the strategy of the player.
+	Options struct {
+		// Duration is the number of the simulated seconds.
+		Duration uint64
+		// SampleInterval is the number of seconds between the samples.
+		SampleInterval uint64
+		// PrestigeGain is the growth of the investors multiplier, in percents, the player prestiges for.
+		PrestigeGain float64
+	}
+
+	// Sample is the state of the player at the time.
+	Sample struct {
+		Time           uint64
+		Coins          amount.Amount
+		EarnedCoins    amount.Amount
+		CoinsPerSecond float64
+		Investors      uint64
+		Prestiges      uint64
+		Cards          uint64
+		Levels         uint64
+	}
+
+	// Result is the outcome of the simulation. The times are the seconds from the start: Unlocks holds
+	// the first purchase of every card bought, FirstPrestige is zero if the player never prestiged.
+	Result struct {
+		Unlocks       map[uint64]uint64
+		FirstPrestige uint64
+		Prestiges     uint64
+		Samples       []Sample
+	}
+
+	// player is the state of the simulated game. The cards values depend on the levels and the investors only,
+	// so they are kept per card and refreshed when those change.
+	player struct {
+		mth       *math.Math
+		cards     []storageModel.Card
+		levels    []uint64
+		nextClick []uint64
+		coins     []amount.Amount
+		timeouts  []uint64
+
+		balance   amount.Amount
+		earned    amount.Amount
+		investors uint64
+		prestiges uint64
+	}
+
+	// purchase is the next card level the player buys, ok is false if nothing is worth buying.
+	purchase struct {
+		card  int
+		price amount.Amount
+		ok    bool
+	}
+)
+
+// Run simulates the player who is always online: clicks every card as soon as it's ready, buys the level
+// which pays back first once it's affordable and prestiges when the investors multiplier grows by PrestigeGain.
+// The purchases of the shop and the tasks are left out.
+func Run(mth *math.Math, cards []storageModel.Card, opts Options) *Result {
+	var (
+		p        = newPlayer(mth, cards)
+		res      = &Result{Unlocks: make(map[uint64]uint64, len(cards))}
+		next     = p.bestPurchase()
+		interval = max(opts.SampleInterval, 1)
+		sampleAt uint64
+	)
+
+	for i, card := range p.cards {
+		if p.levels[i] > 0 {
+			res.Unlocks[card.ID] = 0
+		}
+	}
+
+	for now := uint64(0); now <= opts.Duration; {
+		p.click(now)
+
+		if p.prestigeWorth(opts.PrestigeGain) {
+			p.prestige()
+
+			if res.Prestiges++; res.Prestiges == 1 {
+				res.FirstPrestige = now
+			}
+
+			next = p.bestPurchase()
+		}
+
+		for next.ok && p.balance.Cmp(next.price) >= 0 {
+			if p.buy(next) {
+				if _, ok := res.Unlocks[p.cards[next.card].ID]; !ok {
+					res.Unlocks[p.cards[next.card].ID] = now
+				}
+			}
+
+			next = p.bestPurchase()
+		}
+
+		// nothing changes until the next click
+		then := p.nextEvent(now, opts.Duration)
+
+		for ; sampleAt < then && sampleAt <= opts.Duration; sampleAt += interval {
+			res.Samples = append(res.Samples, p.sample(sampleAt))
+		}
+
+		now = then
+	}
+
+	return res
+}
+
+// WriteCSV writes the samples as csv with a header, one row per sample.
+func WriteCSV(w io.Writer, samples []Sample) (err error) {
+	cw := csv.NewWriter(w)
+
+	if err = cw.Write([]string{
+		"time", "day", "coins", "earned_coins", "coins_per_second", "investors", "prestiges", "cards", "levels",
+	}); err != nil {
+		return err
+	}
+
+	for _, s := range samples {
+		if err = cw.Write([]string{
+			strconv.FormatUint(s.Time, 10),
+			strconv.FormatFloat(float64(s.Time)/86400, 'f', 3, 64),
+			s.Coins.String(),
+			s.EarnedCoins.String(),
+			strconv.FormatFloat(s.CoinsPerSecond, 'g', 6, 64),
+			strconv.FormatUint(s.Investors, 10),
+			strconv.FormatUint(s.Prestiges, 10),
+			strconv.FormatUint(s.Cards, 10),
+			strconv.FormatUint(s.Levels, 10),
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	if err = cw.Error(); err != nil {
+		return fmt.Errorf("write csv: %w", err)
+	}
+
+	return nil
+}
+
+// newPlayer creates the player of a new game, who has the start card only.
+func newPlayer(mth *math.Math, cards []storageModel.Card) *player {
+	p := &player{
+		mth:       mth,
+		cards:     slices.Clone(cards),
+		levels:    make([]uint64, len(cards)),
+		nextClick: make([]uint64, len(cards)),
+		coins:     make([]amount.Amount, len(cards)),
+		timeouts:  make([]uint64, len(cards)),
+	}
+
+	slices.SortFunc(p.cards, func(a, b storageModel.Card) int { return cmp.Compare(a.ID, b.ID) })
+
+	p.reset()
+
+	return p
+}
+
+// reset puts the cards back to the start, the start card on the level 1 and the rest not bought.
+func (p *player) reset() {
+	for i, card := range p.cards {
+		p.levels[i], p.nextClick[i] = 0, 0
+
+		if card.ID == startCardID {
+			p.levels[i] = 1
+		}
+
+		p.refresh(i)
+	}
+}
+
+// refresh calculates the coins per click and the click timeout of the card on its level,
+// the same way the game does with no shop effects.
+func (p *player) refresh(i int) {
+	p.coins[i], p.timeouts[i] = p.cardValues(i, p.levels[i])
+}
+
+// cardValues returns the coins per click and the click timeout of the card on the level.
+// The cards without timeout are clicked once a second.
+func (p *player) cardValues(i int, level uint64) (coins amount.Amount, timeout uint64) {
+	card := &p.cards[i]
+
+	return p.mth.CalculateCardClickCoins(card, level, p.investors, 1), max(p.mth.CalculateCardClickTimeout(card, level), 1)
+}
+
+// rate returns the coins per second the card earns on the level.
+func (p *player) rate(i int, level uint64) float64 {
+	coins, timeout := p.cardValues(i, level)
+
+	return coins.Float64() / float64(timeout)
+}
+
+// click clicks every bought card which is ready.
+func (p *player) click(now uint64) {
+	for i := range p.cards {
+		if p.levels[i] == 0 || p.nextClick[i] > now {
+			continue
+		}
+
+		p.balance = p.balance.Add(p.coins[i])
+		p.earned = p.earned.Add(p.coins[i])
+		p.nextClick[i] = now + p.timeouts[i]
+	}
+}
+
+// nextEvent returns the time of the next click, after the end if there is nothing to click.
+func (p *player) nextEvent(now, end uint64) uint64 {
+	next := end + 1
+
+	for i := range p.cards {
+		if p.levels[i] > 0 {
+			next = min(next, max(p.nextClick[i], now+1))
+		}
+	}
+
+	return next
+}
+
+// bestPurchase returns the next level of the card which pays back its price first.
+func (p *player) bestPurchase() (best purchase) {
+	bestPayback := stdmath.Inf(1)
+
+	for i, card := range p.cards {
+		level := p.levels[i]
+
+		if card.MaxLevel > 0 && level >= card.MaxLevel {
+			continue
+		}
+
+		gain := p.rate(i, level+1) - p.rate(i, level)
+		if !(gain > 0) {
+			continue
+		}
+
+		price := p.mth.CalculateCardUpgradePrice(&card, level)
+
+		if payback := price.Float64() / gain; payback < bestPayback {
+			best, bestPayback = purchase{card: i, price: price, ok: true}, payback
+		}
+	}
+
+	return best
+}
+
+// buy buys the level of the purchase and reports whether the card was bought for the first time.
+func (p *player) buy(next purchase) (unlocked bool) {
+	unlocked = p.levels[next.card] == 0
+
+	p.balance = p.balance.Sub(next.price)
+	p.levels[next.card]++
+	p.refresh(next.card)
+
+	return unlocked
+}
+
+// prestigeWorth reports whether the investors after the reset raise the multiplier by gain percents.
+func (p *player) prestigeWorth(gain float64) bool {
+	investors := p.mth.CalculateInvestorsCount(p.earned)
+
+	if investors <= p.investors {
+		return false
+	}
+
+	var (
+		current = p.mth.CalculateInvestorsMultiplier(p.investors)
+		after   = p.mth.CalculateInvestorsMultiplier(investors)
+	)
+
+	return after > current && (after/current-1)*100 >= gain
+}
+
+// prestige resets the game for the investors of the earned coins.
+func (p *player) prestige() {
+	p.investors = p.mth.CalculateInvestorsCount(p.earned)
+	p.prestiges++
+	p.balance, p.earned = amount.Zero, amount.Zero
+
+	p.reset()
+}
+
+// sample returns the current state of the player at the time.
+func (p *player) sample(at uint64) Sample {
+	s := Sample{
+		Time:        at,
+		Coins:       p.balance,
+		EarnedCoins: p.earned,
+		Investors:   p.investors,
+		Prestiges:   p.prestiges,
+	}
+
+	for i := range p.cards {
+		if p.levels[i] == 0 {
+			continue
+		}
+
+		s.Cards++
+		s.Levels += p.levels[i]
+		s.CoinsPerSecond += p.coins[i].Float64() / float64(p.timeouts[i])
+	}
+
+	return s
+}
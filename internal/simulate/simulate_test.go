@@ -0,0 +1,99 @@
+package simulate
+
+import (
+	"bytes"
+	"testing"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	math "github.com/adzpm/telegram-clicker/internal/math"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+var testCards = []storageModel.Card{
+	{ID: 2, Name: "Card 2", Price: amount.New(60), PriceMultiplier: 1.25, CoinsPerClick: amount.New(30), ClickTimeout: 2, UpgradeLevel: 50, MaxLevel: 1000},
+	{ID: 1, Name: "Card 1", Price: amount.New(5), PriceMultiplier: 1.25, CoinsPerClick: amount.New(1), ClickTimeout: 1, UpgradeLevel: 50, MaxLevel: 1000},
+	{ID: 3, Name: "Card 3", Price: amount.New(1e15), PriceMultiplier: 1.25, CoinsPerClick: amount.New(1), ClickTimeout: 5, MaxLevel: 10},
+}
+
+func newTestMath(earnedCoinsForInvestor uint64) *math.Math {
+	return math.New(&config.GameVariables{
+		EarnedCoinsForInvestor: earnedCoinsForInvestor,
+		PercentsForInvestor:    0.02,
+		Milestones:             []config.Milestone{{CoinsMultiplier: 2}, {TimeoutMultiplier: 0.5}},
+	})
+}
+
+func TestRun(t *testing.T) {
+	testCases := map[string]struct {
+		earnedCoinsForInvestor uint64
+		prestigeGain           float64
+		unlocked               []uint64
+		prestiged              bool
+	}{
+		"no prestige":       {1 << 62, 100, []uint64{1, 2}, false},
+		"prestige":          {1000, 10, []uint64{1, 2}, true},
+		"unreachable gains": {1000, 1e12, []uint64{1, 2}, false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			res := Run(newTestMath(tc.earnedCoinsForInvestor), testCards, Options{Duration: 3600, SampleInterval: 60, PrestigeGain: tc.prestigeGain})
+
+			if len(res.Unlocks) != len(tc.unlocked) || res.Unlocks[1] != 0 {
+				t.Fatalf("expected unlocked cards %v, got %v", tc.unlocked, res.Unlocks)
+			}
+
+			for _, id := range tc.unlocked {
+				if _, ok := res.Unlocks[id]; !ok {
+					t.Errorf("expected card %d unlocked, got %v", id, res.Unlocks)
+				}
+			}
+
+			if (res.Prestiges > 0) != tc.prestiged || (res.FirstPrestige > 0) != tc.prestiged {
+				t.Errorf("expected prestiged %v, got %d prestiges, the first at %d", tc.prestiged, res.Prestiges, res.FirstPrestige)
+			}
+
+			// a sample every minute including both ends
+			if len(res.Samples) != 61 || res.Samples[60].Time != 3600 {
+				t.Fatalf("expected 61 samples, got %d", len(res.Samples))
+			}
+
+			if last := res.Samples[60]; last.Prestiges != res.Prestiges || last.Cards != 2 || !(last.CoinsPerSecond > 0) {
+				t.Errorf("unexpected last sample %+v", last)
+			}
+
+			if !tc.prestiged && res.Samples[60].Coins.Cmp(res.Samples[60].EarnedCoins) > 0 {
+				t.Errorf("expected coins not above the earned coins, got %+v", res.Samples[60])
+			}
+		})
+	}
+}
+
+func TestRunStartCardOnly(t *testing.T) {
+	// the start card is clicked every second and there is nothing to buy
+	cards := []storageModel.Card{{ID: 1, Price: amount.New(5), PriceMultiplier: 1.25, CoinsPerClick: amount.New(3), ClickTimeout: 1, MaxLevel: 1}}
+
+	res := Run(newTestMath(1<<62), cards, Options{Duration: 100, SampleInterval: 100})
+
+	if len(res.Samples) != 2 || res.Samples[1].EarnedCoins != amount.New(303) || res.Samples[1].CoinsPerSecond != 3 {
+		t.Errorf("expected 303 coins earned at 3 coins per second, got %+v", res.Samples)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+
+	samples := []Sample{{Time: 43200, Coins: amount.Max, EarnedCoins: amount.New(7), CoinsPerSecond: 1.5, Investors: 2, Prestiges: 1, Cards: 3, Levels: 10}}
+
+	if err := WriteCSV(&buf, samples); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "time,day,coins,earned_coins,coins_per_second,investors,prestiges,cards,levels\n" +
+		"43200,0.500," + amount.Max.String() + ",7,1.5,2,1,3,10\n"
+
+	if result := buf.String(); result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
@@ -0,0 +1,637 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+type (
+	// Buffered applies the clicks to the state of the users kept in memory and writes them
+	// to the wrapped storage in batches: every flush interval, before any other change
+	// of the user and on shutdown. The active purchases of the loaded users are cached too,
+	// they only change through the methods which evict the user. Every other call goes to the wrapped storage,
+	// so CountRanked counts the scores without the clicks which aren't flushed yet.
+	//
+	// Crash safety: the clicks which aren't flushed yet, at most one flush interval of them,
+	// are lost if the process dies without the final flush. Everything else is written through.
+	// The users are cached by the process, so only one process may serve the same storage.
+	// A failed flush is logged and retried by the next one, the clicks stay visible meanwhile.
+	Buffered struct {
+		Storage
+
+		lgr      *zap.Logger
+		interval time.Duration
+
+		mu    sync.Mutex
+		users map[uint64]*bufferedUser
+
+		// flushMu serializes the writes of the batches, failed keeps the batches to retry
+		flushMu sync.Mutex
+		failed  map[uint64]*ClickBatch
+	}
+
+	// bufferedUser is the state of the user with the clicks which aren't flushed yet.
+	// The user isn't loaded while user is nil, removed entries are dropped from the users.
+	bufferedUser struct {
+		mu      sync.Mutex
+		user    *storageModel.User
+		cards   []storageModel.UserCard
+		byID    map[uint64]int
+		batch   *ClickBatch
+		bought  *bufferedPurchases
+		idle    bool
+		removed bool
+	}
+
+	// bufferedPurchases are the purchases of the user active at the time they were selected.
+	bufferedPurchases struct {
+		at        uint64
+		purchases []storageModel.UserPurchase
+	}
+)
+
+// NewBuffered wraps the storage with the click buffer flushed every interval by Run.
+func NewBuffered(lgr *zap.Logger, str Storage, interval time.Duration) *Buffered {
+	return &Buffered{
+		Storage:  str,
+		lgr:      lgr,
+		interval: interval,
+		users:    make(map[uint64]*bufferedUser),
+		failed:   make(map[uint64]*ClickBatch),
+	}
+}
+
+// Run flushes the clicks every interval until the context is done and then flushes them for the last time.
+// Returns the error of the last flush.
+func (b *Buffered) Run(ctx context.Context) (err error) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err = b.Flush(); err != nil {
+				b.lgr.Error("error while flushing clicks on exit", zap.Error(err))
+			}
+
+			return err
+		case <-ticker.C:
+			if err = b.Flush(); err != nil {
+				b.lgr.Error("error while flushing clicks", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush writes the buffered clicks of every user to the storage in one batch
+// and forgets the users who haven't clicked since the previous flush.
+func (b *Buffered) Flush() (err error) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	entries := make(map[uint64]*bufferedUser, len(b.users))
+	for telegramID, e := range b.users {
+		entries[telegramID] = e
+	}
+	b.mu.Unlock()
+
+	pending := b.failed
+	b.failed = make(map[uint64]*ClickBatch)
+
+	for telegramID, e := range entries {
+		e.mu.Lock()
+
+		switch {
+		case e.batch != nil:
+			pending[telegramID] = mergeClicks(pending[telegramID], e.batch)
+			e.batch, e.idle = nil, true
+		case e.idle && pending[telegramID] == nil:
+			b.remove(telegramID, e)
+		default:
+			e.idle = true
+		}
+
+		e.mu.Unlock()
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	batches := make([]ClickBatch, 0, len(pending))
+	for _, batch := range pending {
+		batches = append(batches, *batch)
+	}
+
+	if err = b.Storage.ApplyClicks(batches); err != nil {
+		b.failed = pending
+
+		return err
+	}
+
+	b.lgr.Debug("clicks flushed", zap.Int("users", len(batches)))
+
+	return nil
+}
+
+// Close flushes the buffered clicks and closes the wrapped storage.
+func (b *Buffered) Close() (err error) {
+	return errors.Join(b.Flush(), b.Storage.Close())
+}
+
+// Click clicks the card of the user in memory. The timeout skips are spent by the wrapped storage,
+// so a click before the timeout with skipTimeout set flushes the user and goes to the storage.
+func (b *Buffered) Click(telegramID, cardID, now uint64, skipTimeout bool, fn ClickFunc) (_ *storageModel.User, _ *storageModel.UserCard, err error) {
+	var (
+		e    *bufferedUser
+		card *storageModel.Card
+	)
+
+	if card, err = b.Storage.SelectCard(cardID); err != nil {
+		return nil, nil, err
+	}
+
+	if e, err = b.load(telegramID); err != nil {
+		return nil, nil, err
+	}
+
+	i, ok := e.byID[cardID]
+	if !ok {
+		e.mu.Unlock()
+
+		return nil, nil, ErrRecordNotFound
+	}
+
+	userCard := &e.cards[i]
+
+	if userCard.Level == 0 {
+		e.mu.Unlock()
+
+		return nil, nil, ErrCardNotBought
+	}
+
+	if nextClick := userCard.NextClick; now < nextClick {
+		e.mu.Unlock()
+
+		if !skipTimeout {
+			return nil, nil, &ClickTimeoutError{Now: now, NextClick: nextClick}
+		}
+
+		var (
+			user *storageModel.User
+			next *storageModel.UserCard
+		)
+
+		err = b.evicted(telegramID, func() (err error) {
+			user, next, err = b.Storage.Click(telegramID, cardID, now, skipTimeout, fn)
+			return err
+		})
+
+		return user, next, err
+	}
+
+	defer e.mu.Unlock()
+
+	coins, timeout := fn(copyOf(e.user), card, copyOf(userCard))
+
+	e.user.Coins = e.user.Coins.Add(coins)
+	earn(e.user, coins, now)
+	e.user.Clicks++
+	e.user.LastSeen = now
+	e.user.Version++
+	userCard.LastClick = now
+	userCard.NextClick = now + timeout
+
+	if e.batch == nil {
+		e.batch = &ClickBatch{TelegramID: telegramID, Cards: make(map[uint64]ClickedCard)}
+	}
+
+	e.batch.Coins = e.batch.Coins.Add(coins)
+	e.batch.Clicks++
+	e.batch.LastSeen = now
+	e.batch.Cards[cardID] = ClickedCard{LastClick: userCard.LastClick, NextClick: userCard.NextClick}
+	e.idle = false
+
+	return copyOf(e.user), copyOf(userCard), nil
+}
+
+func (b *Buffered) SelectUser(telegramID uint64) (_ *storageModel.User, err error) {
+	if e := b.loaded(telegramID); e != nil {
+		defer e.mu.Unlock()
+
+		return copyOf(e.user), nil
+	}
+
+	return b.Storage.SelectUser(telegramID)
+}
+
+func (b *Buffered) SelectUsers() (_ []storageModel.User, err error) {
+	if err = b.Flush(); err != nil {
+		return nil, err
+	}
+
+	return b.Storage.SelectUsers()
+}
+
+// SelectRanked flushes the clicks first, so the scores include them.
+func (b *Buffered) SelectRanked(ranking Ranking, day, limit uint64) (_ []storageModel.User, err error) {
+	if err = b.Flush(); err != nil {
+		return nil, err
+	}
+
+	return b.Storage.SelectRanked(ranking, day, limit)
+}
+
+// SelectUserPurchases serves the purchases of the loaded user from memory: the purchases active
+// at the time they were selected which are still active at now.
+func (b *Buffered) SelectUserPurchases(telegramID, now uint64) (purchases []storageModel.UserPurchase, err error) {
+	e := b.loaded(telegramID)
+	if e == nil {
+		return b.Storage.SelectUserPurchases(telegramID, now)
+	}
+
+	defer e.mu.Unlock()
+
+	if e.bought == nil || now < e.bought.at {
+		if purchases, err = b.Storage.SelectUserPurchases(telegramID, now); err != nil {
+			return nil, err
+		}
+
+		e.bought = &bufferedPurchases{at: now, purchases: purchases}
+
+		return slices.Clone(purchases), nil
+	}
+
+	for _, purchase := range e.bought.purchases {
+		if purchase.ExpiresAt > now || purchase.SkipTimeouts > 0 {
+			purchases = append(purchases, purchase)
+		}
+	}
+
+	return purchases, nil
+}
+
+func (b *Buffered) SelectUserCard(telegramID, cardID uint64) (_ *storageModel.UserCard, err error) {
+	if e := b.loaded(telegramID); e != nil {
+		defer e.mu.Unlock()
+
+		i, ok := e.byID[cardID]
+		if !ok {
+			return nil, ErrRecordNotFound
+		}
+
+		return copyOf(&e.cards[i]), nil
+	}
+
+	return b.Storage.SelectUserCard(telegramID, cardID)
+}
+
+func (b *Buffered) SelectUserCards(telegramID uint64) (_ []storageModel.UserCard, err error) {
+	if e := b.loaded(telegramID); e != nil {
+		defer e.mu.Unlock()
+
+		return slices.Clone(e.cards), nil
+	}
+
+	return b.Storage.SelectUserCards(telegramID)
+}
+
+func (b *Buffered) UpdateUserCoins(telegramID uint64, coins amount.Amount) (user *storageModel.User, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, err = b.Storage.UpdateUserCoins(telegramID, coins)
+		return err
+	})
+
+	return user, err
+}
+
+func (b *Buffered) UpdateUserGold(telegramID, gold uint64) (user *storageModel.User, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, err = b.Storage.UpdateUserGold(telegramID, gold)
+		return err
+	})
+
+	return user, err
+}
+
+func (b *Buffered) UpdateUserInvestors(telegramID, investors uint64) (user *storageModel.User, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, err = b.Storage.UpdateUserInvestors(telegramID, investors)
+		return err
+	})
+
+	return user, err
+}
+
+func (b *Buffered) UpdateUserEarnedCoins(telegramID uint64, earnedCoins amount.Amount) (user *storageModel.User, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, err = b.Storage.UpdateUserEarnedCoins(telegramID, earnedCoins)
+		return err
+	})
+
+	return user, err
+}
+
+func (b *Buffered) UpdateUserLastSeen(telegramID, lastSeen uint64) (user *storageModel.User, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, err = b.Storage.UpdateUserLastSeen(telegramID, lastSeen)
+		return err
+	})
+
+	return user, err
+}
+
+func (b *Buffered) InsertUserCard(telegramID, cardID, level uint64) (userCard *storageModel.UserCard, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		userCard, err = b.Storage.InsertUserCard(telegramID, cardID, level)
+		return err
+	})
+
+	return userCard, err
+}
+
+func (b *Buffered) UpdateUserCardLevel(telegramID, cardID, level uint64) (userCard *storageModel.UserCard, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		userCard, err = b.Storage.UpdateUserCardLevel(telegramID, cardID, level)
+		return err
+	})
+
+	return userCard, err
+}
+
+func (b *Buffered) UpdateUserCardNextClick(telegramID, cardID, nextClick uint64) (userCard *storageModel.UserCard, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		userCard, err = b.Storage.UpdateUserCardNextClick(telegramID, cardID, nextClick)
+		return err
+	})
+
+	return userCard, err
+}
+
+func (b *Buffered) UpdateUserCardLastClick(telegramID, cardID, lastClick uint64) (userCard *storageModel.UserCard, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		userCard, err = b.Storage.UpdateUserCardLastClick(telegramID, cardID, lastClick)
+		return err
+	})
+
+	return userCard, err
+}
+
+func (b *Buffered) Buy(telegramID, cardID uint64, fn BuyFunc) (user *storageModel.User, userCard *storageModel.UserCard, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, userCard, err = b.Storage.Buy(telegramID, cardID, fn)
+		return err
+	})
+
+	return user, userCard, err
+}
+
+func (b *Buffered) Prestige(telegramID, startCardID uint64, fn PrestigeFunc) (user *storageModel.User, userCards []storageModel.UserCard, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, userCards, err = b.Storage.Prestige(telegramID, startCardID, fn)
+		return err
+	})
+
+	return user, userCards, err
+}
+
+func (b *Buffered) Purchase(purchase *storageModel.UserPurchase) (user *storageModel.User, err error) {
+	err = b.evicted(purchase.TelegramID, func() (err error) {
+		user, err = b.Storage.Purchase(purchase)
+		return err
+	})
+
+	return user, err
+}
+
+func (b *Buffered) ClaimTask(telegramID, taskID, now uint64, fn ClaimFunc) (user *storageModel.User, userTask *storageModel.UserTask, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, userTask, err = b.Storage.ClaimTask(telegramID, taskID, now, fn)
+		return err
+	})
+
+	return user, userTask, err
+}
+
+func (b *Buffered) EarnCoins(telegramID uint64, coins amount.Amount, now uint64) (user *storageModel.User, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, err = b.Storage.EarnCoins(telegramID, coins, now)
+		return err
+	})
+
+	return user, err
+}
+
+// entry returns the entry of the user, creating it if there is none.
+func (b *Buffered) entry(telegramID uint64) *bufferedUser {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.users[telegramID]
+	if !ok {
+		e = &bufferedUser{}
+		b.users[telegramID] = e
+	}
+
+	return e
+}
+
+// lock returns the locked entry of the user, retrying if the entry is removed while waiting for the lock.
+func (b *Buffered) lock(telegramID uint64) *bufferedUser {
+	for {
+		e := b.entry(telegramID)
+		e.mu.Lock()
+
+		if !e.removed {
+			return e
+		}
+
+		e.mu.Unlock()
+	}
+}
+
+// loaded returns the locked entry of the user if the user is loaded, otherwise nil.
+func (b *Buffered) loaded(telegramID uint64) *bufferedUser {
+	b.mu.Lock()
+	e, ok := b.users[telegramID]
+	b.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	e.mu.Lock()
+
+	if e.removed || e.user == nil {
+		e.mu.Unlock()
+
+		return nil
+	}
+
+	return e
+}
+
+// load returns the locked entry of the user, loading the user and the user cards from the storage if needed.
+func (b *Buffered) load(telegramID uint64) (e *bufferedUser, err error) {
+	var (
+		user      *storageModel.User
+		userCards []storageModel.UserCard
+	)
+
+	if e = b.lock(telegramID); e.user != nil {
+		return e, nil
+	}
+
+	if user, err = b.Storage.SelectUser(telegramID); err != nil {
+		e.mu.Unlock()
+
+		return nil, err
+	}
+
+	if userCards, err = b.Storage.SelectUserCards(telegramID); err != nil {
+		e.mu.Unlock()
+
+		return nil, err
+	}
+
+	e.user, e.cards = user, userCards
+	e.byID = make(map[uint64]int, len(userCards))
+
+	for i, userCard := range userCards {
+		e.byID[userCard.CardID] = i
+	}
+
+	return e, nil
+}
+
+// evicted flushes the clicks of the user and runs fn while the user is locked, so the next click
+// loads the user changed by fn from the storage.
+func (b *Buffered) evicted(telegramID uint64, fn func() error) (err error) {
+	b.flushMu.Lock()
+
+	e := b.lock(telegramID)
+	defer e.mu.Unlock()
+
+	if batch := mergeClicks(b.failed[telegramID], e.batch); batch != nil {
+		if err = b.Storage.ApplyClicks([]ClickBatch{*batch}); err != nil {
+			b.failed[telegramID], e.batch = batch, nil
+			b.flushMu.Unlock()
+
+			return err
+		}
+	}
+
+	delete(b.failed, telegramID)
+	e.user, e.cards, e.byID, e.batch, e.bought = nil, nil, nil, nil, nil
+
+	b.flushMu.Unlock()
+
+	return fn()
+}
+
+// remove drops the locked entry of the user, the callers holding it retry with a new one.
+func (b *Buffered) remove(telegramID uint64, e *bufferedUser) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.removed = true
+	delete(b.users, telegramID)
+}
+
+// mergeClicks returns the batch with the clicks of both batches, next is the later one. Either may be nil.
+func mergeClicks(prev, next *ClickBatch) *ClickBatch {
+	switch {
+	case prev == nil:
+		return next
+	case next == nil:
+		return prev
+	}
+
+	res := &ClickBatch{
+		TelegramID: next.TelegramID,
+		Coins:      prev.Coins.Add(next.Coins),
+		Clicks:     prev.Clicks + next.Clicks,
+		LastSeen:   next.LastSeen,
+		Cards:      make(map[uint64]ClickedCard, len(prev.Cards)+len(next.Cards)),
+	}
+
+	for cardID, card := range prev.Cards {
+		res.Cards[cardID] = card
+	}
+
+	for cardID, card := range next.Cards {
+		res.Cards[cardID] = card
+	}
+
+	return res
+}
+
+func (b *Buffered) Enter(telegramID, now uint64, fn OfflineFunc) (user *storageModel.User, userCards []storageModel.UserCard, err error) {
+	err = b.evicted(telegramID, func() (err error) {
+		user, userCards, err = b.Storage.Enter(telegramID, now, fn)
+		return err
+	})
+
+	return user, userCards, err
+}
+
+// Refer evicts both users, so their next clicks load the bonus from the storage.
+func (b *Buffered) Refer(telegramID, referrerID, now uint64, bonus ReferralBonus) (user *storageModel.User, err error) {
+	if err = b.evicted(referrerID, func() error { return nil }); err != nil {
+		return nil, err
+	}
+
+	err = b.evicted(telegramID, func() (err error) {
+		user, err = b.Storage.Refer(telegramID, referrerID, now, bonus)
+		return err
+	})
+
+	return user, err
+}
+
+// SelectReferees takes the lifetime coins of the loaded referees from memory, so they include the buffered clicks.
+// The rest comes from the wrapped storage, the referees loaded during a collection keep the rewards from before it.
+func (b *Buffered) SelectReferees(telegramID uint64) (referees []storageModel.User, err error) {
+	if referees, err = b.Storage.SelectReferees(telegramID); err != nil {
+		return nil, err
+	}
+
+	for i := range referees {
+		if e := b.loaded(referees[i].TelegramID); e != nil {
+			referees[i].LifetimeCoins = e.user.LifetimeCoins
+			e.mu.Unlock()
+		}
+	}
+
+	return referees, nil
+}
+
+// CollectReferralRewards flushes the clicks of the referees first, so the shares include the buffered coins.
+// The clicks the referees make meanwhile are shared by the next collection.
+func (b *Buffered) CollectReferralRewards(telegramID, now uint64, fn ReferralFunc) (user *storageModel.User, referees []storageModel.User, err error) {
+	if referees, err = b.Storage.SelectReferees(telegramID); err != nil {
+		return nil, nil, err
+	}
+
+	for _, referee := range referees {
+		if err = b.evicted(referee.TelegramID, func() error { return nil }); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = b.evicted(telegramID, func() (err error) {
+		user, referees, err = b.Storage.CollectReferralRewards(telegramID, now, fn)
+		return err
+	})
+
+	return user, referees, err
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sqlite "github.com/glebarez/sqlite"
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+type (
+	// failingStorage fails to apply the clicks while fail is set and counts the selects of the purchases.
+	failingStorage struct {
+		Storage
+		fail            bool
+		purchaseSelects int
+	}
+)
+
+func (s *failingStorage) SelectUserPurchases(telegramID, now uint64) ([]storageModel.UserPurchase, error) {
+	s.purchaseSelects++
+
+	return s.Storage.SelectUserPurchases(telegramID, now)
+}
+
+func (s *failingStorage) ApplyClicks(batches []ClickBatch) error {
+	if s.fail {
+		return errors.New("connection refused")
+	}
+
+	return s.Storage.ApplyClicks(batches)
+}
+
+func TestBufferedMemory(t *testing.T) {
+	testStorage(t, func(t *testing.T, cards []storageModel.Card) Storage {
+		return NewBuffered(zap.NewNop(), NewMemory(zap.NewNop(), cards), time.Hour)
+	})
+}
+
+func TestBufferedSQLite(t *testing.T) {
+	testStorage(t, func(t *testing.T, cards []storageModel.Card) Storage {
+		str := newTestDatabase(t, sqlite.Open(sqliteDSN(&config.Storage{Path: filepath.Join(t.TempDir(), "clicker.db")})), cards)
+
+		return NewBuffered(zap.NewNop(), str, time.Hour)
+	})
+}
+
+// newTestBuffered creates the buffer over the memory storage with the user 42 owning both test cards.
+func newTestBuffered(t *testing.T) (*Buffered, *failingStorage) {
+	t.Helper()
+
+	str := &failingStorage{Storage: NewMemory(zap.NewNop(), testCards)}
+
+	_, err := str.InsertUser(42, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	for _, card := range testCards {
+		_, err = str.InsertUserCard(42, card.ID, 1)
+		mustNoError(t, err)
+	}
+
+	return NewBuffered(zap.NewNop(), str, time.Hour), str
+}
+
+// testClickFn earns the coins per click of the card and waits for its click timeout.
+func testClickFn(_ *storageModel.User, card *storageModel.Card, _ *storageModel.UserCard) (amount.Amount, uint64) {
+	return card.CoinsPerClick, card.ClickTimeout
+}
+
+func TestBufferedClicks(t *testing.T) {
+	buf, str := newTestBuffered(t)
+
+	for _, click := range []struct{ cardID, now uint64 }{{1, 100}, {2, 101}, {1, 110}} {
+		_, _, err := buf.Click(42, click.cardID, click.now, false, testClickFn)
+		mustNoError(t, err)
+	}
+
+	if _, _, err := buf.Click(42, 2, 120, false, testClickFn); !errors.Is(err, ErrCantClickNow) {
+		t.Errorf("expected ErrCantClickNow, got %v", err)
+	}
+
+	user, err := buf.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(32), user.Coins)
+	mustEqual(t, "version", 3, user.Version)
+
+	stored, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "stored coins", amount.Zero, stored.Coins)
+
+	mustNoError(t, buf.Flush())
+
+	stored, err = str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "user", *user, *stored)
+
+	for _, expected := range []storageModel.UserCard{{CardID: 1, LastClick: 110, NextClick: 120}, {CardID: 2, LastClick: 101, NextClick: 121}} {
+		userCard, err := str.SelectUserCard(42, expected.CardID)
+		mustNoError(t, err)
+		mustEqual(t, "last_click", expected.LastClick, userCard.LastClick)
+		mustEqual(t, "next_click", expected.NextClick, userCard.NextClick)
+	}
+}
+
+func TestBufferedWriteThrough(t *testing.T) {
+	buf, str := newTestBuffered(t)
+
+	_, _, err := buf.Click(42, 2, 100, false, testClickFn)
+	mustNoError(t, err)
+
+	// the buy sees the buffered coins, the clicks after it see the bought level
+	user, userCard, err := buf.Buy(42, 1, func(user *storageModel.User, card *storageModel.Card, _ *storageModel.UserCard) (uint64, amount.Amount, error) {
+		return 1, user.Coins, nil
+	})
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.Zero, user.Coins)
+	mustEqual(t, "level", 2, userCard.Level)
+
+	user, userCard, err = buf.Click(42, 1, 101, false, testClickFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(1), user.Coins)
+	mustEqual(t, "level", 2, userCard.Level)
+
+	if _, _, err = buf.Click(42, 2, 101, false, testClickFn); !errors.Is(err, ErrCantClickNow) {
+		t.Errorf("expected ErrCantClickNow, got %v", err)
+	}
+
+	mustNoError(t, buf.Flush())
+
+	stored, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(1), stored.Coins)
+	mustEqual(t, "earned_coins", amount.New(31), stored.EarnedCoins)
+	mustEqual(t, "clicks", 2, stored.Clicks)
+	mustEqual(t, "version", 3, stored.Version)
+}
+
+func TestBufferedPurchases(t *testing.T) {
+	buf, str := newTestBuffered(t)
+
+	_, err := buf.Purchase(&storageModel.UserPurchase{TelegramID: 42, ItemID: 1, CoinsMultiplier: 2, CreatedAt: 100, ExpiresAt: 200})
+	mustNoError(t, err)
+
+	_, _, err = buf.Click(42, 1, 100, false, testClickFn)
+	mustNoError(t, err)
+
+	// the purchases of the loaded user are selected once and expire in memory
+	for _, tc := range []struct{ now, active uint64 }{{110, 1}, {150, 1}, {200, 0}} {
+		purchases, err := buf.SelectUserPurchases(42, tc.now)
+		mustNoError(t, err)
+		mustEqual(t, "active purchases", tc.active, uint64(len(purchases)))
+	}
+
+	mustEqual(t, "purchase selects", 1, str.purchaseSelects)
+
+	// the purchase evicts the user, so the next select sees it
+	_, err = buf.Purchase(&storageModel.UserPurchase{TelegramID: 42, ItemID: 3, SkipTimeouts: 1, CreatedAt: 210})
+	mustNoError(t, err)
+
+	_, _, err = buf.Click(42, 1, 210, false, testClickFn)
+	mustNoError(t, err)
+
+	purchases, err := buf.SelectUserPurchases(42, 220)
+	mustNoError(t, err)
+	mustEqual(t, "active purchases", 1, len(purchases))
+	mustEqual(t, "purchase selects", 2, str.purchaseSelects)
+}
+
+func TestBufferedReferrals(t *testing.T) {
+	buf, str := newTestBuffered(t)
+
+	// 43 invited 42, 44 is a bystander
+	for _, telegramID := range []uint64{43, 44} {
+		_, err := str.InsertUser(telegramID, amount.Zero, 0, 0)
+		mustNoError(t, err)
+
+		_, err = str.InsertUserCard(telegramID, 1, 1)
+		mustNoError(t, err)
+	}
+
+	_, err := buf.Refer(42, 43, 100, ReferralBonus{})
+	mustNoError(t, err)
+
+	for _, telegramID := range []uint64{42, 44} {
+		_, _, err = buf.Click(telegramID, 1, 100, false, testClickFn)
+		mustNoError(t, err)
+	}
+
+	// the listing sees the buffered coins of the referee and changes nothing
+	referees, err := buf.SelectReferees(43)
+	mustNoError(t, err)
+	mustEqual(t, "referees", 1, len(referees))
+	mustEqual(t, "lifetime_coins", amount.New(1), referees[0].LifetimeCoins)
+
+	stored, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "stored lifetime_coins", amount.Zero, stored.LifetimeCoins)
+
+	// the collection flushes the referee only
+	user, referees, err := buf.CollectReferralRewards(43, 200, func(_ *storageModel.User, coins amount.Amount) amount.Amount { return coins })
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(1), user.Coins)
+	mustEqual(t, "referral_coins", amount.New(1), referees[0].ReferralCoins)
+
+	stored, err = str.SelectUser(44)
+	mustNoError(t, err)
+	mustEqual(t, "bystander coins", amount.Zero, stored.Coins)
+}
+
+func TestBufferedFailedFlush(t *testing.T) {
+	buf, str := newTestBuffered(t)
+
+	_, _, err := buf.Click(42, 1, 100, false, testClickFn)
+	mustNoError(t, err)
+
+	str.fail = true
+
+	if err = buf.Flush(); err == nil {
+		t.Errorf("expected flush error")
+	}
+
+	_, _, err = buf.Click(42, 2, 100, false, testClickFn)
+	mustNoError(t, err)
+
+	if _, err = buf.UpdateUserGold(42, 10); err == nil {
+		t.Errorf("expected flush error")
+	}
+
+	user, err := buf.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(31), user.Coins)
+	mustEqual(t, "gold", 0, user.Gold)
+
+	str.fail = false
+
+	mustNoError(t, buf.Flush())
+
+	stored, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(31), stored.Coins)
+	mustEqual(t, "clicks", 2, stored.Clicks)
+
+	// the idle user is forgotten after two flushes, the next read goes to the storage
+	mustNoError(t, buf.Flush())
+	mustEqual(t, "users", 0, len(buf.users))
+}
+
+func TestBufferedFlushOnExit(t *testing.T) {
+	var (
+		buf, str    = newTestBuffered(t)
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error)
+	)
+
+	go func() { done <- buf.Run(ctx) }()
+
+	for now := uint64(100); now < 200; now += 10 {
+		_, _, err := buf.Click(42, 1, now, false, testClickFn)
+		mustNoError(t, err)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		mustNoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Run to return")
+	}
+
+	stored, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(10), stored.Coins)
+	mustEqual(t, "last_seen", 190, stored.LastSeen)
+}
+
+func TestBufferedClose(t *testing.T) {
+	buf, str := newTestBuffered(t)
+
+	_, _, err := buf.Click(42, 2, 100, false, testClickFn)
+	mustNoError(t, err)
+
+	mustNoError(t, buf.Close())
+
+	stored, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(30), stored.Coins)
+}
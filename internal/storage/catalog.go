@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+type (
+	// Catalog caches the cards which aren't retired. The cards are loaded on the first use
+	// and served from memory until Invalidate is called, so whoever rewrites the cards table
+	// must invalidate the catalog. Callers get deep copies and can't change the cached cards.
+	Catalog struct {
+		mu       sync.Mutex
+		load     func() ([]storageModel.Card, error)
+		snapshot atomic.Pointer[catalogSnapshot]
+	}
+
+	// catalogSnapshot is the immutable set of the loaded cards, the catalog swaps it as a whole.
+	catalogSnapshot struct {
+		cards []storageModel.Card
+		byID  map[uint64]int
+	}
+)
+
+// NewCatalog creates the catalog loading the cards, ordered by id, with load.
+func NewCatalog(load func() ([]storageModel.Card, error)) *Catalog {
+	return &Catalog{load: load}
+}
+
+// Cards returns the cards ordered by id.
+func (c *Catalog) Cards() (_ []storageModel.Card, err error) {
+	snap, err := c.ensure()
+	if err != nil {
+		return nil, err
+	}
+
+	cards := make([]storageModel.Card, len(snap.cards))
+
+	for i := range snap.cards {
+		cards[i] = copyCard(&snap.cards[i])
+	}
+
+	return cards, nil
+}
+
+// Card returns the card by id or ErrRecordNotFound.
+func (c *Catalog) Card(cardID uint64) (_ *storageModel.Card, err error) {
+	snap, err := c.ensure()
+	if err != nil {
+		return nil, err
+	}
+
+	i, ok := snap.byID[cardID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	card := copyCard(&snap.cards[i])
+
+	return &card, nil
+}
+
+// Invalidate drops the cached cards, the next call loads them again. It waits for the load in progress,
+// so the cards loaded before the call are never cached after it.
+func (c *Catalog) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.snapshot.Store(nil)
+}
+
+// ensure returns the cached cards, loading them if they aren't cached.
+func (c *Catalog) ensure() (_ *catalogSnapshot, err error) {
+	if snap := c.snapshot.Load(); snap != nil {
+		return snap, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if snap := c.snapshot.Load(); snap != nil {
+		return snap, nil
+	}
+
+	var cards []storageModel.Card
+
+	if cards, err = c.load(); err != nil {
+		return nil, err
+	}
+
+	snap := &catalogSnapshot{cards: make([]storageModel.Card, len(cards)), byID: make(map[uint64]int, len(cards))}
+
+	for i := range cards {
+		snap.cards[i] = copyCard(&cards[i])
+		snap.byID[cards[i].ID] = i
+	}
+
+	c.snapshot.Store(snap)
+
+	return snap, nil
+}
+
+// copyCard returns the copy of the card which doesn't share the formulas with it.
+func copyCard(card *storageModel.Card) storageModel.Card {
+	res := *card
+	res.CoinsFormula = copyFormula(card.CoinsFormula)
+	res.PriceFormula = copyFormula(card.PriceFormula)
+
+	return res
+}
+
+// copyFormula returns the deep copy of the formula, nil for nil.
+func copyFormula(formula *storageModel.Formula) *storageModel.Formula {
+	if formula == nil {
+		return nil
+	}
+
+	res := *formula
+	res.Pieces = slices.Clone(formula.Pieces)
+
+	for i := range res.Pieces {
+		res.Pieces[i].Pieces = copyFormula(&formula.Pieces[i].Formula).Pieces
+	}
+
+	return &res
+}
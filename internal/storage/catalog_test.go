@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	sqlite "github.com/glebarez/sqlite"
+	zap "go.uber.org/zap"
+	gorm "gorm.io/gorm"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+func TestCatalog(t *testing.T) {
+	var (
+		loads int
+		cards = testCards
+		ctl   = NewCatalog(func() ([]storageModel.Card, error) {
+			loads++
+
+			return append([]storageModel.Card(nil), cards...), nil
+		})
+	)
+
+	for i := 0; i < 3; i++ {
+		res, err := ctl.Cards()
+		mustNoError(t, err)
+		mustEqual(t, "cards", len(testCards), len(res))
+
+		card, err := ctl.Card(2)
+		mustNoError(t, err)
+		mustEqual(t, "card", testCards[1], *card)
+	}
+
+	mustEqual(t, "loads", 1, loads)
+
+	// the cached cards can't be changed through the results
+	res, _ := ctl.Cards()
+	res[0].Price = amount.New(1000)
+
+	card, _ := ctl.Card(1)
+	card.Price = amount.New(1000)
+
+	card, _ = ctl.Card(1)
+	mustEqual(t, "price", testCards[0].Price, card.Price)
+
+	if _, err := ctl.Card(3); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	cards = testCards[:1]
+	ctl.Invalidate()
+
+	res, err := ctl.Cards()
+	mustNoError(t, err)
+	mustEqual(t, "cards", 1, len(res))
+	mustEqual(t, "loads", 2, loads)
+
+	if _, err = ctl.Card(2); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestCatalogFormulas(t *testing.T) {
+	var (
+		formula = &storageModel.Formula{Type: "piecewise", Pieces: []storageModel.FormulaPiece{
+			{FromLevel: 1, Formula: storageModel.Formula{Type: "linear"}},
+			{FromLevel: 10, Formula: storageModel.Formula{Type: "geometric", Multiplier: 1.2}},
+		}}
+		cards = []storageModel.Card{{ID: 1, CoinsFormula: &storageModel.Formula{Type: "linear"}, PriceFormula: formula}}
+		ctl   = NewCatalog(func() ([]storageModel.Card, error) { return cards, nil })
+	)
+
+	card, err := ctl.Card(1)
+	mustNoError(t, err)
+
+	// neither the loaded cards nor the results share the formulas with the cached cards
+	card.CoinsFormula.Type = "geometric"
+	card.PriceFormula.Pieces[1].Multiplier = 2
+	formula.Pieces[0].Type = "polynomial"
+
+	res, err := ctl.Cards()
+	mustNoError(t, err)
+	mustEqual(t, "coins formula", "linear", res[0].CoinsFormula.Type)
+	mustEqual(t, "multiplier", 1.2, res[0].PriceFormula.Pieces[1].Multiplier)
+	mustEqual(t, "piece", "linear", res[0].PriceFormula.Pieces[0].Type)
+}
+
+func TestCatalogInvalidateRace(t *testing.T) {
+	var (
+		wg  sync.WaitGroup
+		ctl = NewCatalog(func() ([]storageModel.Card, error) { return testCards, nil })
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				ctl.Invalidate()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				if _, err := ctl.Card(1); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestCatalogLoadError(t *testing.T) {
+	var (
+		errLoad = errors.New("connection refused")
+		fail    = true
+		ctl     = NewCatalog(func() ([]storageModel.Card, error) {
+			if fail {
+				return nil, errLoad
+			}
+
+			return testCards, nil
+		})
+	)
+
+	if _, err := ctl.Cards(); !errors.Is(err, errLoad) {
+		t.Errorf("expected load error, got %v", err)
+	}
+
+	// the failed load isn't cached
+	fail = false
+
+	res, err := ctl.Cards()
+	mustNoError(t, err)
+	mustEqual(t, "cards", len(testCards), len(res))
+}
+
+// BenchmarkSQLiteClick runs the storage calls of the click request and reports the queries per click:
+// "cached" serves the cards from the catalog, "uncached" invalidates it before every read of the cards
+// and hits the cards table as often as the storage did before the catalog.
+func BenchmarkSQLiteClick(b *testing.B) {
+	for _, uncached := range []bool{false, true} {
+		name := "cached"
+		if uncached {
+			name = "uncached"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			var (
+				queries atomic.Int64
+				str     = newBenchDatabase(b, &queries)
+				clickFn = func(_ *storageModel.User, _ *storageModel.Card, _ *storageModel.UserCard) (amount.Amount, uint64) {
+					return amount.New(1), 0
+				}
+			)
+
+			_, err := str.InsertUser(42, amount.Zero, 0, 0)
+			mustNoError(b, err)
+
+			_, err = str.InsertUserCard(42, 1, 1)
+			mustNoError(b, err)
+
+			queries.Store(0)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if uncached {
+					str.InvalidateCards()
+				}
+
+				if _, _, err = str.Click(42, 1, uint64(i), false, clickFn); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+
+				if uncached {
+					str.InvalidateCards()
+				}
+
+				if _, err = str.SelectCards(); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			b.ReportMetric(float64(queries.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
+
+// newBenchDatabase creates the sqlite database with the test cards counting every query it runs.
+func newBenchDatabase(b *testing.B, queries *atomic.Int64) *Database {
+	b.Helper()
+
+	str, err := NewDatabase(zap.NewNop(), sqlite.Open(sqliteDSN(&config.Storage{Path: filepath.Join(b.TempDir(), "clicker.db")})), &config.Storage{})
+	mustNoError(b, err)
+
+	count := func(*gorm.DB) { queries.Add(1) }
+
+	for _, err = range []error{
+		str.str.Callback().Query().After("gorm:query").Register("bench:count_query", count),
+		str.str.Callback().Create().After("gorm:create").Register("bench:count_create", count),
+		str.str.Callback().Update().After("gorm:update").Register("bench:count_update", count),
+		str.str.Callback().Row().After("gorm:row").Register("bench:count_row", count),
+	} {
+		mustNoError(b, err)
+	}
+
+	mustNoError(b, str.fillCards(testCards))
+
+	return str
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	zap "go.uber.org/zap"
+	gorm "gorm.io/gorm"
+	logger "gorm.io/gorm/logger"
+
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+type (
+	Database struct {
+		str *gorm.DB
+		lgr *zap.Logger
+		cfg *config.Storage
+		ctl *Catalog
+	}
+
+	// CardsSync is the summary of the card catalog synchronization: ids of the inserted, updated and retired cards.
+	CardsSync struct {
+		Inserted []uint64
+		Updated  []uint64
+		Retired  []uint64
+	}
+)
+
+var (
+	migrate = []interface{}{
+		storageModel.User{},
+		storageModel.UserCard{},
+		storageModel.Card{},
+		storageModel.UserPurchase{},
+		storageModel.UserTask{},
+	}
+)
+
+// NewDatabase connects to the database through the given dialector and migrates the schema.
+func NewDatabase(lgr *zap.Logger, dialector gorm.Dialector, cfg *config.Storage) (_ *Database, err error) {
+	var str *gorm.DB
+
+	// the translated errors make the duplicates ErrDuplicatedKey in every database, like in the memory storage
+	if str, err = gorm.Open(dialector, &gorm.Config{
+		TranslateError: true,
+		Logger: logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
+			SlowThreshold:             time.Second,
+			LogLevel:                  logger.Silent,
+			IgnoreRecordNotFoundError: true,
+			ParameterizedQueries:      true,
+		}),
+	}); err != nil {
+		return nil, err
+	}
+
+	// sqlite allows only one writer at a time, so transactions are serialized
+	// through a single connection instead of failing with "database is locked"
+	if dialector.Name() == DriverSQLite {
+		var db *sql.DB
+
+		if db, err = str.DB(); err != nil {
+			return nil, err
+		}
+
+		db.SetMaxOpenConns(1)
+	}
+
+	if err = str.AutoMigrate(migrate...); err != nil {
+		return nil, err
+	}
+
+	s := &Database{
+		str: str,
+		lgr: lgr,
+		cfg: cfg,
+	}
+
+	s.ctl = NewCatalog(s.loadCards)
+
+	return s, nil
+}
+
+// sqliteDSN builds the sqlite connection string from the config.
+func sqliteDSN(cfg *config.Storage) string {
+	return cfg.Path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+
+// postgresDSN builds the postgres connection string from the config.
+func postgresDSN(cfg *config.Storage) string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
+		cfg.Host,
+		cfg.DBUser,
+		cfg.DBPass,
+		cfg.DBName,
+		cfg.Port,
+	)
+}
+
+// SyncCardsFromFile synchronizes the cards table with the card catalog from the json file.
+func (s *Database) SyncCardsFromFile(path string, retire bool) (err error) {
+	var cards []storageModel.Card
+
+	if cards, err = ReadCardsFile(path); err != nil {
+		return err
+	}
+
+	_, err = s.SyncCards(cards, retire)
+
+	return err
+}
+
+// SyncCards atomically makes the cards table match the catalog: inserts new cards and updates changed ones.
+// Cards missing in the catalog are retired if retire is set, otherwise they are kept as is.
+// Retired cards come back when they appear in the catalog again.
+func (s *Database) SyncCards(cards []storageModel.Card, retire bool) (sync *CardsSync, err error) {
+	if err = ValidateCards(cards); err != nil {
+		return nil, err
+	}
+
+	sync = &CardsSync{}
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		var (
+			stored    []storageModel.Card
+			storedMap = make(map[uint64]storageModel.Card)
+			catalog   = make(map[uint64]bool, len(cards))
+		)
+
+		if res := forUpdate(tx).Table("cards").Find(&stored); res.Error != nil {
+			return res.Error
+		}
+
+		for _, card := range stored {
+			storedMap[card.ID] = card
+		}
+
+		for _, card := range cards {
+			catalog[card.ID] = true
+			card.Retired = false
+
+			current, ok := storedMap[card.ID]
+
+			switch {
+			case !ok:
+				if res := tx.Table("cards").Create(&card); res.Error != nil {
+					return res.Error
+				}
+
+				sync.Inserted = append(sync.Inserted, card.ID)
+			case !reflect.DeepEqual(current, card):
+				if res := tx.Table("cards").Save(&card); res.Error != nil {
+					return res.Error
+				}
+
+				sync.Updated = append(sync.Updated, card.ID)
+			}
+		}
+
+		for _, card := range stored {
+			if !retire || card.Retired || catalog[card.ID] {
+				continue
+			}
+
+			if res := tx.Table("cards").Where("id = ?", card.ID).Update("retired", true); res.Error != nil {
+				return res.Error
+			}
+
+			sync.Retired = append(sync.Retired, card.ID)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.InvalidateCards()
+
+	s.lgr.Info("cards synchronized",
+		zap.Uint64s("inserted", sync.Inserted),
+		zap.Uint64s("updated", sync.Updated),
+		zap.Uint64s("retired", sync.Retired),
+	)
+
+	return sync, nil
+}
+
+// InvalidateCards drops the cached card catalog. It must be called after the cards table is rewritten.
+func (s *Database) InvalidateCards() {
+	s.lgr.Debug("invalidating cards cache")
+
+	s.ctl.Invalidate()
+}
+
+// Close closes the connection pool of the database.
+func (s *Database) Close() (err error) {
+	var db *sql.DB
+
+	if db, err = s.str.DB(); err != nil {
+		return err
+	}
+
+	return db.Close()
+}
@@ -0,0 +1,734 @@
+package storage
+
+import (
+	"errors"
+
+	zap "go.uber.org/zap"
+	gorm "gorm.io/gorm"
+	clause "gorm.io/gorm/clause"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+// secondsPerDay is the length of the days the daily earnings are counted for.
+const secondsPerDay = 86400
+
+var (
+	ErrCantClickNow    = errors.New("you can't click now")
+	ErrCardNotBought   = errors.New("card is not bought")
+	ErrNotEnoughCoins  = errors.New("not enough coins")
+	ErrMaxLevelReached = errors.New("max level reached")
+	ErrNotEnoughGold   = errors.New("not enough gold")
+	ErrTaskNotDone     = errors.New("task is not done")
+	ErrTaskClaimed     = errors.New("task is already claimed")
+	ErrCantRefer       = errors.New("user can't be referred")
+)
+
+type (
+	// ClickTimeoutError is ErrCantClickNow with the time the card can be clicked again.
+	ClickTimeoutError struct {
+		Now       uint64
+		NextClick uint64
+	}
+
+	// NotEnoughCoinsError is ErrNotEnoughCoins with the price the user can't afford.
+	NotEnoughCoinsError struct {
+		Price amount.Amount
+		Coins amount.Amount
+	}
+
+	// NotEnoughGoldError is ErrNotEnoughGold with the price the user can't afford.
+	NotEnoughGoldError struct {
+		Price uint64
+		Gold  uint64
+	}
+
+	// ClickFunc returns the number of coins the user earns by clicking the card
+	// and the timeout in seconds before the card can be clicked again.
+	ClickFunc func(user *storageModel.User, card *storageModel.Card, userCard *storageModel.UserCard) (coins amount.Amount, timeout uint64)
+
+	// ClickBatch is the sum of the clicks of the user buffered in memory. Coins are added to the coins
+	// and the earned coins, every click adds one to the clicks and to the version of the user.
+	ClickBatch struct {
+		TelegramID uint64
+		Coins      amount.Amount
+		Clicks     uint64
+		LastSeen   uint64
+		Cards      map[uint64]ClickedCard
+	}
+
+	// ClickedCard is the timers of the card after the last buffered click.
+	ClickedCard struct {
+		LastClick uint64
+		NextClick uint64
+	}
+
+	// BuyFunc returns the number of levels of the card to buy and their total price.
+	// Returning an error cancels the purchase.
+	BuyFunc func(user *storageModel.User, card *storageModel.Card, userCard *storageModel.UserCard) (levels uint64, price amount.Amount, err error)
+
+	// PrestigeFunc returns the number of investors the user has after the reset and the progress of the tasks
+	// by task id, both calculated from the state the reset drops.
+	PrestigeFunc func(user *storageModel.User, userCards []storageModel.UserCard) (investors uint64, progress map[uint64]uint64)
+
+	// ClaimFunc returns the reward the user gets for the task.
+	// Returning an error cancels the claim.
+	ClaimFunc func(user *storageModel.User, userTask *storageModel.UserTask) (coins amount.Amount, gold uint64, err error)
+
+	// OfflineFunc returns the number of coins the user earned while offline, since the last seen time of the user.
+	OfflineFunc func(user *storageModel.User, userCards []storageModel.UserCard) (coins amount.Amount)
+
+	// ReferralBonus is the coins and the gold the referrer and the referee get once for the referral.
+	ReferralBonus struct {
+		ReferrerCoins amount.Amount
+		ReferrerGold  uint64
+		RefereeCoins  amount.Amount
+		RefereeGold   uint64
+	}
+
+	// ReferralFunc returns the share of the referrer in the coins the referee earned since the last collection.
+	ReferralFunc func(referee *storageModel.User, coins amount.Amount) (share amount.Amount)
+)
+
+func (e *ClickTimeoutError) Error() string { return ErrCantClickNow.Error() }
+func (e *ClickTimeoutError) Unwrap() error { return ErrCantClickNow }
+
+func (e *NotEnoughCoinsError) Error() string { return ErrNotEnoughCoins.Error() }
+func (e *NotEnoughCoinsError) Unwrap() error { return ErrNotEnoughCoins }
+
+func (e *NotEnoughGoldError) Error() string { return ErrNotEnoughGold.Error() }
+func (e *NotEnoughGoldError) Unwrap() error { return ErrNotEnoughGold }
+
+// earn adds the coins earned at now to the earned coins of the user: of the run, of all the runs and of the day.
+// The daily coins start over on the first earnings of the next day.
+func earn(user *storageModel.User, coins amount.Amount, now uint64) {
+	if day := now / secondsPerDay; day > user.DailyCoinsDay {
+		user.DailyCoins, user.DailyCoinsDay = amount.Zero, day
+	}
+
+	user.EarnedCoins = user.EarnedCoins.Add(coins)
+	user.LifetimeCoins = user.LifetimeCoins.Add(coins)
+	user.DailyCoins = user.DailyCoins.Add(coins)
+}
+
+// canRefer reports whether the user can be referred by the referrer: once, not by the user itself
+// and not by the own referee.
+func canRefer(user, referrer *storageModel.User) bool {
+	return user.ReferredBy == 0 && user.TelegramID != referrer.TelegramID && referrer.ReferredBy != user.TelegramID
+}
+
+// earned adds the earned coins of the user to the values of the users update.
+func earned(user *storageModel.User, values map[string]interface{}) map[string]interface{} {
+	values["earned_coins"] = user.EarnedCoins
+	values["lifetime_coins"] = user.LifetimeCoins
+	values["daily_coins"] = user.DailyCoins
+	values["daily_coins_day"] = user.DailyCoinsDay
+
+	return values
+}
+
+// versioned adds the bump of the user state version to the values of the users update.
+func versioned(values map[string]interface{}) map[string]interface{} {
+	values["version"] = gorm.Expr("version + 1")
+
+	return values
+}
+
+// forUpdate locks the selected rows until the end of the transaction.
+func forUpdate(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+}
+
+// Click atomically clicks the card: checks that the card is bought and the click timeout, adds the coins calculated by fn
+// and moves the card timers by the timeout calculated by fn. If skipTimeout is set, a timeout skip
+// of the user purchases is spent to click the card before the timeout ends.
+// Returns the final state of the user and the user card.
+func (s *Database) Click(telegramID, cardID, now uint64, skipTimeout bool, fn ClickFunc) (user *storageModel.User, userCard *storageModel.UserCard, err error) {
+	s.lgr.Debug("clicking card",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("card_id", cardID),
+	)
+
+	// the catalog is read before the transaction takes the connection, sqlite has only one
+	card, err := s.ctl.Card(cardID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		if res := forUpdate(tx).Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		if res := forUpdate(tx).Table("user_cards").Where("telegram_id = ? AND card_id = ?", telegramID, cardID).First(&userCard); res.Error != nil {
+			return res.Error
+		}
+
+		// the prestige keeps the cards on the level 0, their clicks would only farm the clicks count
+		if userCard.Level == 0 {
+			return ErrCardNotBought
+		}
+
+		if now < userCard.NextClick {
+			if !skipTimeout {
+				return &ClickTimeoutError{Now: now, NextClick: userCard.NextClick}
+			}
+
+			var purchase *storageModel.UserPurchase
+
+			if res := forUpdate(tx).Table("user_purchases").Where("telegram_id = ? AND skip_timeouts > 0", telegramID).Order("id").First(&purchase); res.Error != nil {
+				if errors.Is(res.Error, ErrRecordNotFound) {
+					return &ClickTimeoutError{Now: now, NextClick: userCard.NextClick}
+				}
+
+				return res.Error
+			}
+
+			if res := tx.Table("user_purchases").Where("id = ?", purchase.ID).Update("skip_timeouts", purchase.SkipTimeouts-1); res.Error != nil {
+				return res.Error
+			}
+		}
+
+		coins, timeout := fn(user, card, userCard)
+
+		earn(user, coins, now)
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(earned(user, map[string]interface{}{
+			"coins":     user.Coins.Add(coins),
+			"clicks":    user.Clicks + 1,
+			"last_seen": now,
+		}))); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.Table("user_cards").Where("telegram_id = ? AND card_id = ?", telegramID, cardID).Updates(map[string]interface{}{
+			"last_click": now,
+			"next_click": now + timeout,
+		}); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("user_cards").Where("telegram_id = ? AND card_id = ?", telegramID, cardID).First(&userCard).Error
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, userCard, nil
+}
+
+// ApplyClicks adds the buffered clicks to the users and moves the card timers in one transaction.
+// Users and cards which don't exist anymore are skipped. The amounts can't be added in sql,
+// sqlite stores them as text, so the users are locked and updated with the sums.
+func (s *Database) ApplyClicks(batches []ClickBatch) (err error) {
+	s.lgr.Debug("applying clicks", zap.Int("batches", len(batches)))
+
+	return s.str.Transaction(func(tx *gorm.DB) (err error) {
+		for _, batch := range batches {
+			var user *storageModel.User
+
+			if res := forUpdate(tx).Table("users").Where("telegram_id = ?", batch.TelegramID).First(&user); res.Error != nil {
+				if errors.Is(res.Error, ErrRecordNotFound) {
+					continue
+				}
+
+				return res.Error
+			}
+
+			earn(user, batch.Coins, batch.LastSeen)
+
+			if res := tx.Table("users").Where("telegram_id = ?", batch.TelegramID).Updates(earned(user, map[string]interface{}{
+				"coins":     user.Coins.Add(batch.Coins),
+				"clicks":    gorm.Expr("clicks + ?", batch.Clicks),
+				"version":   gorm.Expr("version + ?", batch.Clicks),
+				"last_seen": batch.LastSeen,
+			})); res.Error != nil {
+				return res.Error
+			}
+
+			for cardID, card := range batch.Cards {
+				if res := tx.Table("user_cards").Where("telegram_id = ? AND card_id = ?", batch.TelegramID, cardID).Updates(map[string]interface{}{
+					"last_click": card.LastClick,
+					"next_click": card.NextClick,
+				}); res.Error != nil {
+					return res.Error
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// Buy atomically buys the levels of the card calculated by fn.
+// If the user doesn't have the card yet, it is created.
+// Returns the final state of the user and the user card.
+func (s *Database) Buy(telegramID, cardID uint64, fn BuyFunc) (user *storageModel.User, userCard *storageModel.UserCard, err error) {
+	s.lgr.Debug("buying card",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("card_id", cardID),
+	)
+
+	// the catalog is read before the transaction takes the connection, sqlite has only one
+	card, err := s.ctl.Card(cardID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		exists := true
+
+		if res := forUpdate(tx).Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		if res := forUpdate(tx).Table("user_cards").Where("telegram_id = ? AND card_id = ?", telegramID, cardID).First(&userCard); res.Error != nil {
+			if !errors.Is(res.Error, ErrRecordNotFound) {
+				return res.Error
+			}
+
+			exists = false
+			userCard = &storageModel.UserCard{TelegramID: telegramID, CardID: cardID}
+		}
+
+		levels, price, err := fn(user, card, userCard)
+		if err != nil {
+			return err
+		}
+
+		if user.Coins.Cmp(price) < 0 {
+			return &NotEnoughCoinsError{Price: price, Coins: user.Coins}
+		}
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(map[string]interface{}{
+			"coins": user.Coins.Sub(price),
+		})); res.Error != nil {
+			return res.Error
+		}
+
+		if exists {
+			if res := tx.Table("user_cards").Where("telegram_id = ? AND card_id = ?", telegramID, cardID).Update("level", userCard.Level+levels); res.Error != nil {
+				return res.Error
+			}
+		} else {
+			userCard.Level = levels
+
+			if res := tx.Table("user_cards").Create(userCard); res.Error != nil {
+				return res.Error
+			}
+		}
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("user_cards").Where("telegram_id = ? AND card_id = ?", telegramID, cardID).First(&userCard).Error
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, userCard, nil
+}
+
+// Prestige atomically resets the game progress of the user: raises the progress of the tasks and sets
+// the investors calculated by fn, resets coins and all cards, and gives the start card back with level 1.
+// Returns the final state of the user and the user cards.
+func (s *Database) Prestige(telegramID, startCardID uint64, fn PrestigeFunc) (user *storageModel.User, userCards []storageModel.UserCard, err error) {
+	s.lgr.Debug("resetting game", zap.Uint64("telegram_id", telegramID))
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		if res := forUpdate(tx).Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		if res := forUpdate(tx).Table("user_cards").Where("telegram_id = ?", telegramID).Find(&userCards); res.Error != nil {
+			return res.Error
+		}
+
+		investors, progress := fn(user, userCards)
+
+		for taskID, taskProgress := range progress {
+			if _, err = raiseTaskProgress(tx, telegramID, taskID, taskProgress); err != nil {
+				return err
+			}
+		}
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(map[string]interface{}{
+			"investors":    investors,
+			"prestiges":    user.Prestiges + 1,
+			"coins":        amount.Zero,
+			"earned_coins": amount.Zero,
+		})); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.Table("user_cards").Where("telegram_id = ?", telegramID).Updates(map[string]interface{}{
+			"level":      0,
+			"last_click": 0,
+			"next_click": 0,
+		}); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.Table("user_cards").Where("telegram_id = ? AND card_id = ?", telegramID, startCardID).Update("level", 1); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("user_cards").Where("telegram_id = ?", telegramID).Find(&userCards).Error
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, userCards, nil
+}
+
+// Purchase atomically spends the gold for the purchase and applies its instant effects:
+// adds the coins and refreshes the click timeouts of all user cards.
+// Returns the final state of the user.
+func (s *Database) Purchase(purchase *storageModel.UserPurchase) (user *storageModel.User, err error) {
+	s.lgr.Debug("purchasing item",
+		zap.Uint64("telegram_id", purchase.TelegramID),
+		zap.Uint64("item_id", purchase.ItemID),
+	)
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		if res := forUpdate(tx).Table("users").Where("telegram_id = ?", purchase.TelegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		if user.Gold < purchase.Price {
+			return &NotEnoughGoldError{Price: purchase.Price, Gold: user.Gold}
+		}
+
+		if res := tx.Table("users").Where("telegram_id = ?", purchase.TelegramID).Updates(versioned(map[string]interface{}{
+			"gold":  user.Gold - purchase.Price,
+			"coins": user.Coins.Add(purchase.Coins),
+		})); res.Error != nil {
+			return res.Error
+		}
+
+		if purchase.RefreshClicks {
+			if res := tx.Table("user_cards").Where("telegram_id = ?", purchase.TelegramID).Update("next_click", 0); res.Error != nil {
+				return res.Error
+			}
+		}
+
+		if res := tx.Table("user_purchases").Create(purchase); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("users").Where("telegram_id = ?", purchase.TelegramID).First(&user).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// UpdateUserTaskProgress atomically raises the progress of the user task. The progress never goes down,
+// so reached goals stay reached after the reset. If the user doesn't have the task yet, it is created.
+// Returns the final state of the user task.
+func (s *Database) UpdateUserTaskProgress(telegramID, taskID, progress uint64) (userTask *storageModel.UserTask, err error) {
+	s.lgr.Debug("updating user task progress",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("task_id", taskID),
+		zap.Uint64("progress", progress),
+	)
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		userTask, err = raiseTaskProgress(tx, telegramID, taskID, progress)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return userTask, nil
+}
+
+// raiseTaskProgress raises the progress of the user task in the transaction, creating the task if needed.
+func raiseTaskProgress(tx *gorm.DB, telegramID, taskID, progress uint64) (userTask *storageModel.UserTask, err error) {
+	if res := forUpdate(tx).Table("user_tasks").Where("telegram_id = ? AND task_id = ?", telegramID, taskID).First(&userTask); res.Error != nil {
+		if !errors.Is(res.Error, ErrRecordNotFound) {
+			return nil, res.Error
+		}
+
+		userTask = &storageModel.UserTask{TelegramID: telegramID, TaskID: taskID, Progress: progress}
+
+		return userTask, tx.Table("user_tasks").Create(userTask).Error
+	}
+
+	if userTask.Progress >= progress {
+		return userTask, nil
+	}
+
+	userTask.Progress = progress
+
+	return userTask, tx.Table("user_tasks").Where("id = ?", userTask.ID).Update("progress", progress).Error
+}
+
+// ClaimTask atomically gives the user the reward for the task calculated by fn and marks the task as claimed.
+// The coins of the reward are earned at now. Returns the final state of the user and the user task.
+func (s *Database) ClaimTask(telegramID, taskID, now uint64, fn ClaimFunc) (user *storageModel.User, userTask *storageModel.UserTask, err error) {
+	s.lgr.Debug("claiming task",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("task_id", taskID),
+	)
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		if res := forUpdate(tx).Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		if res := forUpdate(tx).Table("user_tasks").Where("telegram_id = ? AND task_id = ?", telegramID, taskID).First(&userTask); res.Error != nil {
+			if !errors.Is(res.Error, ErrRecordNotFound) {
+				return res.Error
+			}
+
+			userTask = &storageModel.UserTask{TelegramID: telegramID, TaskID: taskID}
+		}
+
+		if userTask.Claimed {
+			return ErrTaskClaimed
+		}
+
+		coins, gold, err := fn(user, userTask)
+		if err != nil {
+			return err
+		}
+
+		earn(user, coins, now)
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(earned(user, map[string]interface{}{
+			"coins": user.Coins.Add(coins),
+			"gold":  user.Gold + gold,
+		}))); res.Error != nil {
+			return res.Error
+		}
+
+		userTask.Claimed = true
+
+		if res := tx.Table("user_tasks").Save(userTask); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("users").Where("telegram_id = ?", telegramID).First(&user).Error
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, userTask, nil
+}
+
+// EarnCoins atomically adds the coins earned at now outside of the clicks to the coins and the earned coins
+// of the user. Returns the final state of the user.
+func (s *Database) EarnCoins(telegramID uint64, coins amount.Amount, now uint64) (user *storageModel.User, err error) {
+	s.lgr.Debug("earning coins",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Stringer("coins", coins),
+	)
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		if res := forUpdate(tx).Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		earn(user, coins, now)
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(earned(user, map[string]interface{}{
+			"coins": user.Coins.Add(coins),
+		}))); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("users").Where("telegram_id = ?", telegramID).First(&user).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Enter atomically gives the user the coins earned while offline calculated by fn and moves the last seen
+// time of the user to now, so the concurrent enters never count the same offline time twice.
+// Returns the final state of the user and the user cards.
+func (s *Database) Enter(telegramID, now uint64, fn OfflineFunc) (user *storageModel.User, userCards []storageModel.UserCard, err error) {
+	s.lgr.Debug("entering game", zap.Uint64("telegram_id", telegramID))
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		if res := forUpdate(tx).Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.Table("user_cards").Where("telegram_id = ?", telegramID).Find(&userCards); res.Error != nil {
+			return res.Error
+		}
+
+		coins := fn(user, userCards)
+
+		earn(user, coins, now)
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(earned(user, map[string]interface{}{
+			"coins":     user.Coins.Add(coins),
+			"last_seen": now,
+		}))); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("users").Where("telegram_id = ?", telegramID).First(&user).Error
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, userCards, nil
+}
+
+// Refer atomically marks the user as referred by the referrer and gives both of them the bonus earned at now.
+// The coins the user earned so far aren't shared with the referrer, nor are the coins of the bonus
+// shared with the referrer of the referrer. Returns the final state of the user.
+func (s *Database) Refer(telegramID, referrerID, now uint64, bonus ReferralBonus) (user *storageModel.User, err error) {
+	s.lgr.Debug("referring user",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("referrer_id", referrerID),
+	)
+
+	if telegramID == referrerID {
+		return nil, ErrCantRefer
+	}
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		var users []*storageModel.User
+
+		// both users are locked in the same order by every referral
+		if res := forUpdate(tx).Table("users").Where("telegram_id IN ?", []uint64{telegramID, referrerID}).Order("telegram_id").Find(&users); res.Error != nil {
+			return res.Error
+		}
+
+		if len(users) != 2 {
+			return ErrRecordNotFound
+		}
+
+		referrer := users[1]
+		if user = users[0]; user.TelegramID != telegramID {
+			user, referrer = referrer, user
+		}
+
+		if !canRefer(user, referrer) {
+			return ErrCantRefer
+		}
+
+		earn(user, bonus.RefereeCoins, now)
+		earn(referrer, bonus.ReferrerCoins, now)
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(earned(user, map[string]interface{}{
+			"coins":                  user.Coins.Add(bonus.RefereeCoins),
+			"gold":                   user.Gold + bonus.RefereeGold,
+			"referred_by":            referrerID,
+			"referral_coins":         bonus.ReferrerCoins,
+			"referral_gold":          bonus.ReferrerGold,
+			"referral_settled_coins": user.LifetimeCoins,
+		}))); res.Error != nil {
+			return res.Error
+		}
+
+		if res := tx.Table("users").Where("telegram_id = ?", referrerID).Updates(versioned(earned(referrer, map[string]interface{}{
+			"coins":                  referrer.Coins.Add(bonus.ReferrerCoins),
+			"gold":                   referrer.Gold + bonus.ReferrerGold,
+			"referral_settled_coins": referrer.ReferralSettledCoins.Add(bonus.ReferrerCoins),
+		}))); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("users").Where("telegram_id = ?", telegramID).First(&user).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// CollectReferralRewards atomically gives the user the shares calculated by fn of the coins the referees earned
+// since the last collection. The shares are earned at now and aren't shared with the referrer of the user.
+// The referees whose share is zero keep the coins for the next collection.
+// Returns the final state of the user and the referees ordered by the time they joined.
+func (s *Database) CollectReferralRewards(telegramID, now uint64, fn ReferralFunc) (user *storageModel.User, referees []storageModel.User, err error) {
+	s.lgr.Debug("collecting referral rewards", zap.Uint64("telegram_id", telegramID))
+
+	err = s.str.Transaction(func(tx *gorm.DB) (err error) {
+		if res := forUpdate(tx).Table("users").Where("telegram_id = ?", telegramID).First(&user); res.Error != nil {
+			return res.Error
+		}
+
+		if res := forUpdate(tx).Table("users").Where("referred_by = ?", telegramID).Order("id").Find(&referees); res.Error != nil {
+			return res.Error
+		}
+
+		total := amount.Zero
+
+		for i := range referees {
+			referee := &referees[i]
+
+			share := fn(referee, referee.LifetimeCoins.Sub(referee.ReferralSettledCoins))
+			if share.IsZero() {
+				continue
+			}
+
+			referee.ReferralCoins = referee.ReferralCoins.Add(share)
+			referee.ReferralSettledCoins = referee.LifetimeCoins
+			total = total.Add(share)
+
+			if res := tx.Table("users").Where("telegram_id = ?", referee.TelegramID).Updates(map[string]interface{}{
+				"referral_coins":         referee.ReferralCoins,
+				"referral_settled_coins": referee.ReferralSettledCoins,
+			}); res.Error != nil {
+				return res.Error
+			}
+		}
+
+		if total.IsZero() {
+			return nil
+		}
+
+		earn(user, total, now)
+
+		if res := tx.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(earned(user, map[string]interface{}{
+			"coins":                  user.Coins.Add(total),
+			"referral_settled_coins": user.ReferralSettledCoins.Add(total),
+		}))); res.Error != nil {
+			return res.Error
+		}
+
+		return tx.Table("users").Where("telegram_id = ?", telegramID).First(&user).Error
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, referees, nil
+}
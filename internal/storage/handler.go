@@ -1,21 +1,25 @@
 package storage
 
 import (
+	"fmt"
+	"github.com/adzpm/telegram-clicker/internal/amount"
 	"github.com/adzpm/telegram-clicker/internal/model/storage"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 	"time"
 )
 
-func (s *Storage) InsertUser(telegramID, coins, gold, investors uint64) (user *storage.User, err error) {
+func (s *Database) InsertUser(telegramID uint64, coins amount.Amount, gold, investors uint64) (user *storage.User, err error) {
 	s.lgr.Debug("inserting user", zap.Uint64("telegram_id", telegramID))
 
 	if res := s.str.Table("users").Create(&storage.User{
-		TelegramID:  telegramID,
-		LastSeen:    uint64(time.Now().Unix()),
-		Coins:       coins,
-		EarnedCoins: coins,
-		Gold:        gold,
-		Investors:   investors,
+		TelegramID:    telegramID,
+		LastSeen:      uint64(time.Now().Unix()),
+		Coins:         coins,
+		EarnedCoins:   coins,
+		LifetimeCoins: coins,
+		Gold:          gold,
+		Investors:     investors,
 	}); res.Error != nil {
 		return nil, res.Error
 	}
@@ -27,7 +31,7 @@ func (s *Storage) InsertUser(telegramID, coins, gold, investors uint64) (user *s
 	return user, nil
 }
 
-func (s *Storage) SelectUser(telegramID uint64) (user *storage.User, err error) {
+func (s *Database) SelectUser(telegramID uint64) (user *storage.User, err error) {
 	s.lgr.Debug("selecting user", zap.Uint64("telegram_id", telegramID))
 
 	res := s.str.Table("users").Where("telegram_id = ?", telegramID).First(&user)
@@ -38,23 +42,93 @@ func (s *Storage) SelectUser(telegramID uint64) (user *storage.User, err error)
 	return user, nil
 }
 
-func (s *Storage) SelectUsers() (users []storage.User, err error) {
+func (s *Database) SelectUsers() (users []storage.User, err error) {
 	s.lgr.Debug("selecting all users")
 
-	if res := s.str.Table("users").Find(&users); res.Error != nil {
+	if res := s.str.Table("users").Order("id").Find(&users); res.Error != nil {
 		return nil, res.Error
 	}
 
 	return users, nil
 }
 
-func (s *Storage) UpdateUserCoins(telegramID, coins uint64) (user *storage.User, err error) {
+// SelectReferees selects the users the user invited, ordered by the time they joined.
+func (s *Database) SelectReferees(telegramID uint64) (referees []storage.User, err error) {
+	s.lgr.Debug("selecting referees", zap.Uint64("telegram_id", telegramID))
+
+	if res := s.str.Table("users").Where("referred_by = ?", telegramID).Order("id").Find(&referees); res.Error != nil {
+		return nil, res.Error
+	}
+
+	return referees, nil
+}
+
+// SelectRanked selects limit users with the scores above zero on the ranking,
+// the highest first and the older users first on ties.
+func (s *Database) SelectRanked(ranking Ranking, day, limit uint64) (users []storage.User, err error) {
+	s.lgr.Debug("selecting ranked users",
+		zap.String("ranking", string(ranking)),
+		zap.Uint64("day", day),
+		zap.Uint64("limit", limit),
+	)
+
+	query, err := s.ranked(ranking, day, amount.Zero)
+	if err != nil {
+		return nil, err
+	}
+
+	if res := query.Order(string(ranking) + " DESC, id").Limit(int(limit)).Find(&users); res.Error != nil {
+		return nil, res.Error
+	}
+
+	return users, nil
+}
+
+// CountRanked counts the users with the scores above the given one on the ranking.
+func (s *Database) CountRanked(ranking Ranking, day uint64, above amount.Amount) (count uint64, err error) {
+	s.lgr.Debug("counting ranked users",
+		zap.String("ranking", string(ranking)),
+		zap.Uint64("day", day),
+		zap.Stringer("above", above),
+	)
+
+	var total int64
+
+	query, err := s.ranked(ranking, day, above)
+	if err != nil {
+		return 0, err
+	}
+
+	if res := query.Count(&total); res.Error != nil {
+		return 0, res.Error
+	}
+
+	return uint64(total), nil
+}
+
+// ranked returns the query of the users with the scores above the given one on the ranking.
+func (s *Database) ranked(ranking Ranking, day uint64, above amount.Amount) (_ *gorm.DB, err error) {
+	query := s.str.Table("users")
+
+	switch ranking {
+	case RankingLifetimeCoins:
+		return query.Where("lifetime_coins > ?", above), nil
+	case RankingInvestors:
+		return query.Where("investors > ?", above.Uint64()), nil
+	case RankingDailyCoins:
+		return query.Where("daily_coins_day = ? AND daily_coins > ?", day, above), nil
+	}
+
+	return nil, fmt.Errorf("unknown ranking: %q", ranking)
+}
+
+func (s *Database) UpdateUserCoins(telegramID uint64, coins amount.Amount) (user *storage.User, err error) {
 	s.lgr.Debug("updating user coins",
 		zap.Uint64("telegram_id", telegramID),
-		zap.Uint64("coins", coins),
+		zap.Stringer("coins", coins),
 	)
 
-	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Update("coins", coins); res.Error != nil {
+	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(map[string]interface{}{"coins": coins})); res.Error != nil {
 		return nil, res.Error
 	}
 
@@ -65,13 +139,13 @@ func (s *Storage) UpdateUserCoins(telegramID, coins uint64) (user *storage.User,
 	return user, nil
 }
 
-func (s *Storage) UpdateUserGold(telegramID, gold uint64) (user *storage.User, err error) {
+func (s *Database) UpdateUserGold(telegramID, gold uint64) (user *storage.User, err error) {
 	s.lgr.Debug("updating user gold",
 		zap.Uint64("telegram_id", telegramID),
 		zap.Uint64("gold", gold),
 	)
 
-	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Update("gold", gold); res.Error != nil {
+	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(map[string]interface{}{"gold": gold})); res.Error != nil {
 		return nil, res.Error
 	}
 
@@ -82,13 +156,13 @@ func (s *Storage) UpdateUserGold(telegramID, gold uint64) (user *storage.User, e
 	return user, nil
 }
 
-func (s *Storage) UpdateUserInvestors(telegramID, investors uint64) (user *storage.User, err error) {
+func (s *Database) UpdateUserInvestors(telegramID, investors uint64) (user *storage.User, err error) {
 	s.lgr.Debug("updating user investors",
 		zap.Uint64("telegram_id", telegramID),
 		zap.Uint64("investors", investors),
 	)
 
-	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Update("investors", investors); res.Error != nil {
+	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(map[string]interface{}{"investors": investors})); res.Error != nil {
 		return nil, res.Error
 	}
 
@@ -99,13 +173,13 @@ func (s *Storage) UpdateUserInvestors(telegramID, investors uint64) (user *stora
 	return user, nil
 }
 
-func (s *Storage) UpdateUserEarnedCoins(telegramID, earnedCoins uint64) (user *storage.User, err error) {
+func (s *Database) UpdateUserEarnedCoins(telegramID uint64, earnedCoins amount.Amount) (user *storage.User, err error) {
 	s.lgr.Debug("updating user earned coins",
 		zap.Uint64("telegram_id", telegramID),
-		zap.Uint64("earned_coins", earnedCoins),
+		zap.Stringer("earned_coins", earnedCoins),
 	)
 
-	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Update("earned_coins", earnedCoins); res.Error != nil {
+	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(map[string]interface{}{"earned_coins": earnedCoins})); res.Error != nil {
 		return nil, res.Error
 	}
 
@@ -116,13 +190,13 @@ func (s *Storage) UpdateUserEarnedCoins(telegramID, earnedCoins uint64) (user *s
 	return user, nil
 }
 
-func (s *Storage) UpdateUserLastSeen(telegramID, lastSeen uint64) (user *storage.User, err error) {
+func (s *Database) UpdateUserLastSeen(telegramID, lastSeen uint64) (user *storage.User, err error) {
 	s.lgr.Debug("updating user last seen",
 		zap.Uint64("telegram_id", telegramID),
 		zap.Uint64("last_seen", lastSeen),
 	)
 
-	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Update("last_seen", lastSeen); res.Error != nil {
+	if res := s.str.Table("users").Where("telegram_id = ?", telegramID).Updates(versioned(map[string]interface{}{"last_seen": lastSeen})); res.Error != nil {
 		return nil, res.Error
 	}
 
@@ -133,7 +207,7 @@ func (s *Storage) UpdateUserLastSeen(telegramID, lastSeen uint64) (user *storage
 	return user, nil
 }
 
-func (s *Storage) InsertUserCard(telegramID, cardID, level uint64) (userCard *storage.UserCard, err error) {
+func (s *Database) InsertUserCard(telegramID, cardID, level uint64) (userCard *storage.UserCard, err error) {
 	s.lgr.Debug("inserting user card",
 		zap.Uint64("telegram_id", telegramID),
 		zap.Uint64("card_id", cardID),
@@ -155,7 +229,7 @@ func (s *Storage) InsertUserCard(telegramID, cardID, level uint64) (userCard *st
 	return userCard, nil
 }
 
-func (s *Storage) SelectUserCard(telegramID, cardID uint64) (userCard *storage.UserCard, err error) {
+func (s *Database) SelectUserCard(telegramID, cardID uint64) (userCard *storage.UserCard, err error) {
 	s.lgr.Debug("selecting user card",
 		zap.Uint64("telegram_id", telegramID),
 		zap.Uint64("card_id", cardID),
@@ -168,7 +242,7 @@ func (s *Storage) SelectUserCard(telegramID, cardID uint64) (userCard *storage.U
 	return userCard, nil
 }
 
-func (s *Storage) SelectUserCards(telegramID uint64) (userCards []storage.UserCard, err error) {
+func (s *Database) SelectUserCards(telegramID uint64) (userCards []storage.UserCard, err error) {
 	s.lgr.Debug("selecting user cards", zap.Uint64("telegram_id", telegramID))
 
 	if res := s.str.Table("user_cards").Where("telegram_id = ?", telegramID).Find(&userCards); res.Error != nil {
@@ -178,7 +252,7 @@ func (s *Storage) SelectUserCards(telegramID uint64) (userCards []storage.UserCa
 	return userCards, nil
 }
 
-func (s *Storage) UpdateUserCardLevel(telegramID, cardID, level uint64) (userCard *storage.UserCard, err error) {
+func (s *Database) UpdateUserCardLevel(telegramID, cardID, level uint64) (userCard *storage.UserCard, err error) {
 	s.lgr.Debug("updating user card level",
 		zap.Uint64("telegram_id", telegramID),
 		zap.Uint64("card_id", cardID),
@@ -196,7 +270,7 @@ func (s *Storage) UpdateUserCardLevel(telegramID, cardID, level uint64) (userCar
 	return userCard, nil
 }
 
-func (s *Storage) UpdateUserCardNextClick(telegramID, cardID, nextClick uint64) (userCard *storage.UserCard, err error) {
+func (s *Database) UpdateUserCardNextClick(telegramID, cardID, nextClick uint64) (userCard *storage.UserCard, err error) {
 	s.lgr.Debug("updating user card next click",
 		zap.Uint64("telegram_id", telegramID),
 		zap.Uint64("card_id", cardID),
@@ -214,7 +288,7 @@ func (s *Storage) UpdateUserCardNextClick(telegramID, cardID, nextClick uint64)
 	return userCard, nil
 }
 
-func (s *Storage) UpdateUserCardLastClick(telegramID, cardID, lastClick uint64) (userCard *storage.UserCard, err error) {
+func (s *Database) UpdateUserCardLastClick(telegramID, cardID, lastClick uint64) (userCard *storage.UserCard, err error) {
 	s.lgr.Debug("updating user card last click",
 		zap.Uint64("telegram_id", telegramID),
 		zap.Uint64("card_id", cardID),
@@ -232,22 +306,48 @@ func (s *Storage) UpdateUserCardLastClick(telegramID, cardID, lastClick uint64)
 	return userCard, nil
 }
 
-func (s *Storage) SelectCard(cardID uint64) (cards *storage.Card, err error) {
+func (s *Database) SelectCard(cardID uint64) (cards *storage.Card, err error) {
 	s.lgr.Debug("selecting card", zap.Uint64("card_id", cardID))
 
-	if res := s.str.Table("cards").Where("id = ?", cardID).First(&cards); res.Error != nil {
+	return s.ctl.Card(cardID)
+}
+
+func (s *Database) SelectCards() (cards []storage.Card, err error) {
+	s.lgr.Debug("selecting all cards")
+
+	return s.ctl.Cards()
+}
+
+// loadCards selects the cards which aren't retired for the catalog cache.
+func (s *Database) loadCards() (cards []storage.Card, err error) {
+	s.lgr.Debug("loading cards")
+
+	if res := s.str.Table("cards").Where("retired = ?", false).Order("id").Find(&cards); res.Error != nil {
 		return nil, res.Error
 	}
 
 	return cards, nil
 }
 
-func (s *Storage) SelectCards() (cards []storage.Card, err error) {
-	s.lgr.Debug("selecting all cards")
+func (s *Database) SelectUserPurchases(telegramID, now uint64) (purchases []storage.UserPurchase, err error) {
+	s.lgr.Debug("selecting active user purchases", zap.Uint64("telegram_id", telegramID))
 
-	if res := s.str.Table("cards").Find(&cards); res.Error != nil {
+	if res := s.str.Table("user_purchases").
+		Where("telegram_id = ? AND (expires_at > ? OR skip_timeouts > 0)", telegramID, now).
+		Order("id").
+		Find(&purchases); res.Error != nil {
 		return nil, res.Error
 	}
 
-	return cards, nil
+	return purchases, nil
+}
+
+func (s *Database) SelectUserTasks(telegramID uint64) (userTasks []storage.UserTask, err error) {
+	s.lgr.Debug("selecting user tasks", zap.Uint64("telegram_id", telegramID))
+
+	if res := s.str.Table("user_tasks").Where("telegram_id = ?", telegramID).Order("task_id").Find(&userTasks); res.Error != nil {
+		return nil, res.Error
+	}
+
+	return userTasks, nil
 }
@@ -0,0 +1,837 @@
+package storage
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	zap "go.uber.org/zap"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+type (
+	// Memory is a thread-safe in-memory storage for tests and local play. All data is lost on restart.
+	Memory struct {
+		mu  sync.RWMutex
+		lgr *zap.Logger
+
+		users     map[uint64]*storageModel.User
+		userCards map[uint64]map[uint64]*storageModel.UserCard
+		cards     map[uint64]*storageModel.Card
+		purchases map[uint64][]*storageModel.UserPurchase
+		userTasks map[uint64]map[uint64]*storageModel.UserTask
+
+		lastUserID     uint64
+		lastUserCardID uint64
+		lastPurchaseID uint64
+		lastUserTaskID uint64
+	}
+)
+
+// NewMemory creates a new in-memory storage filled with the given cards.
+func NewMemory(lgr *zap.Logger, cards []storageModel.Card) *Memory {
+	m := &Memory{
+		lgr:       lgr,
+		users:     make(map[uint64]*storageModel.User),
+		userCards: make(map[uint64]map[uint64]*storageModel.UserCard),
+		cards:     make(map[uint64]*storageModel.Card, len(cards)),
+		purchases: make(map[uint64][]*storageModel.UserPurchase),
+		userTasks: make(map[uint64]map[uint64]*storageModel.UserTask),
+	}
+
+	for _, card := range cards {
+		card = copyCard(&card)
+		m.cards[card.ID] = &card
+	}
+
+	return m
+}
+
+func (m *Memory) InsertUser(telegramID uint64, coins amount.Amount, gold, investors uint64) (_ *storageModel.User, err error) {
+	m.lgr.Debug("inserting user", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[telegramID]; ok {
+		return nil, ErrDuplicatedKey
+	}
+
+	m.lastUserID++
+
+	user := &storageModel.User{
+		ID:            m.lastUserID,
+		TelegramID:    telegramID,
+		LastSeen:      uint64(time.Now().Unix()),
+		Coins:         coins,
+		EarnedCoins:   coins,
+		LifetimeCoins: coins,
+		Gold:          gold,
+		Investors:     investors,
+	}
+
+	m.users[telegramID] = user
+
+	return copyOf(user), nil
+}
+
+func (m *Memory) SelectUser(telegramID uint64) (_ *storageModel.User, err error) {
+	m.lgr.Debug("selecting user", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyOf(user), nil
+}
+
+func (m *Memory) SelectUsers() (users []storageModel.User, err error) {
+	m.lgr.Debug("selecting all users")
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		users = append(users, *user)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users, nil
+}
+
+// SelectRanked selects limit users with the scores above zero on the ranking,
+// the highest first and the older users first on ties.
+func (m *Memory) SelectRanked(ranking Ranking, day, limit uint64) (users []storageModel.User, err error) {
+	m.lgr.Debug("selecting ranked users", zap.String("ranking", string(ranking)), zap.Uint64("day", day), zap.Uint64("limit", limit))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if !ranking.Score(user, day).IsZero() {
+			users = append(users, *user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if c := ranking.Score(&users[i], day).Cmp(ranking.Score(&users[j], day)); c != 0 {
+			return c > 0
+		}
+
+		return users[i].ID < users[j].ID
+	})
+
+	return users[:min(uint64(len(users)), limit)], nil
+}
+
+// CountRanked counts the users with the scores above the given one on the ranking.
+func (m *Memory) CountRanked(ranking Ranking, day uint64, above amount.Amount) (count uint64, err error) {
+	m.lgr.Debug("counting ranked users", zap.String("ranking", string(ranking)), zap.Uint64("day", day), zap.Stringer("above", above))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if ranking.Score(user, day).Cmp(above) > 0 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// updateUser applies fn to the user under the write lock and returns a copy of the result.
+func (m *Memory) updateUser(telegramID uint64, fn func(user *storageModel.User)) (_ *storageModel.User, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	fn(user)
+	user.Version++
+
+	return copyOf(user), nil
+}
+
+func (m *Memory) UpdateUserCoins(telegramID uint64, coins amount.Amount) (_ *storageModel.User, err error) {
+	m.lgr.Debug("updating user coins", zap.Uint64("telegram_id", telegramID), zap.Stringer("coins", coins))
+
+	return m.updateUser(telegramID, func(user *storageModel.User) { user.Coins = coins })
+}
+
+func (m *Memory) UpdateUserGold(telegramID, gold uint64) (_ *storageModel.User, err error) {
+	m.lgr.Debug("updating user gold", zap.Uint64("telegram_id", telegramID), zap.Uint64("gold", gold))
+
+	return m.updateUser(telegramID, func(user *storageModel.User) { user.Gold = gold })
+}
+
+func (m *Memory) UpdateUserInvestors(telegramID, investors uint64) (_ *storageModel.User, err error) {
+	m.lgr.Debug("updating user investors", zap.Uint64("telegram_id", telegramID), zap.Uint64("investors", investors))
+
+	return m.updateUser(telegramID, func(user *storageModel.User) { user.Investors = investors })
+}
+
+func (m *Memory) UpdateUserEarnedCoins(telegramID uint64, earnedCoins amount.Amount) (_ *storageModel.User, err error) {
+	m.lgr.Debug("updating user earned coins", zap.Uint64("telegram_id", telegramID), zap.Stringer("earned_coins", earnedCoins))
+
+	return m.updateUser(telegramID, func(user *storageModel.User) { user.EarnedCoins = earnedCoins })
+}
+
+func (m *Memory) UpdateUserLastSeen(telegramID, lastSeen uint64) (_ *storageModel.User, err error) {
+	m.lgr.Debug("updating user last seen", zap.Uint64("telegram_id", telegramID), zap.Uint64("last_seen", lastSeen))
+
+	return m.updateUser(telegramID, func(user *storageModel.User) { user.LastSeen = lastSeen })
+}
+
+func (m *Memory) InsertUserCard(telegramID, cardID, level uint64) (_ *storageModel.UserCard, err error) {
+	m.lgr.Debug("inserting user card",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("card_id", cardID),
+		zap.Uint64("level", level),
+	)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.userCards[telegramID][cardID]; ok {
+		return nil, ErrDuplicatedKey
+	}
+
+	return copyOf(m.insertUserCard(telegramID, cardID, level)), nil
+}
+
+// insertUserCard inserts the user card, the caller must hold the write lock.
+func (m *Memory) insertUserCard(telegramID, cardID, level uint64) *storageModel.UserCard {
+	if _, ok := m.userCards[telegramID]; !ok {
+		m.userCards[telegramID] = make(map[uint64]*storageModel.UserCard)
+	}
+
+	m.lastUserCardID++
+
+	userCard := &storageModel.UserCard{
+		ID:         m.lastUserCardID,
+		TelegramID: telegramID,
+		CardID:     cardID,
+		Level:      level,
+	}
+
+	m.userCards[telegramID][cardID] = userCard
+
+	return userCard
+}
+
+func (m *Memory) SelectUserCard(telegramID, cardID uint64) (_ *storageModel.UserCard, err error) {
+	m.lgr.Debug("selecting user card", zap.Uint64("telegram_id", telegramID), zap.Uint64("card_id", cardID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userCard, ok := m.userCards[telegramID][cardID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyOf(userCard), nil
+}
+
+func (m *Memory) SelectUserCards(telegramID uint64) (userCards []storageModel.UserCard, err error) {
+	m.lgr.Debug("selecting user cards", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.selectUserCards(telegramID), nil
+}
+
+// selectUserCards returns copies of the user cards ordered by id, the caller must hold the lock.
+func (m *Memory) selectUserCards(telegramID uint64) (userCards []storageModel.UserCard) {
+	for _, userCard := range m.userCards[telegramID] {
+		userCards = append(userCards, *userCard)
+	}
+
+	sort.Slice(userCards, func(i, j int) bool { return userCards[i].ID < userCards[j].ID })
+
+	return userCards
+}
+
+// updateUserCard applies fn to the user card under the write lock and returns a copy of the result.
+func (m *Memory) updateUserCard(telegramID, cardID uint64, fn func(userCard *storageModel.UserCard)) (_ *storageModel.UserCard, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userCard, ok := m.userCards[telegramID][cardID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	fn(userCard)
+
+	return copyOf(userCard), nil
+}
+
+func (m *Memory) UpdateUserCardLevel(telegramID, cardID, level uint64) (_ *storageModel.UserCard, err error) {
+	m.lgr.Debug("updating user card level",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("card_id", cardID),
+		zap.Uint64("level", level),
+	)
+
+	return m.updateUserCard(telegramID, cardID, func(userCard *storageModel.UserCard) { userCard.Level = level })
+}
+
+func (m *Memory) UpdateUserCardNextClick(telegramID, cardID, nextClick uint64) (_ *storageModel.UserCard, err error) {
+	m.lgr.Debug("updating user card next click",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("card_id", cardID),
+		zap.Uint64("next_click", nextClick),
+	)
+
+	return m.updateUserCard(telegramID, cardID, func(userCard *storageModel.UserCard) { userCard.NextClick = nextClick })
+}
+
+func (m *Memory) UpdateUserCardLastClick(telegramID, cardID, lastClick uint64) (_ *storageModel.UserCard, err error) {
+	m.lgr.Debug("updating user card last click",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("card_id", cardID),
+		zap.Uint64("last_click", lastClick),
+	)
+
+	return m.updateUserCard(telegramID, cardID, func(userCard *storageModel.UserCard) { userCard.LastClick = lastClick })
+}
+
+func (m *Memory) SelectCard(cardID uint64) (_ *storageModel.Card, err error) {
+	m.lgr.Debug("selecting card", zap.Uint64("card_id", cardID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	card, ok := m.cards[cardID]
+	if !ok || card.Retired {
+		return nil, ErrRecordNotFound
+	}
+
+	res := copyCard(card)
+
+	return &res, nil
+}
+
+func (m *Memory) SelectCards() (cards []storageModel.Card, err error) {
+	m.lgr.Debug("selecting all cards")
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, card := range m.cards {
+		if !card.Retired {
+			cards = append(cards, copyCard(card))
+		}
+	}
+
+	sort.Slice(cards, func(i, j int) bool { return cards[i].ID < cards[j].ID })
+
+	return cards, nil
+}
+
+func (m *Memory) SyncCards(cards []storageModel.Card, retire bool) (sync *CardsSync, err error) {
+	if err = ValidateCards(cards); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		catalog = make(map[uint64]bool, len(cards))
+		retired []uint64
+	)
+
+	sync = &CardsSync{}
+
+	for _, card := range cards {
+		catalog[card.ID] = true
+		card.Retired = false
+
+		current, ok := m.cards[card.ID]
+
+		switch {
+		case !ok:
+			sync.Inserted = append(sync.Inserted, card.ID)
+		case !reflect.DeepEqual(*current, card):
+			sync.Updated = append(sync.Updated, card.ID)
+		}
+
+		card = copyCard(&card)
+		m.cards[card.ID] = &card
+	}
+
+	for _, card := range m.cards {
+		if !retire || card.Retired || catalog[card.ID] {
+			continue
+		}
+
+		card.Retired = true
+		retired = append(retired, card.ID)
+	}
+
+	sort.Slice(retired, func(i, j int) bool { return retired[i] < retired[j] })
+	sync.Retired = retired
+
+	m.lgr.Info("cards synchronized",
+		zap.Uint64s("inserted", sync.Inserted),
+		zap.Uint64s("updated", sync.Updated),
+		zap.Uint64s("retired", sync.Retired),
+	)
+
+	return sync, nil
+}
+
+// InvalidateCards does nothing: the memory storage holds the catalog itself, there is nothing to cache.
+func (m *Memory) InvalidateCards() {}
+
+// Close does nothing: there is no connection to close.
+func (m *Memory) Close() error { return nil }
+
+func (m *Memory) SelectUserPurchases(telegramID, now uint64) (purchases []storageModel.UserPurchase, err error) {
+	m.lgr.Debug("selecting active user purchases", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, purchase := range m.purchases[telegramID] {
+		if purchase.ExpiresAt > now || purchase.SkipTimeouts > 0 {
+			purchases = append(purchases, *purchase)
+		}
+	}
+
+	return purchases, nil
+}
+
+func (m *Memory) Click(telegramID, cardID, now uint64, skipTimeout bool, fn ClickFunc) (_ *storageModel.User, _ *storageModel.UserCard, err error) {
+	m.lgr.Debug("clicking card", zap.Uint64("telegram_id", telegramID), zap.Uint64("card_id", cardID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	card, ok := m.cards[cardID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	userCard, ok := m.userCards[telegramID][cardID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	if userCard.Level == 0 {
+		return nil, nil, ErrCardNotBought
+	}
+
+	if now < userCard.NextClick && !m.spendSkipTimeout(telegramID, skipTimeout) {
+		return nil, nil, &ClickTimeoutError{Now: now, NextClick: userCard.NextClick}
+	}
+
+	coins, timeout := fn(copyOf(user), copyOf(card), copyOf(userCard))
+
+	user.Coins = user.Coins.Add(coins)
+	earn(user, coins, now)
+	user.Clicks++
+	user.LastSeen = now
+	user.Version++
+	userCard.LastClick = now
+	userCard.NextClick = now + timeout
+
+	return copyOf(user), copyOf(userCard), nil
+}
+
+func (m *Memory) ApplyClicks(batches []ClickBatch) (err error) {
+	m.lgr.Debug("applying clicks", zap.Int("batches", len(batches)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, batch := range batches {
+		user, ok := m.users[batch.TelegramID]
+		if !ok {
+			continue
+		}
+
+		user.Coins = user.Coins.Add(batch.Coins)
+		earn(user, batch.Coins, batch.LastSeen)
+		user.Clicks += batch.Clicks
+		user.Version += batch.Clicks
+		user.LastSeen = batch.LastSeen
+
+		for cardID, card := range batch.Cards {
+			if userCard, ok := m.userCards[batch.TelegramID][cardID]; ok {
+				userCard.LastClick = card.LastClick
+				userCard.NextClick = card.NextClick
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) Buy(telegramID, cardID uint64, fn BuyFunc) (_ *storageModel.User, _ *storageModel.UserCard, err error) {
+	m.lgr.Debug("buying card", zap.Uint64("telegram_id", telegramID), zap.Uint64("card_id", cardID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	card, ok := m.cards[cardID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	userCard, exists := m.userCards[telegramID][cardID]
+	if !exists {
+		userCard = &storageModel.UserCard{TelegramID: telegramID, CardID: cardID}
+	}
+
+	levels, price, err := fn(copyOf(user), copyOf(card), copyOf(userCard))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user.Coins.Cmp(price) < 0 {
+		return nil, nil, &NotEnoughCoinsError{Price: price, Coins: user.Coins}
+	}
+
+	if !exists {
+		userCard = m.insertUserCard(telegramID, cardID, 0)
+	}
+
+	user.Coins = user.Coins.Sub(price)
+	user.Version++
+	userCard.Level += levels
+
+	return copyOf(user), copyOf(userCard), nil
+}
+
+func (m *Memory) Prestige(telegramID, startCardID uint64, fn PrestigeFunc) (_ *storageModel.User, _ []storageModel.UserCard, err error) {
+	m.lgr.Debug("resetting game", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	investors, progress := fn(copyOf(user), m.selectUserCards(telegramID))
+
+	for taskID, taskProgress := range progress {
+		userTask := m.userTask(telegramID, taskID)
+		userTask.Progress = max(userTask.Progress, taskProgress)
+	}
+
+	user.Investors = investors
+	user.Prestiges++
+	user.Coins = amount.Zero
+	user.EarnedCoins = amount.Zero
+	user.Version++
+
+	for _, userCard := range m.userCards[telegramID] {
+		userCard.Level = 0
+		userCard.LastClick = 0
+		userCard.NextClick = 0
+	}
+
+	if userCard, ok := m.userCards[telegramID][startCardID]; ok {
+		userCard.Level = 1
+	}
+
+	return copyOf(user), m.selectUserCards(telegramID), nil
+}
+
+func (m *Memory) Purchase(purchase *storageModel.UserPurchase) (_ *storageModel.User, err error) {
+	m.lgr.Debug("purchasing item",
+		zap.Uint64("telegram_id", purchase.TelegramID),
+		zap.Uint64("item_id", purchase.ItemID),
+	)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[purchase.TelegramID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	if user.Gold < purchase.Price {
+		return nil, &NotEnoughGoldError{Price: purchase.Price, Gold: user.Gold}
+	}
+
+	user.Gold -= purchase.Price
+	user.Coins = user.Coins.Add(purchase.Coins)
+	user.Version++
+
+	if purchase.RefreshClicks {
+		for _, userCard := range m.userCards[purchase.TelegramID] {
+			userCard.NextClick = 0
+		}
+	}
+
+	m.lastPurchaseID++
+	purchase.ID = m.lastPurchaseID
+	m.purchases[purchase.TelegramID] = append(m.purchases[purchase.TelegramID], copyOf(purchase))
+
+	return copyOf(user), nil
+}
+
+func (m *Memory) SelectUserTasks(telegramID uint64) (userTasks []storageModel.UserTask, err error) {
+	m.lgr.Debug("selecting user tasks", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, userTask := range m.userTasks[telegramID] {
+		userTasks = append(userTasks, *userTask)
+	}
+
+	sort.Slice(userTasks, func(i, j int) bool { return userTasks[i].TaskID < userTasks[j].TaskID })
+
+	return userTasks, nil
+}
+
+func (m *Memory) UpdateUserTaskProgress(telegramID, taskID, progress uint64) (_ *storageModel.UserTask, err error) {
+	m.lgr.Debug("updating user task progress",
+		zap.Uint64("telegram_id", telegramID),
+		zap.Uint64("task_id", taskID),
+		zap.Uint64("progress", progress),
+	)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	userTask := m.userTask(telegramID, taskID)
+	userTask.Progress = max(userTask.Progress, progress)
+
+	return copyOf(userTask), nil
+}
+
+func (m *Memory) ClaimTask(telegramID, taskID, now uint64, fn ClaimFunc) (_ *storageModel.User, _ *storageModel.UserTask, err error) {
+	m.lgr.Debug("claiming task", zap.Uint64("telegram_id", telegramID), zap.Uint64("task_id", taskID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	userTask, exists := m.userTasks[telegramID][taskID]
+	if !exists {
+		userTask = &storageModel.UserTask{TelegramID: telegramID, TaskID: taskID}
+	}
+
+	if userTask.Claimed {
+		return nil, nil, ErrTaskClaimed
+	}
+
+	coins, gold, err := fn(copyOf(user), copyOf(userTask))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !exists {
+		userTask = m.userTask(telegramID, taskID)
+	}
+
+	user.Coins = user.Coins.Add(coins)
+	earn(user, coins, now)
+	user.Gold += gold
+	user.Version++
+	userTask.Claimed = true
+
+	return copyOf(user), copyOf(userTask), nil
+}
+
+// userTask returns the stored user task, creating it if the user doesn't have it yet.
+func (m *Memory) userTask(telegramID, taskID uint64) *storageModel.UserTask {
+	if _, ok := m.userTasks[telegramID]; !ok {
+		m.userTasks[telegramID] = make(map[uint64]*storageModel.UserTask)
+	}
+
+	userTask, ok := m.userTasks[telegramID][taskID]
+	if !ok {
+		m.lastUserTaskID++
+
+		userTask = &storageModel.UserTask{ID: m.lastUserTaskID, TelegramID: telegramID, TaskID: taskID}
+		m.userTasks[telegramID][taskID] = userTask
+	}
+
+	return userTask
+}
+
+// spendSkipTimeout spends one timeout skip of the user purchases, if it's allowed and there is any left.
+func (m *Memory) spendSkipTimeout(telegramID uint64, skipTimeout bool) bool {
+	if !skipTimeout {
+		return false
+	}
+
+	for _, purchase := range m.purchases[telegramID] {
+		if purchase.SkipTimeouts > 0 {
+			purchase.SkipTimeouts--
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyOf returns a shallow copy of the value, so callers can't modify the stored records.
+func copyOf[T any](value *T) *T {
+	res := *value
+
+	return &res
+}
+
+func (m *Memory) EarnCoins(telegramID uint64, coins amount.Amount, now uint64) (_ *storageModel.User, err error) {
+	m.lgr.Debug("earning coins", zap.Uint64("telegram_id", telegramID), zap.Stringer("coins", coins))
+
+	return m.updateUser(telegramID, func(user *storageModel.User) {
+		user.Coins = user.Coins.Add(coins)
+		earn(user, coins, now)
+	})
+}
+
+func (m *Memory) Enter(telegramID, now uint64, fn OfflineFunc) (_ *storageModel.User, userCards []storageModel.UserCard, err error) {
+	m.lgr.Debug("entering game", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	userCards = m.selectUserCards(telegramID)
+	coins := fn(copyOf(user), userCards)
+
+	user.Coins = user.Coins.Add(coins)
+	earn(user, coins, now)
+	user.LastSeen = now
+	user.Version++
+
+	return copyOf(user), userCards, nil
+}
+
+func (m *Memory) Refer(telegramID, referrerID, now uint64, bonus ReferralBonus) (_ *storageModel.User, err error) {
+	m.lgr.Debug("referring user", zap.Uint64("telegram_id", telegramID), zap.Uint64("referrer_id", referrerID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	referrer, ok := m.users[referrerID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	if !canRefer(user, referrer) {
+		return nil, ErrCantRefer
+	}
+
+	user.Coins = user.Coins.Add(bonus.RefereeCoins)
+	earn(user, bonus.RefereeCoins, now)
+	user.Gold += bonus.RefereeGold
+	user.ReferredBy = referrerID
+	user.ReferralCoins, user.ReferralGold = bonus.ReferrerCoins, bonus.ReferrerGold
+	user.ReferralSettledCoins = user.LifetimeCoins
+	user.Version++
+
+	referrer.Coins = referrer.Coins.Add(bonus.ReferrerCoins)
+	earn(referrer, bonus.ReferrerCoins, now)
+	referrer.Gold += bonus.ReferrerGold
+	referrer.ReferralSettledCoins = referrer.ReferralSettledCoins.Add(bonus.ReferrerCoins)
+	referrer.Version++
+
+	return copyOf(user), nil
+}
+
+// SelectReferees selects the users the user invited, ordered by the time they joined.
+func (m *Memory) SelectReferees(telegramID uint64) (referees []storageModel.User, err error) {
+	m.lgr.Debug("selecting referees", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, referee := range m.users {
+		if referee.ReferredBy == telegramID {
+			referees = append(referees, *referee)
+		}
+	}
+
+	sort.Slice(referees, func(i, j int) bool { return referees[i].ID < referees[j].ID })
+
+	return referees, nil
+}
+
+func (m *Memory) CollectReferralRewards(telegramID, now uint64, fn ReferralFunc) (_ *storageModel.User, referees []storageModel.User, err error) {
+	m.lgr.Debug("collecting referral rewards", zap.Uint64("telegram_id", telegramID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[telegramID]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	total := amount.Zero
+
+	for _, referee := range m.users {
+		if referee.ReferredBy != telegramID {
+			continue
+		}
+
+		if share := fn(copyOf(referee), referee.LifetimeCoins.Sub(referee.ReferralSettledCoins)); !share.IsZero() {
+			referee.ReferralCoins = referee.ReferralCoins.Add(share)
+			referee.ReferralSettledCoins = referee.LifetimeCoins
+			total = total.Add(share)
+		}
+
+		referees = append(referees, *referee)
+	}
+
+	sort.Slice(referees, func(i, j int) bool { return referees[i].ID < referees[j].ID })
+
+	if !total.IsZero() {
+		user.Coins = user.Coins.Add(total)
+		earn(user, total, now)
+		user.ReferralSettledCoins = user.ReferralSettledCoins.Add(total)
+		user.Version++
+	}
+
+	return copyOf(user), referees, nil
+}
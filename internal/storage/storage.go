@@ -4,108 +4,188 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"time"
 
+	sqlite "github.com/glebarez/sqlite"
 	zap "go.uber.org/zap"
 	postgres "gorm.io/driver/postgres"
 	gorm "gorm.io/gorm"
-	logger "gorm.io/gorm/logger"
 
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
 	config "github.com/adzpm/telegram-clicker/internal/config"
+	math "github.com/adzpm/telegram-clicker/internal/math"
 	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
 )
 
-type (
-	Storage struct {
-		str *gorm.DB
-		lgr *zap.Logger
-		cfg *config.Storage
-	}
+const (
+	// RankingLifetimeCoins ranks the users by the coins earned in all the runs.
+	RankingLifetimeCoins Ranking = "lifetime_coins"
+	// RankingInvestors ranks the users by the current investors.
+	RankingInvestors Ranking = "investors"
+	// RankingDailyCoins ranks the users by the coins earned on the day.
+	RankingDailyCoins Ranking = "daily_coins"
 )
 
-var (
-	migrate = []interface{}{
-		storageModel.User{},
-		storageModel.UserCard{},
-		storageModel.Card{},
-	}
+const (
+	DriverMemory   = config.DriverMemory
+	DriverPostgres = config.DriverPostgres
+	DriverSQLite   = config.DriverSQLite
 )
 
-func New(lgr *zap.Logger, cfg *config.Storage) (_ *Storage, err error) {
-	var (
-		str *gorm.DB
-
-		dsn = fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
-			cfg.Host,
-			cfg.DBUser,
-			cfg.DBPass,
-			cfg.DBName,
-			cfg.Port,
-		)
-	)
-
-	if str, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
-			SlowThreshold:             time.Second,
-			LogLevel:                  logger.Silent,
-			IgnoreRecordNotFoundError: true,
-			ParameterizedQueries:      true,
-		}),
-	}); err != nil {
-		return nil, err
-	}
+var (
+	// ErrRecordNotFound is returned by every storage implementation when the record doesn't exist.
+	ErrRecordNotFound = gorm.ErrRecordNotFound
+	// ErrDuplicatedKey is returned by every storage implementation when the user or the user card already exists.
+	ErrDuplicatedKey = gorm.ErrDuplicatedKey
+)
 
-	if err = str.AutoMigrate(migrate...); err != nil {
-		return nil, err
+type (
+	// Ranking is the column of the users the leaderboards rank by.
+	Ranking string
+
+	Storage interface {
+		InsertUser(telegramID uint64, coins amount.Amount, gold, investors uint64) (*storageModel.User, error)
+		SelectUser(telegramID uint64) (*storageModel.User, error)
+		SelectUsers() ([]storageModel.User, error)
+		SelectRanked(ranking Ranking, day, limit uint64) ([]storageModel.User, error)
+		CountRanked(ranking Ranking, day uint64, above amount.Amount) (uint64, error)
+		SelectReferees(telegramID uint64) ([]storageModel.User, error)
+		UpdateUserCoins(telegramID uint64, coins amount.Amount) (*storageModel.User, error)
+		UpdateUserGold(telegramID, gold uint64) (*storageModel.User, error)
+		UpdateUserInvestors(telegramID, investors uint64) (*storageModel.User, error)
+		UpdateUserEarnedCoins(telegramID uint64, earnedCoins amount.Amount) (*storageModel.User, error)
+		UpdateUserLastSeen(telegramID, lastSeen uint64) (*storageModel.User, error)
+
+		InsertUserCard(telegramID, cardID, level uint64) (*storageModel.UserCard, error)
+		SelectUserCard(telegramID, cardID uint64) (*storageModel.UserCard, error)
+		SelectUserCards(telegramID uint64) ([]storageModel.UserCard, error)
+		UpdateUserCardLevel(telegramID, cardID, level uint64) (*storageModel.UserCard, error)
+		UpdateUserCardNextClick(telegramID, cardID, nextClick uint64) (*storageModel.UserCard, error)
+		UpdateUserCardLastClick(telegramID, cardID, lastClick uint64) (*storageModel.UserCard, error)
+
+		SelectCard(cardID uint64) (*storageModel.Card, error)
+		SelectCards() ([]storageModel.Card, error)
+		InvalidateCards()
+		SyncCards(cards []storageModel.Card, retire bool) (*CardsSync, error)
+
+		SelectUserPurchases(telegramID, now uint64) ([]storageModel.UserPurchase, error)
+
+		SelectUserTasks(telegramID uint64) ([]storageModel.UserTask, error)
+		UpdateUserTaskProgress(telegramID, taskID, progress uint64) (*storageModel.UserTask, error)
+
+		Click(telegramID, cardID, now uint64, skipTimeout bool, fn ClickFunc) (*storageModel.User, *storageModel.UserCard, error)
+		Buy(telegramID, cardID uint64, fn BuyFunc) (*storageModel.User, *storageModel.UserCard, error)
+		Prestige(telegramID, startCardID uint64, fn PrestigeFunc) (*storageModel.User, []storageModel.UserCard, error)
+		Purchase(purchase *storageModel.UserPurchase) (*storageModel.User, error)
+		ClaimTask(telegramID, taskID, now uint64, fn ClaimFunc) (*storageModel.User, *storageModel.UserTask, error)
+		EarnCoins(telegramID uint64, coins amount.Amount, now uint64) (*storageModel.User, error)
+		Enter(telegramID, now uint64, fn OfflineFunc) (*storageModel.User, []storageModel.UserCard, error)
+		Refer(telegramID, referrerID, now uint64, bonus ReferralBonus) (*storageModel.User, error)
+		CollectReferralRewards(telegramID, now uint64, fn ReferralFunc) (*storageModel.User, []storageModel.User, error)
+		ApplyClicks(batches []ClickBatch) error
+
+		Close() error
 	}
+)
 
-	res := &Storage{
-		str: str,
-		lgr: lgr,
-		cfg: cfg,
+// Score returns the score of the user on the ranking on the day, the daily coins of the other days don't count.
+func (r Ranking) Score(user *storageModel.User, day uint64) amount.Amount {
+	switch r {
+	case RankingLifetimeCoins:
+		return user.LifetimeCoins
+	case RankingInvestors:
+		return amount.New(user.Investors)
+	case RankingDailyCoins:
+		if user.DailyCoinsDay == day {
+			return user.DailyCoins
+		}
 	}
 
-	return res, res.FillCardsFromFileIfTableEmpty()
+	return amount.Zero
 }
 
-func (s *Storage) FillCardsFromFileIfTableEmpty() (err error) {
+// New creates the storage selected by the driver in the config and loads the card catalog
+// from the cards path of the game variables. Postgres is used by default.
+func New(lgr *zap.Logger, cfg *config.Storage, gv *config.GameVariables) (_ Storage, err error) {
 	var (
-		cards []storageModel.Card
-		fb    []byte
-		res   *gorm.DB
-		file  *os.File
+		str       *Database
+		dialector gorm.Dialector
 	)
 
-	if res = s.str.Table("cards").Find(&cards); res.Error != nil {
-		return res.Error
+	switch cfg.Driver {
+	case DriverMemory:
+		var cards []storageModel.Card
+
+		if cards, err = ReadCardsFile(gv.CardsPath); err != nil {
+			return nil, err
+		}
+
+		if err = ValidateCards(cards); err != nil {
+			return nil, err
+		}
+
+		return NewMemory(lgr, cards), nil
+	case DriverPostgres, "":
+		dialector = postgres.Open(postgresDSN(cfg))
+	case DriverSQLite:
+		dialector = sqlite.Open(sqliteDSN(cfg))
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %q", cfg.Driver)
 	}
 
-	if len(cards) > 0 {
-		return nil
+	if str, err = NewDatabase(lgr, dialector, cfg); err != nil {
+		return nil, err
 	}
 
-	s.lgr.Debug("filling cards")
+	return str, str.SyncCardsFromFile(gv.CardsPath, gv.RetireRemovedCards)
+}
+
+// ReadCardsFile reads the card catalog from the json file.
+func ReadCardsFile(path string) (cards []storageModel.Card, err error) {
+	var (
+		fb   []byte
+		file *os.File
+	)
 
-	if file, err = os.Open("cards.json"); err != nil {
-		return err
+	if file, err = os.Open(path); err != nil {
+		return nil, err
 	}
 
 	defer func() { _ = file.Close() }()
 
 	if fb, err = io.ReadAll(file); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err = json.Unmarshal(fb, &cards); err != nil {
-		return err
+		return nil, err
 	}
 
+	return cards, nil
+}
+
+// ValidateCards checks that every card of the catalog has a unique id and valid formulas.
+func ValidateCards(cards []storageModel.Card) error {
+	ids := make(map[uint64]bool, len(cards))
+
 	for _, card := range cards {
-		if res = s.str.Table("cards").Create(&card); res.Error != nil {
-			return res.Error
+		if card.ID == 0 {
+			return fmt.Errorf("card %q: id is required", card.Name)
 		}
+
+		if ids[card.ID] {
+			return fmt.Errorf("card %d: duplicated id", card.ID)
+		}
+
+		if _, err := math.CoinsFormula(&card); err != nil {
+			return fmt.Errorf("card %d: coins_formula: %w", card.ID, err)
+		}
+
+		if _, err := math.PriceFormula(&card); err != nil {
+			return fmt.Errorf("card %d: price_formula: %w", card.ID, err)
+		}
+
+		ids[card.ID] = true
 	}
 
 	return nil
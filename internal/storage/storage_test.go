@@ -0,0 +1,976 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	sqlite "github.com/glebarez/sqlite"
+	zap "go.uber.org/zap"
+	postgres "gorm.io/driver/postgres"
+	gorm "gorm.io/gorm"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	config "github.com/adzpm/telegram-clicker/internal/config"
+	math "github.com/adzpm/telegram-clicker/internal/math"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+const envTestPostgresDSN = "CLICKER_TEST_POSTGRES_DSN"
+
+var testCards = []storageModel.Card{
+	{ID: 1, Name: "Card 1", Price: amount.New(5), PriceMultiplier: 1.25, CoinsPerClick: amount.New(1), ClickTimeout: 10, UpgradeLevel: 50, MaxLevel: 1000},
+	{ID: 2, Name: "Card 2", Price: amount.New(60), PriceMultiplier: 1.25, CoinsPerClick: amount.New(30), ClickTimeout: 20, UpgradeLevel: 50, MaxLevel: 1000},
+}
+
+type newStorageFunc func(t *testing.T, cards []storageModel.Card) Storage
+
+func TestMemory(t *testing.T) {
+	testStorage(t, func(t *testing.T, cards []storageModel.Card) Storage {
+		return NewMemory(zap.NewNop(), cards)
+	})
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv(envTestPostgresDSN)
+	if dsn == "" {
+		t.Skipf("%s is not set", envTestPostgresDSN)
+	}
+
+	testStorage(t, func(t *testing.T, cards []storageModel.Card) Storage {
+		return newTestDatabase(t, postgres.Open(dsn), cards)
+	})
+}
+
+func TestSQLite(t *testing.T) {
+	testStorage(t, func(t *testing.T, cards []storageModel.Card) Storage {
+		return newTestDatabase(t, sqlite.Open(sqliteDSN(&config.Storage{Path: filepath.Join(t.TempDir(), "clicker.db")})), cards)
+	})
+}
+
+func TestSQLiteMigrateAndSyncCards(t *testing.T) {
+	var (
+		cfg = &config.Storage{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "clicker.db")}
+		gv  = &config.GameVariables{CardsPath: "../../cards.json"}
+		str Storage
+		err error
+	)
+
+	expected, err := ReadCardsFile(gv.CardsPath)
+	mustNoError(t, err)
+
+	// the second run must neither fail on existing tables nor duplicate the cards
+	for i := 0; i < 2; i++ {
+		str, err = New(zap.NewNop(), cfg, gv)
+		mustNoError(t, err)
+
+		cards, err := str.SelectCards()
+		mustNoError(t, err)
+		mustEqual(t, "cards", len(expected), len(cards))
+
+		for i := range expected {
+			mustEqual(t, "card", expected[i], cards[i])
+		}
+	}
+}
+
+// newTestDatabase recreates the schema of the database and fills it with the given cards.
+func newTestDatabase(t *testing.T, dialector gorm.Dialector, cards []storageModel.Card) *Database {
+	t.Helper()
+
+	str, err := NewDatabase(zap.NewNop(), dialector, &config.Storage{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = str.str.Migrator().DropTable(migrate...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = str.str.AutoMigrate(migrate...); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = str.fillCards(cards); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return str
+}
+
+// fillCards inserts the cards into the empty cards table of the test database.
+func (s *Database) fillCards(cards []storageModel.Card) (err error) {
+	defer s.InvalidateCards()
+
+	for _, card := range cards {
+		if res := s.str.Table("cards").Create(&card); res.Error != nil {
+			return res.Error
+		}
+	}
+
+	return nil
+}
+
+// testStorage is the conformance suite every storage implementation must pass.
+func testStorage(t *testing.T, newStorage newStorageFunc) {
+	t.Run("users", func(t *testing.T) { testUsers(t, newStorage(t, testCards)) })
+	t.Run("duplicates", func(t *testing.T) { testDuplicates(t, newStorage(t, testCards)) })
+	t.Run("ranking", func(t *testing.T) { testRanking(t, newStorage(t, testCards)) })
+	t.Run("user cards", func(t *testing.T) { testUserCards(t, newStorage(t, testCards)) })
+	t.Run("cards", func(t *testing.T) { testSelectCards(t, newStorage(t, testCards)) })
+	t.Run("sync cards", func(t *testing.T) { testSyncCards(t, newStorage(t, testCards)) })
+	t.Run("click", func(t *testing.T) { testClick(t, newStorage(t, testCards)) })
+	t.Run("buy", func(t *testing.T) { testBuy(t, newStorage(t, testCards)) })
+	t.Run("amounts", func(t *testing.T) { testAmounts(t, newStorage(t, testCards)) })
+	t.Run("prestige", func(t *testing.T) { testPrestige(t, newStorage(t, testCards)) })
+	t.Run("earnings", func(t *testing.T) { testEarnings(t, newStorage(t, testCards)) })
+	t.Run("referrals", func(t *testing.T) { testReferrals(t, newStorage(t, testCards)) })
+	t.Run("purchase", func(t *testing.T) { testPurchase(t, newStorage(t, testCards)) })
+	t.Run("tasks", func(t *testing.T) { testTasks(t, newStorage(t, testCards)) })
+	t.Run("concurrent clicks", func(t *testing.T) { testConcurrentClicks(t, newStorage(t, testCards)) })
+	t.Run("concurrent buys", func(t *testing.T) { testConcurrentBuys(t, newStorage(t, testCards)) })
+	t.Run("concurrent enters", func(t *testing.T) { testConcurrentEnters(t, newStorage(t, testCards)) })
+}
+
+func mustNoError(t testing.TB, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func mustEqual[T comparable](t *testing.T, field string, expected, result T) {
+	t.Helper()
+
+	if expected != result {
+		t.Errorf("%s: expected %v, got %v", field, expected, result)
+	}
+}
+
+func testUsers(t *testing.T, str Storage) {
+	user, err := str.InsertUser(42, amount.New(10), 1000, 2)
+	mustNoError(t, err)
+	mustEqual(t, "telegram_id", 42, user.TelegramID)
+	mustEqual(t, "coins", amount.New(10), user.Coins)
+	mustEqual(t, "earned_coins", amount.New(10), user.EarnedCoins)
+	mustEqual(t, "gold", 1000, user.Gold)
+	mustEqual(t, "investors", 2, user.Investors)
+
+	if _, err = str.SelectUser(43); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	_, err = str.InsertUser(43, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	user, err = str.UpdateUserCoins(42, amount.New(11))
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(11), user.Coins)
+
+	user, err = str.UpdateUserGold(42, 12)
+	mustNoError(t, err)
+	mustEqual(t, "gold", 12, user.Gold)
+
+	user, err = str.UpdateUserInvestors(42, 13)
+	mustNoError(t, err)
+	mustEqual(t, "investors", 13, user.Investors)
+
+	user, err = str.UpdateUserEarnedCoins(42, amount.New(14))
+	mustNoError(t, err)
+	mustEqual(t, "earned_coins", amount.New(14), user.EarnedCoins)
+
+	user, err = str.UpdateUserLastSeen(42, 15)
+	mustNoError(t, err)
+	mustEqual(t, "last_seen", 15, user.LastSeen)
+
+	user, err = str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "user", storageModel.User{ID: user.ID, TelegramID: 42, LastSeen: 15, Coins: amount.New(11), EarnedCoins: amount.New(14), Gold: 12, Investors: 13, Version: 5, LifetimeCoins: amount.New(10)}, *user)
+
+	if _, err = str.UpdateUserCoins(44, amount.New(1)); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	users, err := str.SelectUsers()
+	mustNoError(t, err)
+	mustEqual(t, "users", 2, len(users))
+}
+
+func testDuplicates(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	if _, err = str.InsertUser(42, amount.New(10), 0, 0); !errors.Is(err, ErrDuplicatedKey) {
+		t.Errorf("expected ErrDuplicatedKey, got %v", err)
+	}
+
+	_, err = str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+
+	if _, err = str.InsertUserCard(42, 1, 2); !errors.Is(err, ErrDuplicatedKey) {
+		t.Errorf("expected ErrDuplicatedKey, got %v", err)
+	}
+
+	// the first rows are kept
+	user, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.Zero, user.Coins)
+
+	userCard, err := str.SelectUserCard(42, 1)
+	mustNoError(t, err)
+	mustEqual(t, "level", 1, userCard.Level)
+}
+
+// testRanking inserts the users out of the telegram ids order, so the ties are ordered by the insertion.
+func testRanking(t *testing.T, str Storage) {
+	const day = 86400
+
+	for _, user := range []struct {
+		telegramID uint64
+		investors  uint64
+		coins      uint64
+		day        uint64
+	}{
+		{3, 1, 100, 5},
+		{1, 0, 100, 5},
+		{2, 2, 300, 4},
+		{4, 0, 0, 5},
+	} {
+		_, err := str.InsertUser(user.telegramID, amount.Zero, 0, user.investors)
+		mustNoError(t, err)
+
+		_, err = str.EarnCoins(user.telegramID, amount.New(user.coins), user.day*day)
+		mustNoError(t, err)
+	}
+
+	testCases := map[string]struct {
+		ranking Ranking
+		day     uint64
+		limit   uint64
+		ranked  []uint64
+	}{
+		"lifetime coins":     {RankingLifetimeCoins, 5, 10, []uint64{2, 3, 1}},
+		"limited":            {RankingLifetimeCoins, 5, 2, []uint64{2, 3}},
+		"investors":          {RankingInvestors, 5, 10, []uint64{2, 3}},
+		"daily coins":        {RankingDailyCoins, 5, 10, []uint64{3, 1}},
+		"daily coins before": {RankingDailyCoins, 4, 10, []uint64{2}},
+		"nobody":             {RankingDailyCoins, 6, 10, []uint64{}},
+	}
+
+	for name, tc := range testCases {
+		users, err := str.SelectRanked(tc.ranking, tc.day, tc.limit)
+		mustNoError(t, err)
+
+		ranked := make([]uint64, 0, len(users))
+		for _, user := range users {
+			ranked = append(ranked, user.TelegramID)
+		}
+
+		if !reflect.DeepEqual(tc.ranked, ranked) {
+			t.Errorf("%s: expected %v, got %v", name, tc.ranked, ranked)
+		}
+	}
+
+	for _, tc := range []struct {
+		ranking Ranking
+		day     uint64
+		above   uint64
+		count   uint64
+	}{
+		{RankingLifetimeCoins, 5, 0, 3},
+		{RankingLifetimeCoins, 5, 100, 1},
+		{RankingLifetimeCoins, 5, 300, 0},
+		{RankingInvestors, 5, 1, 1},
+		{RankingDailyCoins, 5, 0, 2},
+		{RankingDailyCoins, 5, 99, 2},
+	} {
+		count, err := str.CountRanked(tc.ranking, tc.day, amount.New(tc.above))
+		mustNoError(t, err)
+		mustEqual(t, fmt.Sprintf("%s above %d", tc.ranking, tc.above), tc.count, count)
+	}
+}
+
+func testUserCards(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	userCard, err := str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+	mustEqual(t, "card_id", 1, userCard.CardID)
+	mustEqual(t, "level", 1, userCard.Level)
+
+	if _, err = str.SelectUserCard(42, 2); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	_, err = str.InsertUserCard(42, 2, 3)
+	mustNoError(t, err)
+
+	userCard, err = str.UpdateUserCardLevel(42, 1, 5)
+	mustNoError(t, err)
+	mustEqual(t, "level", 5, userCard.Level)
+
+	userCard, err = str.UpdateUserCardNextClick(42, 1, 6)
+	mustNoError(t, err)
+	mustEqual(t, "next_click", 6, userCard.NextClick)
+
+	userCard, err = str.UpdateUserCardLastClick(42, 1, 7)
+	mustNoError(t, err)
+	mustEqual(t, "last_click", 7, userCard.LastClick)
+
+	userCard, err = str.SelectUserCard(42, 1)
+	mustNoError(t, err)
+	mustEqual(t, "user card", storageModel.UserCard{ID: userCard.ID, TelegramID: 42, CardID: 1, Level: 5, NextClick: 6, LastClick: 7}, *userCard)
+
+	userCards, err := str.SelectUserCards(42)
+	mustNoError(t, err)
+	mustEqual(t, "user cards", 2, len(userCards))
+
+	userCards, err = str.SelectUserCards(43)
+	mustNoError(t, err)
+	mustEqual(t, "user cards", 0, len(userCards))
+}
+
+func testSelectCards(t *testing.T, str Storage) {
+	cards, err := str.SelectCards()
+	mustNoError(t, err)
+	mustEqual(t, "cards", len(testCards), len(cards))
+
+	card, err := str.SelectCard(2)
+	mustNoError(t, err)
+	mustEqual(t, "card", testCards[1], *card)
+
+	if _, err = str.SelectCard(100); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func testSyncCards(t *testing.T, str Storage) {
+	changed := testCards[0]
+	changed.Price = amount.New(7)
+	changed.ClickTimeout = 5
+
+	added := storageModel.Card{
+		ID: 3, Name: "Card 3", Price: amount.New(100), PriceMultiplier: 1.5, CoinsPerClick: amount.New(50), ClickTimeout: 30,
+		CoinsFormula: &storageModel.Formula{Type: math.FormulaPolynomial, Exponent: 1.5},
+		PriceFormula: &storageModel.Formula{Type: math.FormulaPiecewise, Pieces: []storageModel.FormulaPiece{
+			{FromLevel: 1, Formula: storageModel.Formula{Type: math.FormulaLinear}},
+			{FromLevel: 10, Formula: storageModel.Formula{Type: math.FormulaGeometric, Multiplier: 1.2}},
+		}},
+	}
+
+	// card 2 is removed from the catalog but kept without retiring
+	sync, err := str.SyncCards([]storageModel.Card{changed, added}, false)
+	mustNoError(t, err)
+	mustEqual(t, "inserted", 1, len(sync.Inserted))
+	mustEqual(t, "updated", 1, len(sync.Updated))
+	mustEqual(t, "retired", 0, len(sync.Retired))
+
+	cards, err := str.SelectCards()
+	mustNoError(t, err)
+	mustEqual(t, "cards", 3, len(cards))
+	mustEqual(t, "card", changed, cards[0])
+
+	if !reflect.DeepEqual(added, cards[2]) {
+		t.Errorf("card: expected %+v, got %+v", added, cards[2])
+	}
+
+	sync, err = str.SyncCards([]storageModel.Card{changed, added}, true)
+	mustNoError(t, err)
+	mustEqual(t, "inserted", 0, len(sync.Inserted))
+	mustEqual(t, "updated", 0, len(sync.Updated))
+	mustEqual(t, "retired", 1, len(sync.Retired))
+	mustEqual(t, "retired id", 2, sync.Retired[0])
+
+	cards, err = str.SelectCards()
+	mustNoError(t, err)
+	mustEqual(t, "cards", 2, len(cards))
+
+	if _, err = str.SelectCard(2); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	// the retired card comes back with the catalog
+	sync, err = str.SyncCards([]storageModel.Card{testCards[0], testCards[1], added}, true)
+	mustNoError(t, err)
+	mustEqual(t, "updated", 2, len(sync.Updated))
+	mustEqual(t, "retired", 0, len(sync.Retired))
+
+	cards, err = str.SelectCards()
+	mustNoError(t, err)
+	mustEqual(t, "cards", 3, len(cards))
+	mustEqual(t, "card", testCards[1], cards[1])
+
+	if _, err = str.SyncCards([]storageModel.Card{added, added}, true); err == nil {
+		t.Errorf("expected duplicated id error")
+	}
+
+	invalid := added
+	invalid.PriceFormula = &storageModel.Formula{Type: math.FormulaGeometric}
+
+	if _, err = str.SyncCards([]storageModel.Card{invalid}, true); err == nil {
+		t.Errorf("expected invalid formula error")
+	}
+}
+
+func testClick(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	_, err = str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+
+	clickFn := func(_ *storageModel.User, card *storageModel.Card, _ *storageModel.UserCard) (amount.Amount, uint64) {
+		return amount.New(7), card.ClickTimeout
+	}
+
+	user, userCard, err := str.Click(42, 1, 100, false, clickFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(7), user.Coins)
+	mustEqual(t, "earned_coins", amount.New(7), user.EarnedCoins)
+	mustEqual(t, "last_seen", 100, user.LastSeen)
+	mustEqual(t, "last_click", 100, userCard.LastClick)
+	mustEqual(t, "next_click", 110, userCard.NextClick)
+
+	if _, _, err = str.Click(42, 1, 109, false, clickFn); !errors.Is(err, ErrCantClickNow) {
+		t.Errorf("expected ErrCantClickNow, got %v", err)
+	}
+
+	user, err = str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(7), user.Coins)
+
+	user, _, err = str.Click(42, 1, 110, false, clickFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(14), user.Coins)
+	mustEqual(t, "clicks", 2, user.Clicks)
+	mustEqual(t, "version", 2, user.Version)
+
+	if _, _, err = str.Click(42, 2, 110, false, clickFn); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	// the cards on the level 0 can't be clicked and the clicks aren't counted
+	_, err = str.InsertUserCard(42, 2, 0)
+	mustNoError(t, err)
+
+	if _, _, err = str.Click(42, 2, 110, true, clickFn); !errors.Is(err, ErrCardNotBought) {
+		t.Errorf("expected ErrCardNotBought, got %v", err)
+	}
+
+	user, err = str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "clicks", 2, user.Clicks)
+	mustEqual(t, "version", 2, user.Version)
+}
+
+func testBuy(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.New(100), 0, 0)
+	mustNoError(t, err)
+
+	priceFn := func(_ *storageModel.User, card *storageModel.Card, _ *storageModel.UserCard) (uint64, amount.Amount, error) {
+		return 1, card.Price, nil
+	}
+
+	user, userCard, err := str.Buy(42, 2, priceFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(40), user.Coins)
+	mustEqual(t, "level", 1, userCard.Level)
+
+	if _, _, err = str.Buy(42, 2, priceFn); !errors.Is(err, ErrNotEnoughCoins) {
+		t.Errorf("expected ErrNotEnoughCoins, got %v", err)
+	}
+
+	user, userCard, err = str.Buy(42, 1, priceFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(35), user.Coins)
+	mustEqual(t, "level", 1, userCard.Level)
+
+	user, userCard, err = str.Buy(42, 1, priceFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(30), user.Coins)
+	mustEqual(t, "level", 2, userCard.Level)
+
+	user, userCard, err = str.Buy(42, 1, func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (uint64, amount.Amount, error) {
+		return 3, amount.New(30), nil
+	})
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.Zero, user.Coins)
+	mustEqual(t, "level", 5, userCard.Level)
+
+	if _, _, err = str.Buy(42, 1, func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (uint64, amount.Amount, error) {
+		return 0, amount.Zero, ErrMaxLevelReached
+	}); !errors.Is(err, ErrMaxLevelReached) {
+		t.Errorf("expected ErrMaxLevelReached, got %v", err)
+	}
+
+	userCard, err = str.SelectUserCard(42, 1)
+	mustNoError(t, err)
+	mustEqual(t, "level", 5, userCard.Level)
+
+	userCard, err = str.SelectUserCard(42, 2)
+	mustNoError(t, err)
+	mustEqual(t, "level", 1, userCard.Level)
+}
+
+func testAmounts(t *testing.T, str Storage) {
+	big, err := amount.Parse("1000000000000000000000000000000")
+	mustNoError(t, err)
+
+	user, err := str.InsertUser(42, big, 0, 0)
+	mustNoError(t, err)
+	mustEqual(t, "coins", big, user.Coins)
+
+	_, err = str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+
+	// the coins saturate instead of wrapping
+	user, _, err = str.Click(42, 1, 100, false, func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (amount.Amount, uint64) {
+		return amount.Max, 10
+	})
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.Max, user.Coins)
+	mustEqual(t, "earned_coins", amount.Max, user.EarnedCoins)
+
+	user, _, err = str.Buy(42, 2, func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (uint64, amount.Amount, error) {
+		return 1, big, nil
+	})
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.Max.Sub(big), user.Coins)
+
+	if _, _, err = str.Buy(42, 2, func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (uint64, amount.Amount, error) {
+		return 1, amount.Max, nil
+	}); !errors.Is(err, ErrNotEnoughCoins) {
+		t.Errorf("expected ErrNotEnoughCoins, got %v", err)
+	}
+
+	user, err = str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.Max.Sub(big), user.Coins)
+	mustEqual(t, "earned_coins", amount.Max, user.EarnedCoins)
+
+	mustNoError(t, str.ApplyClicks([]ClickBatch{{TelegramID: 42, Coins: big.Add(big), Clicks: 1, LastSeen: 200}}))
+
+	user, err = str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.Max, user.Coins)
+}
+
+func testPrestige(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.New(100), 0, 1)
+	mustNoError(t, err)
+
+	_, err = str.InsertUserCard(42, 1, 10)
+	mustNoError(t, err)
+
+	_, err = str.InsertUserCard(42, 2, 5)
+	mustNoError(t, err)
+
+	_, err = str.UpdateUserCardNextClick(42, 2, 500)
+	mustNoError(t, err)
+
+	user, userCards, err := str.Prestige(42, 1, func(user *storageModel.User, userCards []storageModel.UserCard) (uint64, map[uint64]uint64) {
+		return user.Investors + 3, map[uint64]uint64{1: uint64(len(userCards))}
+	})
+	mustNoError(t, err)
+	mustEqual(t, "investors", 4, user.Investors)
+	mustEqual(t, "prestiges", 1, user.Prestiges)
+	mustEqual(t, "coins", amount.Zero, user.Coins)
+	mustEqual(t, "earned_coins", amount.Zero, user.EarnedCoins)
+	mustEqual(t, "user cards", 2, len(userCards))
+
+	for _, userCard := range userCards {
+		expected := storageModel.UserCard{ID: userCard.ID, TelegramID: 42, CardID: userCard.CardID}
+		if userCard.CardID == 1 {
+			expected.Level = 1
+		}
+
+		mustEqual(t, "user card", expected, userCard)
+	}
+
+	// the progress is calculated from the cards before the reset
+	userTasks, err := str.SelectUserTasks(42)
+	mustNoError(t, err)
+	mustEqual(t, "user tasks", 1, len(userTasks))
+	mustEqual(t, "progress", 2, userTasks[0].Progress)
+}
+
+func testEarnings(t *testing.T, str Storage) {
+	const day = secondsPerDay
+
+	_, err := str.InsertUser(42, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	_, err = str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+
+	clickFn := func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (amount.Amount, uint64) {
+		return amount.New(5), 1
+	}
+
+	_, _, err = str.Click(42, 1, 10*day+100, false, clickFn)
+	mustNoError(t, err)
+
+	user, err := str.EarnCoins(42, amount.New(20), 10*day+200)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(25), user.Coins)
+	mustEqual(t, "earned_coins", amount.New(25), user.EarnedCoins)
+	mustEqual(t, "daily_coins", amount.New(25), user.DailyCoins)
+	mustEqual(t, "daily_coins_day", 10, user.DailyCoinsDay)
+
+	user, _, err = str.Prestige(42, 1, func(*storageModel.User, []storageModel.UserCard) (uint64, map[uint64]uint64) {
+		return 1, nil
+	})
+	mustNoError(t, err)
+	mustEqual(t, "earned_coins", amount.Zero, user.EarnedCoins)
+	mustEqual(t, "lifetime_coins", amount.New(25), user.LifetimeCoins)
+	mustEqual(t, "daily_coins", amount.New(25), user.DailyCoins)
+
+	// the daily coins start over the next day
+	err = str.ApplyClicks([]ClickBatch{{TelegramID: 42, Coins: amount.New(7), Clicks: 1, LastSeen: 11*day + 1}})
+	mustNoError(t, err)
+
+	user, _, err = str.ClaimTask(42, 1, 11*day+2, func(*storageModel.User, *storageModel.UserTask) (amount.Amount, uint64, error) {
+		return amount.New(3), 0, nil
+	})
+	mustNoError(t, err)
+	mustEqual(t, "earned_coins", amount.New(10), user.EarnedCoins)
+	mustEqual(t, "lifetime_coins", amount.New(35), user.LifetimeCoins)
+	mustEqual(t, "daily_coins", amount.New(10), user.DailyCoins)
+	mustEqual(t, "daily_coins_day", 11, user.DailyCoinsDay)
+
+	if _, err = str.EarnCoins(43, amount.New(1), 0); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func testReferrals(t *testing.T, str Storage) {
+	var (
+		bonus   = ReferralBonus{ReferrerCoins: amount.New(100), ReferrerGold: 5, RefereeCoins: amount.New(50), RefereeGold: 1}
+		shareFn = func(_ *storageModel.User, coins amount.Amount) amount.Amount { return coins.Div(10) }
+	)
+
+	for _, telegramID := range []uint64{3, 1} {
+		_, err := str.InsertUser(telegramID, amount.Zero, 0, 0)
+		mustNoError(t, err)
+	}
+
+	_, err := str.InsertUser(2, amount.New(10), 0, 0)
+	mustNoError(t, err)
+
+	_, err = str.Refer(1, 3, 100, bonus)
+	mustNoError(t, err)
+
+	user, err := str.Refer(2, 1, 100, bonus)
+	mustNoError(t, err)
+	mustEqual(t, "referred_by", 1, user.ReferredBy)
+	mustEqual(t, "coins", amount.New(60), user.Coins)
+	mustEqual(t, "gold", 1, user.Gold)
+	mustEqual(t, "referral_coins", amount.New(100), user.ReferralCoins)
+
+	user, err = str.SelectUser(1)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(150), user.Coins)
+	mustEqual(t, "lifetime_coins", amount.New(150), user.LifetimeCoins)
+	mustEqual(t, "gold", 6, user.Gold)
+
+	for _, tc := range []struct {
+		telegramID, referrerID uint64
+		expected               error
+	}{
+		{2, 1, ErrCantRefer},
+		{1, 1, ErrCantRefer},
+		{3, 1, ErrCantRefer},
+		{4, 1, ErrRecordNotFound},
+		{3, 4, ErrRecordNotFound},
+	} {
+		if _, err = str.Refer(tc.telegramID, tc.referrerID, 100, bonus); !errors.Is(err, tc.expected) {
+			t.Errorf("referring %d by %d: expected %v, got %v", tc.telegramID, tc.referrerID, tc.expected, err)
+		}
+	}
+
+	// the coins the referee earned before the referral and the bonus aren't shared
+	_, err = str.EarnCoins(2, amount.New(1000), 200)
+	mustNoError(t, err)
+
+	user, referees, err := str.CollectReferralRewards(1, 300, shareFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(250), user.Coins)
+	mustEqual(t, "referees", 1, len(referees))
+	mustEqual(t, "referee", 2, referees[0].TelegramID)
+	mustEqual(t, "referral_coins", amount.New(200), referees[0].ReferralCoins)
+
+	referees, err = str.SelectReferees(1)
+	mustNoError(t, err)
+	mustEqual(t, "referees", 1, len(referees))
+	mustEqual(t, "referral_coins", amount.New(200), referees[0].ReferralCoins)
+	mustEqual(t, "referral_settled_coins", amount.New(1060), referees[0].ReferralSettledCoins)
+
+	// the referral rewards of the user aren't shared with the referrer of the user
+	user, referees, err = str.CollectReferralRewards(3, 300, shareFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(100), user.Coins)
+	mustEqual(t, "referral_coins", amount.New(100), referees[0].ReferralCoins)
+
+	// the coins too few for a share are kept for the next collection
+	_, err = str.EarnCoins(2, amount.New(5), 400)
+	mustNoError(t, err)
+
+	user, _, err = str.CollectReferralRewards(1, 500, shareFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(250), user.Coins)
+
+	_, err = str.EarnCoins(2, amount.New(5), 600)
+	mustNoError(t, err)
+
+	user, referees, err = str.CollectReferralRewards(1, 700, shareFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(251), user.Coins)
+	mustEqual(t, "referral_coins", amount.New(201), referees[0].ReferralCoins)
+
+	if _, _, err = str.CollectReferralRewards(4, 700, shareFn); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	referees, err = str.SelectReferees(2)
+	mustNoError(t, err)
+	mustEqual(t, "referees", 0, len(referees))
+}
+
+func testPurchase(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.Zero, 100, 0)
+	mustNoError(t, err)
+
+	_, err = str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+
+	_, err = str.UpdateUserCardNextClick(42, 1, 500)
+	mustNoError(t, err)
+
+	if _, err = str.Purchase(&storageModel.UserPurchase{TelegramID: 42, ItemID: 1, Price: 101}); !errors.Is(err, ErrNotEnoughGold) {
+		t.Errorf("expected ErrNotEnoughGold, got %v", err)
+	}
+
+	user, err := str.Purchase(&storageModel.UserPurchase{TelegramID: 42, ItemID: 1, Price: 10, Coins: amount.New(50), RefreshClicks: true})
+	mustNoError(t, err)
+	mustEqual(t, "gold", 90, user.Gold)
+	mustEqual(t, "coins", amount.New(50), user.Coins)
+
+	userCard, err := str.SelectUserCard(42, 1)
+	mustNoError(t, err)
+	mustEqual(t, "next_click", 0, userCard.NextClick)
+
+	_, err = str.Purchase(&storageModel.UserPurchase{TelegramID: 42, ItemID: 2, Price: 20, CoinsMultiplier: 2, CreatedAt: 100, ExpiresAt: 200})
+	mustNoError(t, err)
+
+	_, err = str.Purchase(&storageModel.UserPurchase{TelegramID: 42, ItemID: 3, Price: 30, SkipTimeouts: 1})
+	mustNoError(t, err)
+
+	purchases, err := str.SelectUserPurchases(42, 150)
+	mustNoError(t, err)
+	mustEqual(t, "active purchases", 2, len(purchases))
+
+	purchases, err = str.SelectUserPurchases(42, 200)
+	mustNoError(t, err)
+	mustEqual(t, "active purchases", 1, len(purchases))
+
+	clickFn := func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (amount.Amount, uint64) {
+		return amount.New(1), 10
+	}
+
+	_, _, err = str.Click(42, 1, 100, false, clickFn)
+	mustNoError(t, err)
+
+	if _, _, err = str.Click(42, 1, 105, false, clickFn); !errors.Is(err, ErrCantClickNow) {
+		t.Errorf("expected ErrCantClickNow, got %v", err)
+	}
+
+	user, userCard, err = str.Click(42, 1, 105, true, clickFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(52), user.Coins)
+	mustEqual(t, "next_click", 115, userCard.NextClick)
+
+	if _, _, err = str.Click(42, 1, 106, true, clickFn); !errors.Is(err, ErrCantClickNow) {
+		t.Errorf("expected ErrCantClickNow, got %v", err)
+	}
+
+	purchases, err = str.SelectUserPurchases(42, 200)
+	mustNoError(t, err)
+	mustEqual(t, "active purchases", 0, len(purchases))
+}
+
+func testTasks(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	userTask, err := str.UpdateUserTaskProgress(42, 2, 5)
+	mustNoError(t, err)
+	mustEqual(t, "progress", 5, userTask.Progress)
+
+	userTask, err = str.UpdateUserTaskProgress(42, 2, 3)
+	mustNoError(t, err)
+	mustEqual(t, "progress", 5, userTask.Progress)
+
+	_, err = str.UpdateUserTaskProgress(42, 1, 1)
+	mustNoError(t, err)
+
+	userTasks, err := str.SelectUserTasks(42)
+	mustNoError(t, err)
+	mustEqual(t, "user tasks", 2, len(userTasks))
+	mustEqual(t, "task id", 1, userTasks[0].TaskID)
+
+	claimFn := func(_ *storageModel.User, userTask *storageModel.UserTask) (amount.Amount, uint64, error) {
+		if userTask.Progress < 5 {
+			return amount.Zero, 0, ErrTaskNotDone
+		}
+
+		return amount.New(10), 20, nil
+	}
+
+	if _, _, err = str.ClaimTask(42, 1, 100, claimFn); !errors.Is(err, ErrTaskNotDone) {
+		t.Errorf("expected ErrTaskNotDone, got %v", err)
+	}
+
+	if _, _, err = str.ClaimTask(42, 3, 100, claimFn); !errors.Is(err, ErrTaskNotDone) {
+		t.Errorf("expected ErrTaskNotDone, got %v", err)
+	}
+
+	user, userTask, err := str.ClaimTask(42, 2, 100, claimFn)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(10), user.Coins)
+	mustEqual(t, "earned_coins", amount.New(10), user.EarnedCoins)
+	mustEqual(t, "gold", 20, user.Gold)
+	mustEqual(t, "claimed", true, userTask.Claimed)
+
+	if _, _, err = str.ClaimTask(42, 2, 100, claimFn); !errors.Is(err, ErrTaskClaimed) {
+		t.Errorf("expected ErrTaskClaimed, got %v", err)
+	}
+
+	userTasks, err = str.SelectUserTasks(42)
+	mustNoError(t, err)
+	mustEqual(t, "user tasks", 2, len(userTasks))
+	mustEqual(t, "claimed", true, userTasks[1].Claimed)
+}
+
+func testConcurrentClicks(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	_, err = str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, _, err := str.Click(42, 1, 100, false, func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (amount.Amount, uint64) {
+				return amount.New(1), 10
+			})
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrCantClickNow) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	mustEqual(t, "succeeded clicks", 1, succeeded)
+
+	user, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(1), user.Coins)
+}
+
+func testConcurrentEnters(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.Zero, 0, 0)
+	mustNoError(t, err)
+
+	_, err = str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+
+	_, err = str.UpdateUserLastSeen(42, 1000)
+	mustNoError(t, err)
+
+	var wg sync.WaitGroup
+
+	// every enter earns a coin per second since the last seen time, which only the first one finds behind
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, userCards, err := str.Enter(42, 1100, func(user *storageModel.User, userCards []storageModel.UserCard) amount.Amount {
+				return amount.New(1100 - user.LastSeen)
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if len(userCards) != 1 {
+				t.Errorf("expected 1 user card, got %d", len(userCards))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	user, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.New(100), user.Coins)
+	mustEqual(t, "last_seen", 1100, user.LastSeen)
+
+	if _, _, err = str.Enter(43, 1100, func(*storageModel.User, []storageModel.UserCard) amount.Amount { return amount.Zero }); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func testConcurrentBuys(t *testing.T, str Storage) {
+	_, err := str.InsertUser(42, amount.New(30), 0, 0)
+	mustNoError(t, err)
+
+	_, err = str.InsertUserCard(42, 1, 1)
+	mustNoError(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, _, err := str.Buy(42, 1, func(*storageModel.User, *storageModel.Card, *storageModel.UserCard) (uint64, amount.Amount, error) {
+				return 1, amount.New(10), nil
+			})
+			if err != nil && !errors.Is(err, ErrNotEnoughCoins) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	user, err := str.SelectUser(42)
+	mustNoError(t, err)
+	mustEqual(t, "coins", amount.Zero, user.Coins)
+
+	userCard, err := str.SelectUserCard(42, 1)
+	mustNoError(t, err)
+	mustEqual(t, "level", 4, userCard.Level)
+}
@@ -0,0 +1,141 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+const (
+	// TypeEarnedCoins is done when the user earns Target coins before the reset.
+	TypeEarnedCoins = "earned_coins"
+	// TypeCardLevel is done when the user has the card CardID of Target level.
+	TypeCardLevel = "card_level"
+	// TypeClicks is done when the user clicks Target times.
+	TypeClicks = "clicks"
+	// TypePrestige is done when the user resets the game Target times.
+	TypePrestige = "prestige"
+	// TypeJoinChannel is done when the user is a member of the telegram Channel.
+	TypeJoinChannel = "join_channel"
+)
+
+type (
+	Task struct {
+		ID          uint64        `json:"id"`
+		Name        string        `json:"name"`
+		Description string        `json:"description"`
+		ImageURL    string        `json:"image_url"`
+		Type        string        `json:"type"`
+		Target      uint64        `json:"target"`
+		CardID      uint64        `json:"card_id"`
+		Channel     string        `json:"channel"`
+		RewardCoins amount.Amount `json:"reward_coins"`
+		RewardGold  uint64        `json:"reward_gold"`
+	}
+
+	Tasks struct {
+		tasks []Task
+		index map[uint64]*Task
+	}
+)
+
+// New creates a new Tasks with the given task definitions.
+func New(tasks []Task) (_ *Tasks, err error) {
+	t := &Tasks{
+		tasks: tasks,
+		index: make(map[uint64]*Task, len(tasks)),
+	}
+
+	for i := range t.tasks {
+		task := &t.tasks[i]
+
+		if task.Type == TypeJoinChannel {
+			task.Target = 1
+		}
+
+		if err = task.validate(); err != nil {
+			return nil, err
+		}
+
+		if _, ok := t.index[task.ID]; ok {
+			return nil, fmt.Errorf("task %d: duplicated id", task.ID)
+		}
+
+		t.index[task.ID] = task
+	}
+
+	return t, nil
+}
+
+// Read loads the task definitions from the json file.
+func Read(path string) (_ *Tasks, err error) {
+	var (
+		fb    []byte
+		tasks []Task
+	)
+
+	if fb, err = os.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(fb, &tasks); err != nil {
+		return nil, err
+	}
+
+	return New(tasks)
+}
+
+func (t *Task) validate() error {
+	switch {
+	case t.ID == 0:
+		return fmt.Errorf("task %q: id is required", t.Name)
+	case t.Target == 0:
+		return fmt.Errorf("task %d: target is required", t.ID)
+	case t.Type == TypeCardLevel && t.CardID == 0:
+		return fmt.Errorf("task %d: card level requires card id", t.ID)
+	case t.Type == TypeJoinChannel && t.Channel == "":
+		return fmt.Errorf("task %d: join channel requires channel", t.ID)
+	case t.Type == TypeEarnedCoins, t.Type == TypeCardLevel, t.Type == TypeClicks,
+		t.Type == TypePrestige, t.Type == TypeJoinChannel:
+		return nil
+	default:
+		return fmt.Errorf("task %d: unknown type %q", t.ID, t.Type)
+	}
+}
+
+// All returns all tasks.
+func (t *Tasks) All() []Task { return t.tasks }
+
+// Task returns the task by id.
+func (t *Tasks) Task(id uint64) (*Task, bool) {
+	task, ok := t.index[id]
+
+	return task, ok
+}
+
+// Progress returns the current progress of the task calculated from the user state.
+// The progress of TypeJoinChannel can't be calculated and is always 0.
+func (t *Task) Progress(user *storageModel.User, userCards []storageModel.UserCard) uint64 {
+	switch t.Type {
+	case TypeEarnedCoins:
+		return user.EarnedCoins.Uint64()
+	case TypeClicks:
+		return user.Clicks
+	case TypePrestige:
+		return user.Prestiges
+	case TypeCardLevel:
+		for _, userCard := range userCards {
+			if userCard.CardID == t.CardID {
+				return userCard.Level
+			}
+		}
+	}
+
+	return 0
+}
+
+// IsDone returns true if the progress reached the target of the task.
+func (t *Task) IsDone(progress uint64) bool { return progress >= t.Target }
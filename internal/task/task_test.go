@@ -0,0 +1,120 @@
+package task
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	amount "github.com/adzpm/telegram-clicker/internal/amount"
+	storageModel "github.com/adzpm/telegram-clicker/internal/model/storage"
+)
+
+func TestNew(t *testing.T) {
+	testCases := map[string]struct {
+		tasks   []Task
+		isError bool
+	}{
+		"valid":                        {[]Task{{ID: 1, Type: TypeClicks, Target: 10}, {ID: 2, Type: TypeJoinChannel, Channel: "@test"}}, false},
+		"empty id":                     {[]Task{{Type: TypeClicks, Target: 10}}, true},
+		"empty target":                 {[]Task{{ID: 1, Type: TypeClicks}}, true},
+		"duplicated id":                {[]Task{{ID: 1, Type: TypeClicks, Target: 1}, {ID: 1, Type: TypePrestige, Target: 1}}, true},
+		"unknown type":                 {[]Task{{ID: 1, Type: "unknown", Target: 1}}, true},
+		"card level without card id":   {[]Task{{ID: 1, Type: TypeCardLevel, Target: 1}}, true},
+		"join channel without channel": {[]Task{{ID: 1, Type: TypeJoinChannel}}, true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(tc.tasks); (err != nil) != tc.isError {
+				t.Errorf("expected error %t, got %v", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var (
+		user      = &storageModel.User{EarnedCoins: amount.New(100), Clicks: 20, Prestiges: 3}
+		userCards = []storageModel.UserCard{{CardID: 1, Level: 5}, {CardID: 2, Level: 7}}
+	)
+
+	testCases := map[string]struct {
+		task     Task
+		expected uint64
+	}{
+		"earned coins":    {Task{Type: TypeEarnedCoins}, 100},
+		"clicks":          {Task{Type: TypeClicks}, 20},
+		"prestige":        {Task{Type: TypePrestige}, 3},
+		"card level":      {Task{Type: TypeCardLevel, CardID: 2}, 7},
+		"card not bought": {Task{Type: TypeCardLevel, CardID: 3}, 0},
+		"join channel":    {Task{Type: TypeJoinChannel}, 0},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if result := tc.task.Progress(user, userCards); result != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestTelegramChecker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/getChatMember" || r.URL.Query().Get("chat_id") != "@test" {
+			_, _ = w.Write([]byte(`{"ok":false,"description":"Bad Request: chat not found"}`))
+
+			return
+		}
+
+		switch r.URL.Query().Get("user_id") {
+		case "1":
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"status":"member"}}`))
+		case "2":
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"status":"restricted","is_member":true}}`))
+		default:
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"status":"left"}}`))
+		}
+	}))
+
+	defer srv.Close()
+
+	testCases := map[string]struct {
+		channel    string
+		telegramID uint64
+		expected   bool
+		isError    bool
+	}{
+		"member":            {"@test", 1, true, false},
+		"restricted member": {"@test", 2, true, false},
+		"left":              {"@test", 3, false, false},
+		"unknown channel":   {"@unknown", 1, false, true},
+	}
+
+	checker := NewTelegramChecker(srv.URL, "token")
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, err := checker.IsMember(tc.channel, tc.telegramID)
+			if (err != nil) != tc.isError {
+				t.Fatalf("expected error %t, got %v", tc.isError, err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestTelegramCheckerUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	// the transport error doesn't leak the bot token from the url
+	_, err := NewTelegramChecker(srv.URL, "123:secret").IsMember("@test", 1)
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected error without the token, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// TelegramAPIURL is the address of the telegram bot api.
+const TelegramAPIURL = "https://api.telegram.org"
+
+type (
+	// ChannelChecker checks whether the user is a member of the telegram channel.
+	ChannelChecker interface {
+		IsMember(channel string, telegramID uint64) (bool, error)
+	}
+
+	// TelegramChecker checks the channel membership with the getChatMember method of the bot api.
+	// The bot must be an administrator of the channel.
+	TelegramChecker struct {
+		cli      *http.Client
+		apiURL   string
+		botToken string
+	}
+
+	chatMemberResponse struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Result      struct {
+			Status   string `json:"status"`
+			IsMember bool   `json:"is_member"`
+		} `json:"result"`
+	}
+)
+
+// NewTelegramChecker creates a new TelegramChecker for the bot api at apiURL.
+func NewTelegramChecker(apiURL, botToken string) *TelegramChecker {
+	return &TelegramChecker{
+		cli:      &http.Client{Timeout: 5 * time.Second},
+		apiURL:   apiURL,
+		botToken: botToken,
+	}
+}
+
+// IsMember reports whether the user is a member of the channel. The url of the request contains the bot token,
+// so the transport errors are returned without it.
+func (c *TelegramChecker) IsMember(channel string, telegramID uint64) (_ bool, err error) {
+	var (
+		res   *http.Response
+		uerr  *url.Error
+		query = url.Values{
+			"chat_id": {channel},
+			"user_id": {strconv.FormatUint(telegramID, 10)},
+		}
+		member = &chatMemberResponse{}
+	)
+
+	if res, err = c.cli.Get(c.apiURL + "/bot" + c.botToken + "/getChatMember?" + query.Encode()); err != nil {
+		if errors.As(err, &uerr) {
+			return false, fmt.Errorf("get chat member: %w", uerr.Err)
+		}
+
+		return false, err
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if err = json.NewDecoder(res.Body).Decode(member); err != nil {
+		return false, err
+	}
+
+	if !member.OK {
+		return false, fmt.Errorf("get chat member: %s", member.Description)
+	}
+
+	switch member.Result.Status {
+	case "creator", "administrator", "member":
+		return true, nil
+	case "restricted":
+		return member.Result.IsMember, nil
+	default:
+		return false, nil
+	}
+}
@@ -29,30 +29,81 @@ let Application = Vue.createApp({
             started_from_telegram: false,
             telegram_data: null,
             game_data: null,
+            shop_data: null,
+            tasks_data: null,
             error: null,
+            offline_earnings: null,
             percents: {},
+            socket: null,
+            socket_seq: 0,
+            socket_pending: {},
+            entering: false,
         }
     },
 
     methods: {
-        Enter(telegram_id) {
-            let url = this.CurrentAddress + '/enter' + '?telegram_id=' + telegram_id
+        Enter() {
+            let url = this.CurrentAddress + '/api/v1/enter'
 
-            axios.get(url).then(response => {
+            this.entering = true
+
+            axios.post(url).then(response => {
                 console.log(response.data)
                 this.game_data = response.data
+                this.entering = false
+
+                if (BigInt(response.data.offline_earnings?.coins ?? 0) > 0n) {
+                    this.ShowOfflineEarnings(response.data.offline_earnings)
+                }
+
+                this.LoadShop()
+                this.LoadTasks()
             }).catch(error => {
+                this.entering = false
                 this.ShowError(error.response.data)
             })
         },
 
-        Click(e, telegram_id, card_id) {
-            let card = this.game_data.cards[card_id]
-            if (Date.now() / 1000 < card.next_click) return
+        Click(e, card_id) {
+            let card = this.game_data.cards[card_id],
+                skip_timeout = Date.now() / 1000 < card.next_click
 
-            let url = this.CurrentAddress + '/click' + '?telegram_id=' + telegram_id + '&card_id=' + card_id
+            if (skip_timeout && !(this.game_data.effects?.skip_timeouts > 0)) return
 
-            axios.get(url).then(response => {
+            if (this.Send('click', {card_id: card_id, skip_timeout: skip_timeout}, e)) return
+
+            let url = this.CurrentAddress + '/api/v1/click'
+
+            axios.post(url, {card_id: card_id, skip_timeout: skip_timeout, compact: true}).then(response => {
+                console.log(response.data)
+                this.ApplyDiff(response.data)
+                this.PopEffect(e)
+            }).catch(error => {
+                this.ShowError(error.response.data)
+            })
+        },
+
+        BuyCard(e, card_id, count = 1) {
+            let url = this.CurrentAddress + '/api/v1/buy',
+                data = count === 'max' ? {card_id: card_id, max: true} : {card_id: card_id, count: count}
+
+            if (this.Send('buy', data, e)) return
+
+            axios.post(url, {...data, compact: true}).then(response => {
+                console.log(response.data)
+                this.ApplyDiff(response.data)
+                this.PopEffect(e)
+            }).catch(error => {
+                this.ShowError(error.response.data)
+            })
+        },
+
+        Reset(e) {
+            let url = this.CurrentAddress + '/api/v1/reset'
+
+            if (this.Send('reset', null, e)) return
+
+            axios.post(url).then(response => {
                 console.log(response.data)
                 this.game_data = response.data
                 this.PopEffect(e)
@@ -61,10 +112,21 @@ let Application = Vue.createApp({
             })
         },
 
-        BuyCard(e, telegram_id, card_id) {
-            let url = this.CurrentAddress + '/buy' + '?telegram_id=' + telegram_id + '&card_id=' + card_id
+        LoadShop() {
+            let url = this.CurrentAddress + '/api/v1/shop'
 
             axios.get(url).then(response => {
+                console.log(response.data)
+                this.shop_data = response.data
+            }).catch(error => {
+                this.ShowError(error.response.data)
+            })
+        },
+
+        BuyShopItem(e, item_id) {
+            let url = this.CurrentAddress + '/api/v1/shop/buy'
+
+            axios.post(url, {item_id: item_id}).then(response => {
                 console.log(response.data)
                 this.game_data = response.data
                 this.PopEffect(e)
@@ -73,27 +135,121 @@ let Application = Vue.createApp({
             })
         },
 
-        Reset(e, telegram_id) {
-            let url = this.CurrentAddress + '/reset' + '?telegram_id=' + telegram_id
+        LoadTasks() {
+            let url = this.CurrentAddress + '/api/v1/tasks'
 
             axios.get(url).then(response => {
+                console.log(response.data)
+                this.tasks_data = response.data
+            }).catch(error => {
+                this.ShowError(error.response.data)
+            })
+        },
+
+        ClaimTask(e, task_id) {
+            let url = this.CurrentAddress + '/api/v1/tasks/claim'
+
+            axios.post(url, {task_id: task_id}).then(response => {
                 console.log(response.data)
                 this.game_data = response.data
                 this.PopEffect(e)
+                this.LoadTasks()
             }).catch(error => {
                 this.ShowError(error.response.data)
             })
         },
 
+        OpenChannel(channel) {
+            window.Telegram?.WebApp?.openTelegramLink('https://t.me/' + channel.replace('@', ''))
+        },
+
+        // websocket methods
+
+        Connect() {
+            let url = this.CurrentAddress.replace(/^http/, 'ws') + '/api/v1/ws?init_data=' +
+                encodeURIComponent(window.Telegram?.WebApp?.initData ?? '')
+
+            this.socket = new WebSocket(url)
+
+            this.socket.onmessage = event => {
+                this.OnSocketMessage(JSON.parse(event.data))
+            }
+
+            this.socket.onclose = () => {
+                this.socket = null
+                setTimeout(() => this.Connect(), 3000)
+            }
+        },
+
+        Send(type, data, e) {
+            if (this.socket?.readyState !== WebSocket.OPEN) return false
+
+            let id = String(++this.socket_seq)
+
+            this.socket_pending[id] = e
+            this.socket.send(JSON.stringify({id: id, type: type, data: data}))
+
+            return true
+        },
+
+        OnSocketMessage(msg) {
+            console.log(msg)
+
+            let e = this.socket_pending[msg.id]
+            delete this.socket_pending[msg.id]
+
+            switch (msg.type) {
+                case 'state':
+                    this.game_data = msg.data
+                    break
+                case 'diff':
+                    this.ApplyDiff(msg.data)
+                    break
+                case 'ready':
+                    this.StartPercentCalculation()
+                    break
+                case 'error':
+                    this.ShowError(msg.data)
+                    return
+            }
+
+            if (e) this.PopEffect(e)
+        },
+
+        ApplyDiff(diff) {
+            let {cards, effects, ...totals} = diff
+
+            // the whole game is on its way
+            if (this.entering || !this.game_data) return
+
+            // the diff is already applied
+            if (diff.version <= this.game_data.version) return
+
+            // some diffs are missed, the whole game is needed
+            if (diff.version > this.game_data.version + 1) return this.Enter()
+
+            Object.assign(this.game_data, totals)
+            Object.assign(this.game_data.cards, cards ?? {})
+
+            if (effects) this.game_data.effects = effects
+        },
+
         // non-rest methods
 
         ShowError(err) {
-            this.error = err
+            this.error = err?.error ?? err
             setTimeout(() => {
                 this.error = null
             }, 2500)
         },
 
+        ShowOfflineEarnings(earnings) {
+            this.offline_earnings = earnings
+            setTimeout(() => {
+                this.offline_earnings = null
+            }, 5000)
+        },
+
         PopEffect: function (e) {
             for (let i = 0; i < 5; i++) {
                 this.createParticle(e.clientX, e.clientY);
@@ -125,6 +281,9 @@ let Application = Vue.createApp({
         },
 
         FormatNumber(num) {
+            // the coin amounts come as decimal strings, they may not fit in the javascript numbers
+            num = Number(num)
+
             const units = [
                 {value: 1e45, suffix: ' ???'},
                 {value: 1e42, suffix: ' TRE'},
@@ -151,6 +310,10 @@ let Application = Vue.createApp({
             return num.toString();
         },
 
+        CanAfford(price, coins) {
+            return BigInt(price) <= BigInt(coins)
+        },
+
         CalculatePercentage(last_click, next_click) {
             if (next_click === 0 || last_click === 0) return 100
 
@@ -201,7 +364,10 @@ let Application = Vue.createApp({
 
         this.telegram_data = {...window.Telegram?.WebApp?.initDataUnsafe}
 
-        this.Enter(this.TelegramID)
+        axios.defaults.headers.common['X-Telegram-Init-Data'] = window.Telegram?.WebApp?.initData ?? ''
+
+        this.Enter()
+        this.Connect()
 
         let updater = setInterval(() => {
             this.StartPercentCalculation()